@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// like lts, work or legacy_app
+var aliasNameRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+// SetAlias defines a name that can be used anywhere a version is accepted
+// (like in .php-version files, or with SetDefaultVersion). The target is
+// either a requirement (like 8.1, ^8.2, or 8.3-fpm) or the path of a PHP
+// installation (like /opt/php/custom), which is registered with RegisterPath
+// when it is not already known. A flavor can be appended to the alias when
+// using it (like lts-fpm). The alias is saved in the configuration; an empty
+// target removes it.
+func (s *PHPStore) SetAlias(name, target string) error {
+	s.load()
+	name = strings.TrimSpace(name)
+	target = strings.TrimSpace(target)
+	if !aliasNameRegexp.MatchString(name) {
+		return errors.Errorf("invalid alias name %q: it must start with a letter and only contain letters, digits, dots, underscores, and dashes", name)
+	}
+	if _, flavor := splitFlavor(name); flavor != "" {
+		return errors.Errorf("invalid alias name %q: it cannot end with a flavor", name)
+	}
+	if isAliasPath(target) {
+		path, err := expandPath(target)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		target = path
+		s.mu.RLock()
+		v := s.versionAtPath(target)
+		s.mu.RUnlock()
+		if v == nil {
+			if _, err := s.RegisterPath(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if target != "" && !isAliasPath(target) {
+		requirement, _ := splitFlavor(target)
+		if _, ok := s.aliases[requirement]; ok {
+			return errors.Errorf("the target of alias %q cannot be another alias (%s)", name, requirement)
+		}
+		if s.matchInstalledVersion(target) == nil {
+			return errors.Errorf("no installed PHP version matches %q", target)
+		}
+	}
+	if err := s.updateConfig(func(c *config) {
+		c.Aliases = setAlias(c.Aliases, name, target)
+	}); err != nil {
+		return err
+	}
+	s.aliases = setAlias(s.aliases, name, target)
+	return nil
+}
+
+// Aliases returns the aliases defined with SetAlias, with their target
+func (s *PHPStore) Aliases() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	aliases := make(map[string]string, len(s.aliases))
+	for name, target := range s.aliases {
+		aliases[name] = target
+	}
+	return aliases
+}
+
+// resolveAlias returns the requirement an alias stands for (keeping the
+// flavor it is used with, like lts-fpm), or the installation path of a path
+// alias; other requirements are returned as is
+func (s *PHPStore) resolveAlias(requirement string) (string, string) {
+	name, flavor := splitFlavor(requirement)
+	target, ok := s.aliases[name]
+	if !ok {
+		// custom names of phpbrew builds are aliases as well
+		return requirement, s.phpbrewAlias(name)
+	}
+	if isAliasPath(target) {
+		return requirement, target
+	}
+	if flavor != "" {
+		// the flavor of the alias wins over the one of its target
+		target, _ = splitFlavor(target)
+	}
+	return withFlavor(target, flavor), ""
+}
+
+// bestVersionForAliasPath returns the version of a path alias (see
+// bestVersion)
+func (s *PHPStore) bestVersionForAliasPath(requirement, path, source string) (*Version, string, *Warning, error) {
+	_, flavor := splitFlavor(requirement)
+	v := s.versionAtPath(path)
+	if v == nil {
+		return s.unsatisfiedVersion(&Warning{Kind: WarningVersionNotAvailable, Requested: requirement, Source: source})
+	}
+	if !v.SupportsFlavor(flavor) {
+		return s.unsatisfiedVersion(&Warning{Kind: WarningFlavorNotAvailable, Requested: requirement, Source: source})
+	}
+	return v, source, nil, nil
+}
+
+// matchAliasedRequirement is like matchRequirement, but resolves aliases; the
+// version of a path alias is an exact match
+func (s *PHPStore) matchAliasedRequirement(v *Version, requirement string) (bool, bool, bool) {
+	requirement, path := s.resolveAlias(requirement)
+	if path == "" {
+		return matchRequirement(v, requirement)
+	}
+	_, flavor := splitFlavor(requirement)
+	return v == s.versionAtPath(path), true, v.SupportsFlavor(flavor)
+}
+
+// versionAtPath returns the version installed at the given path (the
+// installation directory, its bin/ directory, or the binary)
+func (s *PHPStore) versionAtPath(path string) *Version {
+	for i := len(s.versions) - 1; i >= 0; i-- {
+		v := s.versions[i]
+		if v.Path == path || v.PHPPath == path || v.FPMPath == path || filepath.Dir(v.binary()) == path {
+			return v
+		}
+	}
+	return nil
+}
+
+// isAliasPath returns whether the target of an alias is a path instead of a
+// requirement
+func isAliasPath(target string) bool {
+	return strings.ContainsAny(target, `/\`) || strings.HasPrefix(target, "~")
+}
+
+func setAlias(aliases map[string]string, name, target string) map[string]string {
+	if target == "" {
+		delete(aliases, name)
+		return aliases
+	}
+	if aliases == nil {
+		aliases = make(map[string]string)
+	}
+	aliases[name] = target
+	return aliases
+}
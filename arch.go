@@ -0,0 +1,190 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ArchUniversal is the architecture of macOS universal binaries (see Archs)
+const ArchUniversal = "universal"
+
+// binaryArch returns the CPU architecture of an executable (like x86_64,
+// arm64, or universal) by reading its headers, or an empty string when the
+// format is not supported
+func binaryArch(path string) string {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		switch f.Machine {
+		case elf.EM_X86_64:
+			return "x86_64"
+		case elf.EM_AARCH64:
+			return "arm64"
+		case elf.EM_386:
+			return "i386"
+		}
+		return strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_"))
+	}
+	if f, err := macho.OpenFat(path); err == nil {
+		f.Close()
+		return ArchUniversal
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		return machoArch(f.Cpu)
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		switch f.Machine {
+		case pe.IMAGE_FILE_MACHINE_AMD64:
+			return "x86_64"
+		case pe.IMAGE_FILE_MACHINE_ARM64:
+			return "arm64"
+		case pe.IMAGE_FILE_MACHINE_I386:
+			return "i386"
+		}
+	}
+	return ""
+}
+
+// normalizeArch makes architecture names reported by different tools comparable
+func normalizeArch(arch string) string {
+	switch arch = strings.ToLower(arch); arch {
+	case "amd64", "x64", "x86-64":
+		return "x86_64"
+	case "aarch64", "arm64e":
+		return "arm64"
+	case "386", "i686", "x86":
+		return "i386"
+	}
+	return arch
+}
+
+var (
+	nativeArchOnce sync.Once
+	nativeArch     string
+)
+
+// hostArch returns the native CPU architecture of the machine
+func hostArch() string {
+	nativeArchOnce.Do(func() {
+		nativeArch = normalizeArch(runtime.GOARCH)
+		if runtime.GOOS == "darwin" && nativeArch == "x86_64" {
+			// the current process might be translated by Rosetta
+			out, err := exec.Command("sysctl", "-n", "sysctl.proc_translated").Output()
+			if err == nil && string(bytes.TrimSpace(out)) == "1" {
+				nativeArch = "arm64"
+			}
+		}
+	})
+	return nativeArch
+}
+
+// inspectBinary records the properties of the PHP binary read from its headers
+func (v *Version) inspectBinary() {
+	v.detectArch()
+	v.Libc = binaryLibc(v.binary())
+	v.ContainerRuntime, v.Container = wrapperContainer(v.binary())
+	v.applyPHPBrewVariants()
+	if v.Compiler == "" {
+		v.Compiler = binaryCompiler(v.binary())
+	}
+}
+
+// detectArch records the architecture of the PHP binary, and whether it runs
+// emulated (like x86_64 binaries under Rosetta on Apple Silicon)
+func (v *Version) detectArch() {
+	if arch := binaryArch(v.binary()); arch != "" {
+		v.Arch = arch
+	}
+	v.Arch = normalizeArch(v.Arch)
+	v.Archs = nil
+	if v.Arch == ArchUniversal {
+		v.Archs = universalArchs(v.binary())
+	}
+	v.Emulated = v.Arch != "" && !v.SupportsArch(hostArch())
+}
+
+// SupportsArch returns true if the version runs natively on the given
+// architecture (like arm64); universal binaries support the architectures of
+// their slices (all of them when unknown)
+func (v *Version) SupportsArch(arch string) bool {
+	arch = normalizeArch(arch)
+	if v.Arch != ArchUniversal {
+		return v.Arch == arch
+	}
+	if len(v.Archs) == 0 {
+		return true
+	}
+	for _, a := range v.Archs {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// universalArchs returns the architectures of the slices of a macOS universal
+// binary (like arm64 and x86_64)
+func universalArchs(path string) []string {
+	f, err := macho.OpenFat(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var archs []string
+	for _, arch := range f.Arches {
+		archs = append(archs, machoArch(arch.Cpu))
+	}
+	sort.Strings(archs)
+	return archs
+}
+
+// machoArch returns the architecture of a Mach-O CPU type
+func machoArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuAmd64:
+		return "x86_64"
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.Cpu386:
+		return "i386"
+	}
+	return normalizeArch(strings.TrimPrefix(cpu.String(), "Cpu"))
+}
+
+// nativeSliceCommand returns the command running the native slice of a
+// universal binary: when the current process is emulated (like a x86_64
+// build running under Rosetta), macOS runs the slice of the emulated
+// architecture instead
+func (v *Version) nativeSliceCommand(path string, args []string) (string, []string) {
+	if runtime.GOOS != "darwin" || v.Arch != ArchUniversal || normalizeArch(runtime.GOARCH) == hostArch() || !v.SupportsArch(hostArch()) {
+		return path, args
+	}
+	return "/usr/bin/arch", append([]string{"-" + hostArch(), path}, args...)
+}
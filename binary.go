@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bytes"
+	"debug/elf"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// C libraries that Linux PHP binaries can be linked against (see Version.Libc)
+const (
+	LibcGlibc  = "glibc"
+	LibcMusl   = "musl"
+	LibcStatic = "static"
+)
+
+var (
+	// the X-Powered-By header is built at compile time in PHP binaries
+	poweredByRegexp = regexp.MustCompile(`X-Powered-By: PHP/(\d+\.\d+\.\d+[0-9A-Za-z.+~-]*)`)
+	// the FileVersion of Windows VERSIONINFO resources is stored in UTF-16
+	fileVersionRegexp = regexp.MustCompile("F\x00i\x00l\x00e\x00V\x00e\x00r\x00s\x00i\x00o\x00n\x00(?:\x00\x00)+((?:[0-9]\x00)+\\.\x00(?:[0-9]\x00)+\\.\x00(?:[0-9]\x00)+)")
+)
+
+// like API20230831,NTS or API20230831,TS,debug: the module build ID of the
+// Zend Engine tells whether a binary is a thread-safe and a debug build
+var buildIDRegexp = regexp.MustCompile(`API\d{8},(N?TS)(,debug)?`)
+
+// versionFromBinary extracts the PHP version from a PHP binary without
+// running it; it returns an empty string when the version cannot be found
+func versionFromBinary(path string) string {
+	return scanBinary(path, versionFromData)
+}
+
+// buildFromBinary extracts the PHP version and the build flags from a PHP
+// binary without running it; ok is false when they cannot all be found (like
+// for Windows binaries, whose engine is in a separate DLL)
+func buildFromBinary(path string) (version string, threadSafe, debugBuild, ok bool) {
+	buildID := false
+	scanBinary(path, func(data []byte) string {
+		if m := buildIDRegexp.FindSubmatch(data); m != nil && !buildID {
+			buildID = true
+			threadSafe = string(m[1]) == "TS"
+			debugBuild = len(m[2]) > 0
+		}
+		if version == "" {
+			version = versionFromData(data)
+		}
+		if version != "" && buildID {
+			return version
+		}
+		return ""
+	})
+	return version, threadSafe, debugBuild, version != "" && buildID
+}
+
+// versionFromData extracts the PHP version from a chunk of a PHP binary
+func versionFromData(data []byte) string {
+	if m := poweredByRegexp.FindSubmatch(data); m != nil {
+		return string(m[1])
+	}
+	if m := fileVersionRegexp.FindSubmatch(data); m != nil {
+		return string(bytes.ReplaceAll(m[1], []byte{0}, nil))
+	}
+	return ""
+}
+
+// scanBinary returns the first non-empty result of find on the contents of a
+// binary, or an empty string
+func scanBinary(path string, find func(data []byte) string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	// binaries can be large, so read them by chunks, keeping the end of the
+	// previous chunk in case a match is split between two chunks
+	const overlap = 256
+	buf := make([]byte, 1<<20)
+	kept := 0
+	for {
+		n, err := io.ReadFull(f, buf[kept:])
+		data := buf[:kept+n]
+		if found := find(data); found != "" {
+			return found
+		}
+		if err != nil || len(data) <= overlap {
+			return ""
+		}
+		kept = copy(buf, data[len(data)-overlap:])
+	}
+}
+
+// binaryLibc returns the C library a Linux binary is linked against (see
+// Libc* constants), or an empty string when it cannot be determined (like for
+// binaries that are not ELF executables)
+func binaryLibc(path string) string {
+	f, err := elf.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		interp, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return ""
+		}
+		// like /lib/ld-musl-x86_64.so.1 or /lib64/ld-linux-x86-64.so.2
+		if strings.Contains(string(interp), "musl") {
+			return LibcMusl
+		}
+		return LibcGlibc
+	}
+	return LibcStatic
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"regexp"
+	"strings"
+)
+
+// versionedNamePattern returns the pattern of the versioned names of the
+// binaries (or directories) starting with prefix, with all naming schemes
+// used by installers: php8, php83, php8.3, php-8.3, php8.3.6, or php8.4RC1
+// (for the php prefix); it is shared by all sources so that a naming scheme
+// supported for one of them is supported for the others as well
+func versionedNamePattern(prefix string) string {
+	return regexp.QuoteMeta(prefix) + `-?\d+(?:\.\d+){0,2}(?:(?:RC|BETA|rc|beta)\d*)?`
+}
+
+// versionedNameRegexp returns a regexp matching the versioned names starting
+// with prefix (see versionedNamePattern)
+func versionedNameRegexp(prefix string) *regexp.Regexp {
+	return regexp.MustCompile("^" + versionedNamePattern(prefix) + "$")
+}
+
+var (
+	// like php8.3 (but not php, php-config8.3, or phpize8.3)
+	phpVersionedName = versionedNameRegexp("php")
+	// like lsphp83
+	lsphpVersionedName = versionedNameRegexp("lsphp")
+)
+
+var (
+	phpFPMVersionedName = versionedNameRegexp("php-fpm")
+	phpCGIVersionedName = versionedNameRegexp("php-cgi")
+)
+
+// isPHPBinaryName returns whether name is the name of a PHP binary (like php,
+// php-fpm8.3, php-cgi, or php.exe on Windows), used to ignore other changes
+// in the directories of the PATH
+func isPHPBinaryName(name string) bool {
+	// names are case insensitive on Windows and macOS
+	name = strings.ToLower(name)
+	for _, ext := range []string{".exe", ".bat", ".cmd"} {
+		if strings.HasSuffix(name, ext) {
+			name = name[:len(name)-len(ext)]
+			break
+		}
+	}
+	switch name {
+	case "php", "php-fpm", "php-cgi", "lsphp", "frankenphp":
+		return true
+	}
+	return phpVersionedName.MatchString(name) || phpFPMVersionedName.MatchString(name) || phpCGIVersionedName.MatchString(name) || lsphpVersionedName.MatchString(name)
+}
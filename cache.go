@@ -0,0 +1,444 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func (s *PHPStore) cachePath() string {
+	if s.cacheFile == "" {
+		return s.legacyCachePath()
+	}
+	return s.cacheFile
+}
+
+// legacyCachePath is the location of the cache in the configuration
+// directory
+func (s *PHPStore) legacyCachePath() string {
+	return filepath.Join(s.configDir, "php_versions.json")
+}
+
+// defaultCachePath returns the location of the cache: the XDG cache
+// directory on Linux when XDG_CACHE_HOME is defined, so that the cache lives
+// with other caches, or the configuration directory otherwise. The name of
+// the cache in the XDG cache directory is keyed by the configuration
+// directory (like ~/.cache/symfony/php_versions-1a2b3c4d5e6f7a8b.json), as
+// stores with different configurations must not share their cache.
+func (s *PHPStore) defaultCachePath() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); runtime.GOOS == "linux" && dir != "" && filepath.IsAbs(dir) {
+		configDir, err := filepath.Abs(s.configDir)
+		if err != nil {
+			return s.legacyCachePath()
+		}
+		sum := sha256.Sum256([]byte(configDir))
+		return filepath.Join(dir, "symfony", fmt.Sprintf("php_versions-%x.json", sum[:8]))
+	}
+	return s.legacyCachePath()
+}
+
+// moveLegacyCache moves the cache of the configuration directory to the
+// cache location, if they are different
+func (s *PHPStore) moveLegacyCache() {
+	cache, legacy := s.cachePath(), s.legacyCachePath()
+	if cache == legacy || s.noCacheWrite || s.memoryCache {
+		return
+	}
+	if _, err := os.Stat(cache); !os.IsNotExist(err) {
+		return
+	}
+	contents, err := os.ReadFile(legacy)
+	if err != nil {
+		return
+	}
+	s.log("Moving the cache from %s to %s", legacy, cache)
+	if err := os.MkdirAll(filepath.Dir(cache), 0755); err != nil {
+		return
+	}
+	if err := os.WriteFile(cache, contents, 0644); err == nil {
+		os.Remove(legacy)
+	}
+}
+
+// memoryCaches are the caches of the stores using WithMemoryCache, by cache
+// path, so that they are shared by the stores of the current process
+var memoryCaches = struct {
+	sync.Mutex
+	entries map[string]memoryCache
+}{entries: make(map[string]memoryCache)}
+
+type memoryCache struct {
+	contents []byte
+	modTime  time.Time
+}
+
+// cacheModTime returns when the cache was last written, and false when
+// there is no cache
+func (s *PHPStore) cacheModTime() (time.Time, bool) {
+	if s.memoryCache {
+		memoryCaches.Lock()
+		defer memoryCaches.Unlock()
+		entry, ok := memoryCaches.entries[s.cachePath()]
+		return entry.modTime, ok
+	}
+	fi, err := os.Stat(s.cachePath())
+	if err != nil {
+		return time.Time{}, false
+	}
+	return fi.ModTime(), true
+}
+
+// removeCache removes the cache so that versions are discovered again; when
+// the cache cannot be written (see WithNoCacheWrite), it is ignored instead
+func (s *PHPStore) removeCache() {
+	switch {
+	case s.noCacheWrite:
+		s.ignoreCache = true
+	case s.memoryCache:
+		memoryCaches.Lock()
+		delete(memoryCaches.entries, s.cachePath())
+		memoryCaches.Unlock()
+	default:
+		os.Remove(s.cachePath())
+	}
+}
+
+// SchemaVersion is the version of the JSON format of the cache and of
+// MarshalReport; it must be increased (with a migration in cacheMigrations)
+// when the format changes, like when new fields are added to Version, but only
+// once per release
+const SchemaVersion = 2
+
+// cacheFile is the JSON document of the cache and of MarshalReport:
+//
+//	{
+//	    "schema_version": <SchemaVersion>,
+//	    "versions": [
+//	        {"version": "8.3.8", "path": "/usr", "php_path": "/usr/bin/php8.3", ...}
+//	    ]
+//	}
+//
+// Versions are serialized with the JSON keys of the Version fields, sorted
+// from the oldest to the most recent version. Keys are never renamed nor
+// removed without increasing the schema version; keys of empty optional
+// fields are omitted.
+type cacheFile struct {
+	SchemaVersion int      `json:"schema_version"`
+	Versions      versions `json:"versions"`
+}
+
+// MarshalReport returns the versions of the store as a JSON document, in the
+// same format as the cache (see SchemaVersion); external tools should check
+// the schema version before reading the versions.
+func (s *PHPStore) MarshalReport() ([]byte, error) {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	contents, err := s.marshalVersions()
+	return contents, errors.WithStack(err)
+}
+
+func (s *PHPStore) marshalVersions() ([]byte, error) {
+	vs := s.versions
+	if vs == nil {
+		vs = versions{}
+	}
+	return json.MarshalIndent(cacheFile{
+		SchemaVersion: SchemaVersion,
+		Versions:      vs,
+	}, "", "    ")
+}
+
+// cacheMigration migrates cached versions from a schema version to the next
+// one
+type cacheMigration struct {
+	// reprobe is true when all versions must be probed again (like when
+	// fields are added to Version); versions are only probed once when
+	// migrating through several schema versions
+	reprobe bool
+	// migrate updates the versions, after they were probed again if needed
+	migrate func(s *PHPStore, vs versions) versions
+}
+
+// cacheMigrations migrates cached versions from a schema version (the index
+// plus one) to the next one
+var cacheMigrations = []cacheMigration{
+	// 1 -> 2: versions were stored as a list; versions are probed again to
+	// populate the fields added since then (binary stamps, extensions, ...)
+	{reprobe: true},
+}
+
+// migrateCache migrates cached versions from the given schema version to
+// the last one
+func (s *PHPStore) migrateCache(vs versions, from int) versions {
+	reprobed := false
+	for i, m := range cacheMigrations[from-1:] {
+		s.log("Migrating the cache from schema version %d to %d", from+i, from+i+1)
+		if m.reprobe && !reprobed {
+			vs, _ = s.revalidateVersions(vs, true)
+			reprobed = true
+		}
+		if m.migrate != nil {
+			vs = m.migrate(s, vs)
+		}
+	}
+	return vs
+}
+
+// decodeCache decodes and migrates the cache contents, and returns whether
+// it was migrated
+func (s *PHPStore) decodeCache(contents []byte) (versions, bool, error) {
+	var cache cacheFile
+	if err := json.Unmarshal(contents, &cache); err != nil {
+		// schema version 1 was a list of versions
+		if err := json.Unmarshal(contents, &cache.Versions); err != nil {
+			return nil, false, err
+		}
+		cache.SchemaVersion = 1
+	}
+	if cache.SchemaVersion > SchemaVersion {
+		return nil, false, errors.Errorf("cache schema version %d is not supported", cache.SchemaVersion)
+	}
+	if cache.SchemaVersion < 1 {
+		return nil, false, errors.Errorf("invalid cache schema version %d", cache.SchemaVersion)
+	}
+	if cache.SchemaVersion == SchemaVersion {
+		return cache.Versions, false, nil
+	}
+	return s.migrateCache(cache.Versions, cache.SchemaVersion), true, nil
+}
+
+// writeCache stores the versions on disk; the file is written atomically as
+// other processes might read it concurrently
+func (s *PHPStore) writeCache() {
+	if s.noCacheWrite {
+		return
+	}
+	contents, err := s.marshalVersions()
+	if err != nil {
+		return
+	}
+	cache := s.cachePath()
+	if s.memoryCache {
+		memoryCaches.Lock()
+		memoryCaches.entries[cache] = memoryCache{contents: contents, modTime: time.Now()}
+		memoryCaches.Unlock()
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(cache), 0755); err != nil {
+		return
+	}
+	unlock := s.lockCache(true)
+	defer unlock()
+	tmp := fmt.Sprintf("%s.%d.tmp", cache, os.Getpid())
+	if err := os.WriteFile(tmp, contents, 0644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, cache); err != nil {
+		os.Remove(tmp)
+	}
+}
+
+// refreshInBackground discovers versions again and updates the cache for the
+// next invocations; the versions of the current store are left untouched
+func (s *PHPStore) refreshInBackground() {
+	s.log("Cache is older than %s, refreshing it in the background", s.cacheTTL)
+	s.refreshWg.Add(1)
+	go func() {
+		defer s.refreshWg.Done()
+		fresh := s.newDiscoveryStore()
+		fresh.discover()
+		s.mu.RLock()
+		keepDiscoveryTimes(fresh.versions, s.versions)
+		s.mu.RUnlock()
+		sort.Sort(fresh.versions)
+		fresh.writeCache()
+	}()
+}
+
+// newDiscoveryStore returns an empty store sharing the configuration of the
+// current one (including its loggers, so that background refreshes and Watch
+// are logged), to discover versions without touching the current ones; the
+// lazy discovery and the cache TTL are not shared, as the new store discovers
+// versions right away
+func (s *PHPStore) newDiscoveryStore() *PHPStore {
+	return &PHPStore{
+		configDir:              s.configDir,
+		seen:                   make(map[string]int),
+		discoveryLogFunc:       s.discoveryLogFunc,
+		structuredLogger:       s.structuredLogger,
+		discoveryEvents:        s.discoveryEvents,
+		sources:                s.sources,
+		strict:                 s.strict,
+		preferNative:           s.preferNative,
+		discoveryConcurrency:   s.discoveryConcurrency,
+		workspaceMarkers:       s.workspaceMarkers,
+		releasesURL:            s.releasesURL,
+		report:                 &DiscoveryReport{},
+		registeredPaths:        s.registeredPaths,
+		ignoredPaths:           s.ignoredPaths,
+		ignoredVersions:        s.ignoredVersions,
+		excludedPaths:          s.excludedPaths,
+		scanNetworkFilesystems: s.scanNetworkFilesystems,
+		cacheFile:              s.cacheFile,
+		noCacheWrite:           s.noCacheWrite,
+		memoryCache:            s.memoryCache,
+	}
+}
+
+// keepDiscoveryTimes keeps the discovery time of versions that were already
+// known before a new discovery
+func keepDiscoveryTimes(vs, previous versions) {
+	discoveredAt := make(map[string]time.Time, len(previous))
+	for _, v := range previous {
+		if !v.DiscoveredAt.IsZero() {
+			discoveredAt[v.binary()] = v.DiscoveredAt
+		}
+	}
+	for _, v := range vs {
+		if t, ok := discoveredAt[v.binary()]; ok && t.Before(v.DiscoveredAt) {
+			v.DiscoveredAt = t
+		}
+	}
+}
+
+// WaitForRefresh waits for the background refresh of the cache to be done (see
+// WithCacheTTL), which is useful for short-lived processes
+func (s *PHPStore) WaitForRefresh() {
+	s.refreshWg.Wait()
+}
+
+// revalidateVersions checks concurrently that the binaries of the cached
+// versions still exist and did not change since they were probed. Versions
+// whose binary was removed are dropped, and the ones whose binary changed (like
+// after an in-place upgrade) or whose companion binaries were removed are
+// probed again. When reprobe is true, all
+// versions are probed again, and kept as is when probing fails. It returns the
+// valid versions and whether some versions were dropped or probed again.
+// Versions verified the longest time ago are checked first.
+func (s *PHPStore) revalidateVersions(vs versions, reprobe bool) (versions, bool) {
+	results := make([]*Version, len(vs))
+	changed := make([]bool, len(vs))
+	order := make([]int, len(vs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return vs[order[i]].LastVerifiedAt.Before(vs[order[j]].LastVerifiedAt)
+	})
+	sem := make(chan struct{}, s.discoveryConcurrency)
+	var wg sync.WaitGroup
+	for _, i := range order {
+		v := vs[i]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, v *Version) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fi, err := os.Stat(v.binary())
+			if err != nil {
+				s.log("Removing %s from the cache as it does not exist anymore", v.binary())
+				changed[i] = true
+				return
+			}
+			unchanged := v.BinaryModTime.IsZero() || (fi.ModTime().Equal(v.BinaryModTime) && fi.Size() == v.BinarySize)
+			missing := v.missingCompanions()
+			if !reprobe && unchanged && len(missing) == 0 {
+				results[i] = v
+				return
+			}
+			if reprobe {
+				s.log("Probing %s again as the cache schema changed", v.binary())
+				results[i] = v
+			} else if unchanged {
+				s.log("Probing %s again as %s does not exist anymore", v.binary(), strings.Join(missing, ", "))
+			} else {
+				s.log("%s changed since it was probed, probing it again", v.binary())
+			}
+			changed[i] = true
+			// like php8.3, or php-fpm8.3 for FPM-only installations
+			binName := strings.Replace(strings.TrimSuffix(filepath.Base(v.binary()), ".exe"), "php-fpm", "php", 1)
+			if nv, _ := s.discoverPHP(v.Path, binName); nv != nil {
+				nv.IsSystem = v.IsSystem
+				nv.Source = v.Source
+				nv.DiscoveredAt = v.DiscoveredAt
+				nv.stampBinary()
+				results[i] = nv
+			}
+		}(i, v)
+	}
+	wg.Wait()
+
+	valid := versions{}
+	hasChanged := false
+	for i, v := range results {
+		if v != nil {
+			valid = append(valid, v)
+		}
+		hasChanged = hasChanged || changed[i]
+	}
+	return valid, hasChanged
+}
+
+var errLockBusy = errors.New("lock is held by another process")
+
+// lockCache acquires a lock on the cache (shared for reads, exclusive for
+// writes) as several processes might use it concurrently. When the lock
+// cannot be acquired in a timely manner, the cache is used without it.
+func (s *PHPStore) lockCache(exclusive bool) func() {
+	lock := s.cachePath() + ".lock"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		unlock, err := tryLockFile(lock, exclusive)
+		if err == nil {
+			return unlock
+		}
+		if err != errLockBusy || time.Now().After(deadline) {
+			s.log("Unable to lock %s: %s", lock, err)
+			return func() {}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (s *PHPStore) readCache() ([]byte, error) {
+	if s.memoryCache {
+		memoryCaches.Lock()
+		defer memoryCaches.Unlock()
+		if entry, ok := memoryCaches.entries[s.cachePath()]; ok {
+			return entry.contents, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	unlock := s.lockCache(false)
+	defer unlock()
+	return os.ReadFile(s.cachePath())
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// Command returns the command running the PHP CLI of this version with the
+// given arguments (like a script and its arguments), with the environment of
+// the version (see Env). FrankenPHP binaries run PHP via their php-cli
+// command.
+func (v *Version) Command(ctx context.Context, args ...string) *exec.Cmd {
+	if v.FrankenPHP {
+		args = append([]string{"php-cli"}, args...)
+	}
+	return v.command(ctx, v.PHPPath, args)
+}
+
+// ServerCommand returns the command running the server binary of this
+// version (see ServerPath) with the given arguments, with the environment of
+// the version (see Env):
+//
+//   - FPM is kept in the foreground so that it can be managed by the caller;
+//   - CGI does not restart after a number of requests (PHP_FCGI_MAX_REQUESTS),
+//     as the caller would have to restart it;
+//   - FrankenPHP and the built-in server of the CLI only get the arguments.
+func (v *Version) ServerCommand(ctx context.Context, args ...string) *exec.Cmd {
+	switch v.serverType() {
+	case fpmServer:
+		return v.command(ctx, v.FPMPath, append([]string{"--nodaemonize"}, args...))
+
+	case cgiServer:
+		cmd := v.command(ctx, v.CGIPath, args)
+		cmd.Env = append(cmd.Env, "PHP_FCGI_MAX_REQUESTS=0")
+		return cmd
+
+	case lsapiServer:
+		return v.command(ctx, v.LSAPIPath, args)
+
+	default:
+		return v.command(ctx, v.PHPPath, args)
+	}
+}
+
+func (v *Version) command(ctx context.Context, path string, args []string) *exec.Cmd {
+	path, args = v.nativeSliceCommand(path, args)
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = append(os.Environ(), v.Env()...)
+	return cmd
+}
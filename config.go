@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// config is the user configuration of the store, stored in php_store.json
+// in the configuration directory
+type config struct {
+	// Sources lists the sources used by BestVersionForDir, by order of
+	// precedence (see DefaultSources); sources not listed are disabled
+	Sources []string `json:"sources,omitempty"`
+	// CacheTTL is the maximum age of the versions cache (like "24h") before
+	// it is refreshed in the background (see WithCacheTTL)
+	CacheTTL string `json:"cache_ttl,omitempty"`
+	// Paths lists the PHP installations registered with RegisterPath (like
+	// ~/bin/php); they are probed on each discovery
+	Paths []string `json:"paths,omitempty"`
+	// IgnoredPaths lists the directories or binaries ignored by discovery
+	// (see IgnorePath), and IgnoredVersions the ignored versions (like 7.4
+	// or 7.4.3, see IgnoreVersion)
+	IgnoredPaths    []string `json:"ignored_paths,omitempty"`
+	IgnoredVersions []string `json:"ignored_versions,omitempty"`
+	// DefaultVersion is the version used when projects do not require any
+	// (see SetDefaultVersion)
+	DefaultVersion string `json:"default_version,omitempty"`
+	// ExcludedPaths lists glob patterns of directories skipped by discovery
+	// (like /mnt/*), and ScanNetworkFilesystems disables the skipping of
+	// network filesystems (see WithExcludedPaths and WithNetworkFilesystems)
+	ExcludedPaths          []string `json:"excluded_paths,omitempty"`
+	ScanNetworkFilesystems bool     `json:"scan_network_filesystems,omitempty"`
+	// Aliases maps alias names (like lts) to a requirement (like 8.1) or to
+	// the path of a PHP installation (see SetAlias)
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+func (s *PHPStore) configPath() string {
+	return filepath.Join(s.configDir, "php_store.json")
+}
+
+func (s *PHPStore) readConfig() (*config, error) {
+	contents, err := os.ReadFile(s.configPath())
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	if err := json.Unmarshal(contents, &c); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse %s", s.configPath())
+	}
+	return &c, nil
+}
+
+// updateConfig applies the given change to the configuration file
+func (s *PHPStore) updateConfig(update func(c *config)) error {
+	c, err := s.readConfig()
+	if os.IsNotExist(errors.Cause(err)) {
+		c = &config{}
+	} else if err != nil {
+		return err
+	}
+	update(c)
+	s.resolutions.reset()
+	contents, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.MkdirAll(s.configDir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(s.configPath(), contents, 0644))
+}
+
+func (s *PHPStore) loadConfig() {
+	s.resolutions.reset()
+	c, err := s.readConfig()
+	if err != nil {
+		if !os.IsNotExist(errors.Cause(err)) {
+			s.log("Unable to use the configuration: %s", err)
+		}
+		return
+	}
+	if c.Sources != nil {
+		s.sources = s.validateSources(c.Sources)
+	}
+	s.registeredPaths = expandPaths(c.Paths)
+	s.ignoredPaths = expandPaths(c.IgnoredPaths)
+	s.ignoredVersions = c.IgnoredVersions
+	s.defaultVersion = c.DefaultVersion
+	s.excludedPaths = expandPaths(c.ExcludedPaths)
+	s.scanNetworkFilesystems = c.ScanNetworkFilesystems
+	s.aliases = c.Aliases
+	if c.CacheTTL != "" {
+		if ttl, err := time.ParseDuration(c.CacheTTL); err == nil {
+			s.cacheTTL = ttl
+		} else {
+			s.log("Unable to parse the cache TTL %q: %s", c.CacheTTL, err)
+		}
+	}
+}
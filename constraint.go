@@ -0,0 +1,318 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
+)
+
+// constraint is a single comparison against a version (like ">=8.1")
+type constraint struct {
+	op string
+	v  *version.Version
+}
+
+func (c constraint) check(v *version.Version) bool {
+	switch c.op {
+	case ">":
+		return v.GreaterThan(c.v)
+	case ">=":
+		return v.GreaterThanOrEqual(c.v)
+	case "<":
+		return v.LessThan(c.v)
+	case "<=":
+		return v.LessThanOrEqual(c.v)
+	case "!=":
+		return !v.Equal(c.v)
+	default:
+		return v.Equal(c.v)
+	}
+}
+
+// constraints is a Composer-like version constraint: a list of alternatives
+// (separated by ||), each one being a list of constraints that must all match
+type constraints struct {
+	raw          string
+	alternatives [][]constraint
+}
+
+func (cs *constraints) check(v *version.Version) bool {
+	for _, and := range cs.alternatives {
+		ok := true
+		for _, c := range and {
+			if !c.check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs *constraints) String() string {
+	return cs.raw
+}
+
+// isConstraint returns true if the requirement is a constraint expression
+// (like "^8.2" or ">=8.1 <8.4") instead of a simple version prefix
+func isConstraint(requirement string) bool {
+	return strings.ContainsAny(strings.TrimSpace(requirement), "<>=!^~*|, ")
+}
+
+// parseConstraints parses a Composer-like constraint expression.
+// Supported syntaxes: comparisons (>=8.1, <8.4, !=8.2.1), caret (^8.2),
+// tilde (~8.2), wildcards (8.*), hyphen ranges (8.1 - 8.3), AND (space or
+// comma) and OR (||). A bare version (8.1) matches all its patch versions.
+func parseConstraints(expr string) (*constraints, error) {
+	cs := &constraints{raw: strings.TrimSpace(expr)}
+	for _, alternative := range strings.Split(strings.ReplaceAll(cs.raw, "||", "|"), "|") {
+		var and []constraint
+		tokens := tokenizeConstraint(alternative)
+		if len(tokens) == 0 {
+			return nil, errors.Errorf("invalid constraint %q: empty alternative", expr)
+		}
+		for i := 0; i < len(tokens); i++ {
+			// hyphen range
+			if i+2 < len(tokens) && tokens[i+1] == "-" {
+				lower, err := parseConstraint(">=" + tokens[i])
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid constraint %q", expr)
+				}
+				upper, err := parseConstraint("<=" + tokens[i+2])
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid constraint %q", expr)
+				}
+				and = append(and, lower...)
+				and = append(and, upper...)
+				i += 2
+				continue
+			}
+			c, err := parseConstraint(tokens[i])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid constraint %q", expr)
+			}
+			and = append(and, c...)
+		}
+		cs.alternatives = append(cs.alternatives, and)
+	}
+	return cs, nil
+}
+
+// tokenizeConstraint splits an AND group into tokens, making sure that
+// operators separated from their version by spaces (">= 8.1") are kept together
+func tokenizeConstraint(expr string) []string {
+	var tokens []string
+	pending := ""
+	for _, field := range strings.FieldsFunc(expr, func(r rune) bool { return r == ' ' || r == ',' || r == '\t' }) {
+		if strings.Trim(field, "<>=!^~") == "" && field != "-" {
+			pending += field
+			continue
+		}
+		tokens = append(tokens, pending+field)
+		pending = ""
+	}
+	if pending != "" {
+		tokens = append(tokens, pending)
+	}
+	return tokens
+}
+
+// parseConstraint converts a single constraint token to one or more comparisons
+func parseConstraint(token string) ([]constraint, error) {
+	// stability flags are not relevant for PHP versions
+	if pos := strings.IndexByte(token, '@'); pos != -1 {
+		token = token[:pos]
+	}
+	if token == "*" {
+		return nil, nil
+	}
+
+	op := ""
+	for _, prefix := range []string{">=", "<=", "!=", "==", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(token, prefix) {
+			op = prefix
+			token = strings.TrimSpace(token[len(prefix):])
+			break
+		}
+	}
+	token = strings.TrimPrefix(token, "v")
+
+	segments := strings.Split(token, ".")
+	// wildcards (8.* or 8.1.*) are equivalent to a bare version prefix
+	if segments[len(segments)-1] == "*" || segments[len(segments)-1] == "x" {
+		if op != "" {
+			return nil, errors.Errorf("wildcard %q cannot be combined with an operator", token)
+		}
+		segments = segments[:len(segments)-1]
+	}
+	nums := make([]int, len(segments))
+	for i, segment := range segments {
+		n, err := strconv.Atoi(segment)
+		if err != nil || n < 0 || len(segments) > 3 {
+			return nil, errors.Errorf("%q is not a valid version", token)
+		}
+		nums[i] = n
+	}
+	if len(nums) == 0 {
+		return nil, errors.Errorf("%q is not a valid version", token)
+	}
+
+	switch op {
+	case "^":
+		// ^8.2 means >=8.2.0 <9.0.0
+		return []constraint{
+			{">=", makeVersion(nums...)},
+			{"<", makeVersion(nums[0] + 1)},
+		}, nil
+	case "~":
+		// ~8.2 means >=8.2.0 <9.0.0, ~8.2.1 means >=8.2.1 <8.3.0
+		if len(nums) == 1 {
+			return []constraint{{">=", makeVersion(nums...)}, {"<", makeVersion(nums[0] + 1)}}, nil
+		}
+		upper := append([]int{}, nums[:len(nums)-1]...)
+		upper[len(upper)-1]++
+		return []constraint{{">=", makeVersion(nums...)}, {"<", makeVersion(upper...)}}, nil
+	case "", "=", "==":
+		if len(nums) == 3 {
+			return []constraint{{"==", makeVersion(nums...)}}, nil
+		}
+		// a partial version matches all versions sharing the same prefix
+		upper := append([]int{}, nums...)
+		upper[len(upper)-1]++
+		return []constraint{{">=", makeVersion(nums...)}, {"<", makeVersion(upper...)}}, nil
+	case "<=", ">":
+		// <=8.1 includes all 8.1 patch versions, >8.1 excludes them
+		if len(nums) < 3 {
+			upper := append([]int{}, nums...)
+			upper[len(upper)-1]++
+			if op == "<=" {
+				return []constraint{{"<", makeVersion(upper...)}}, nil
+			}
+			return []constraint{{">=", makeVersion(upper...)}}, nil
+		}
+	}
+	return []constraint{{op, makeVersion(nums...)}}, nil
+}
+
+func makeVersion(nums ...int) *version.Version {
+	segments := []string{"0", "0", "0"}
+	for i, n := range nums {
+		segments[i] = strconv.Itoa(n)
+	}
+	return version.Must(version.NewVersion(strings.Join(segments, ".")))
+}
+
+// BestVersionForConstraint returns the most recent installed version matching
+// the given constraint expression (like ^8.2 or >=8.1 <8.4) and supporting the
+// given flavor (optional, see Flavor* constants), independently of any
+// directory. Aliases (see SetAlias) are resolved first. An
+// UnsatisfiedVersionError is returned when no version matches.
+func (s *PHPStore) BestVersionForConstraint(expr, flavor string) (*Version, error) {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	expr, path := s.resolveAlias(expr)
+	if flavor == "" {
+		expr, flavor = splitFlavor(expr)
+	} else {
+		expr, _ = splitFlavor(expr)
+	}
+	if path != "" {
+		return s.versionForAliasPath(expr, path, flavor)
+	}
+	cs, err := parseConstraints(expr)
+	if err != nil {
+		return nil, err
+	}
+	v, withoutFlavor := s.matchConstraint(s.versions, cs, flavor)
+	if v != nil {
+		return v, nil
+	}
+	reason := fmt.Sprintf(`no installed version satisfies PHP %s`, withFlavor(cs.String(), flavor))
+	if withoutFlavor {
+		reason = fmt.Sprintf(`no installed version satisfying PHP %s supports the "%s" flavor`, cs, flavor)
+	}
+	return nil, &UnsatisfiedVersionError{
+		Requirement: withFlavor(cs.String(), flavor),
+		Reason:      reason,
+	}
+}
+
+// versionForAliasPath returns the version of a path alias supporting the
+// given flavor (see BestVersionForConstraint)
+func (s *PHPStore) versionForAliasPath(name, path, flavor string) (*Version, error) {
+	v := s.versionAtPath(path)
+	if v != nil && v.SupportsFlavor(flavor) {
+		return v, nil
+	}
+	reason := fmt.Sprintf(`no installed version at %s (alias %s)`, path, name)
+	if v != nil {
+		reason = fmt.Sprintf(`the version at %s (alias %s) does not support the "%s" flavor`, path, name, flavor)
+	}
+	return nil, &UnsatisfiedVersionError{
+		Requirement: withFlavor(name, flavor),
+		Reason:      reason,
+	}
+}
+
+// bestVersionForConstraint returns the most recent version matching the
+// constraint expression of the matcher (see parseConstraints) and supporting
+// its flavor, versions providing all the given extensions being preferred
+func (s *PHPStore) bestVersionForConstraint(m *requirementMatcher, source string, extensions ...string) (*Version, string, *Warning, error) {
+	cs, flavor := m.constraints, m.flavor
+	if m.err != nil {
+		return s.unsatisfiedVersion(&Warning{Kind: WarningInvalidConstraint, Requested: withFlavor(m.requirement, flavor), Source: source, Err: m.err})
+	}
+
+	v, withoutFlavor := s.matchConstraint(s.candidates(extensions), cs, flavor)
+	if v != nil {
+		return v, fmt.Sprintf("%s (matching %s)", source, withFlavor(cs.String(), flavor)), nil, nil
+	}
+	if withoutFlavor {
+		return s.unsatisfiedVersion(&Warning{Kind: WarningFlavorNotAvailable, Requested: withFlavor(cs.String(), flavor), Source: source})
+	}
+	return s.unsatisfiedVersion(&Warning{Kind: WarningConstraintNotSatisfied, Requested: withFlavor(cs.String(), flavor), Source: source})
+}
+
+// matchConstraint returns the last candidate matching the constraint and
+// supporting the flavor; when none is found, it also tells if some versions
+// match the constraint but not the flavor
+func (s *PHPStore) matchConstraint(candidates versions, cs *constraints, flavor string) (*Version, bool) {
+	// start from the end as versions are always sorted
+	withoutFlavor := false
+	for i := len(candidates) - 1; i >= 0; i-- {
+		v := candidates[i]
+		if fv := v.fullVersion(); fv != nil && cs.check(fv) {
+			if v.SupportsFlavor(flavor) {
+				return v, false
+			}
+			withoutFlavor = true
+		}
+	}
+	return nil, withoutFlavor
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Container runtimes supported by RegisterContainer
+const (
+	// ContainerToolbox runs binaries with toolbox run (Fedora Silverblue)
+	ContainerToolbox = "toolbox"
+	// ContainerDistrobox runs binaries with distrobox enter
+	ContainerDistrobox = "distrobox"
+	// ContainerPodman runs binaries with podman exec in running containers
+	// (rootless or not); servers are only reachable from the host when the
+	// container shares its network or publishes the ports
+	ContainerPodman = "podman"
+)
+
+// containerRuntime describes how to list containers and run binaries in them;
+// there is no Docker runtime: Podman support is built on this table rather
+// than on a Docker subsystem, which this package does not have
+type containerRuntime struct {
+	// list is the command listing the containers, parsed by names
+	list  []string
+	names func(out []byte) []string
+	// exec returns the command running a binary in the given container
+	exec func(name string) []string
+}
+
+var containerRuntimes = map[string]containerRuntime{
+	ContainerToolbox: {
+		// like "a1b2c3d4e5f6  fedora-toolbox-40  2 days ago  running  registry.fedoraproject.org/fedora-toolbox:40"
+		list:  []string{"toolbox", "list", "--containers"},
+		names: func(out []byte) []string { return containerNames(out, " ", 1) },
+		exec:  func(name string) []string { return []string{"toolbox", "run", "--container", name} },
+	},
+	ContainerDistrobox: {
+		// like "a1b2c3d4e5f6 | ubuntu-php | Up 2 hours | ubuntu:24.04"
+		list:  []string{"distrobox", "list", "--no-color"},
+		names: func(out []byte) []string { return containerNames(out, "|", 1) },
+		exec:  func(name string) []string { return []string{"distrobox", "enter", name, "--"} },
+	},
+	ContainerPodman: {
+		// like "php-app map[maintainer:me]", without header
+		list:  []string{"podman", "ps", "--format", "{{.Names}} {{.Labels}}"},
+		names: podmanContainerNames,
+		// -i keeps stdin open (like for php -r reading STDIN)
+		exec: func(name string) []string { return []string{"podman", "exec", "-i", name} },
+	},
+}
+
+// containerNameRegexp is the syntax of container names shared by the
+// runtimes; names are used in paths, wrapper scripts, and command lines
+var containerNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// containerBinaries are the binaries looked for in containers, by the path
+// of their wrapper relative to the wrapper directory
+var containerBinaries = map[string]string{
+	filepath.Join("bin", "php"):      "php",
+	filepath.Join("sbin", "php-fpm"): "php-fpm",
+	filepath.Join("bin", "php-cgi"):  "php-cgi",
+}
+
+// containerWrapperMarker is the second line of the wrapper scripts, followed
+// by the runtime and the name of the container
+const containerWrapperMarker = "# phpstore container: "
+
+// containerLookupScript prints the name and the path of the PHP binaries of
+// a container; FPM is often outside of the PATH
+const containerLookupScript = `for b in php php-fpm php-cgi; do
+	p=$(command -v $b || { [ -x /usr/sbin/$b ] && echo /usr/sbin/$b; })
+	[ -n "$p" ] && echo "$b $p"
+done
+true`
+
+// RegisterContainer registers the PHP version of a container (see
+// Container* constants for the supported runtimes, like toolbox on Fedora
+// Silverblue). Wrapper scripts running the PHP binaries of the container
+// (CLI, FPM, and CGI) are written in the configuration directory, and
+// registered with RegisterPath; the version can then be used like any other
+// one. Unregister the version with uninstall set to remove the wrappers.
+func (s *PHPStore) RegisterContainer(ctx context.Context, runtime, name string) (*Version, error) {
+	if !containerNameRegexp.MatchString(name) {
+		return nil, errors.Errorf("invalid container name %q", name)
+	}
+	rt, ok := containerRuntimes[runtime]
+	if !ok {
+		return nil, errors.Errorf("unsupported container runtime %q", runtime)
+	}
+	var stdout, stderr bytes.Buffer
+	args := append(rt.exec(name), "sh", "-c", containerLookupScript)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "unable to run a command in the %s container %s: %s", runtime, name, strings.TrimSpace(stderr.String()))
+	}
+	binaries := map[string]string{}
+	sc := bufio.NewScanner(&stdout)
+	for sc.Scan() {
+		if fields := strings.Fields(sc.Text()); len(fields) == 2 {
+			binaries[fields[0]] = fields[1]
+		}
+	}
+	if binaries["php"] == "" {
+		return nil, errors.Errorf("no PHP binary found in the %s container %s", runtime, name)
+	}
+
+	dir := s.containerDir(runtime, name)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for wrapper, binary := range containerBinaries {
+		path, ok := binaries[binary]
+		if !ok {
+			continue
+		}
+		wrapper = filepath.Join(dir, wrapper)
+		if err := os.MkdirAll(filepath.Dir(wrapper), 0755); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var quoted []string
+		for _, arg := range append(rt.exec(name), path) {
+			quoted = append(quoted, shellQuote(arg))
+		}
+		script := fmt.Sprintf("#!/bin/sh\n%s%s %s\nexec %s \"$@\"\n", containerWrapperMarker, runtime, name, strings.Join(quoted, " "))
+		if err := os.WriteFile(wrapper, []byte(script), 0755); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return s.RegisterPath(filepath.Join(dir, "bin", "php"))
+}
+
+// DiscoverContainers registers the PHP versions of all the containers of the
+// available runtimes (see RegisterContainer); containers without PHP are
+// skipped. Containers are not part of the discovery as running them is slow.
+func (s *PHPStore) DiscoverContainers(ctx context.Context) ([]*Version, error) {
+	runtimes := make([]string, 0, len(containerRuntimes))
+	for runtime := range containerRuntimes {
+		runtimes = append(runtimes, runtime)
+	}
+	sort.Strings(runtimes)
+	var vs []*Version
+	for _, runtime := range runtimes {
+		rt := containerRuntimes[runtime]
+		if _, err := exec.LookPath(rt.list[0]); err != nil {
+			continue
+		}
+		out, err := exec.CommandContext(ctx, rt.list[0], rt.list[1:]...).Output()
+		if err != nil {
+			return vs, errors.Wrapf(err, "unable to list the %s containers", runtime)
+		}
+		for _, name := range rt.names(out) {
+			v, err := s.RegisterContainer(ctx, runtime, name)
+			if err != nil {
+				s.log("Skipping the %s container %s: %s", runtime, name, err)
+				continue
+			}
+			s.log("Found PHP %s in the %s container %s", v.Version, runtime, name)
+			vs = append(vs, v)
+		}
+	}
+	return vs, nil
+}
+
+// containerDir returns the directory of the wrappers of a container
+func (s *PHPStore) containerDir(runtime, name string) string {
+	return filepath.Join(s.configDir, "containers", runtime, name)
+}
+
+// containerNames returns the names of the containers listed in a table (the
+// column at the given index), skipping the header line
+func containerNames(out []byte, separator string, column int) []string {
+	var names []string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for first := true; sc.Scan(); first = false {
+		if first {
+			continue
+		}
+		var fields []string
+		if separator == " " {
+			fields = strings.Fields(sc.Text())
+		} else {
+			fields = strings.Split(sc.Text(), separator)
+		}
+		if len(fields) > column {
+			if name := strings.TrimSpace(fields[column]); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// podmanContainerNames returns the names of the containers listed by podman
+// ps, except the ones managed by toolbox and distrobox (which are Podman
+// containers as well)
+func podmanContainerNames(out []byte) []string {
+	var names []string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.Contains(line, "com.github.containers.toolbox:") || strings.Contains(line, "manager:distrobox") {
+			continue
+		}
+		names = append(names, fields[0])
+	}
+	return names
+}
+
+// wrapperContainer returns the runtime and the name of the container run by
+// a wrapper script written by RegisterContainer, if the binary is one
+func wrapperContainer(path string) (string, string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	lines := strings.SplitN(string(head[:n]), "\n", 3)
+	if len(lines) < 3 || lines[0] != "#!/bin/sh" || !strings.HasPrefix(lines[1], containerWrapperMarker) {
+		return "", ""
+	}
+	fields := strings.Fields(strings.TrimPrefix(lines[1], containerWrapperMarker))
+	if len(fields) != 2 {
+		return "", ""
+	}
+	return fields[0], fields[1]
+}
+
+// shellQuote quotes an argument for POSIX shells
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
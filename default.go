@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SetDefaultVersion sets the version used when a project does not require
+// any specific version (like 8.3, 8.3.8, ^8.2, or 8.3-fpm), instead of the
+// first PHP binary in the PATH. The setting is saved in the configuration;
+// an empty requirement removes it.
+func (s *PHPStore) SetDefaultVersion(requirement string) error {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requirement = strings.TrimSpace(requirement)
+	if requirement != "" && s.matchInstalledVersion(requirement) == nil {
+		return errors.Errorf("no installed PHP version matches %q", requirement)
+	}
+	if err := s.updateConfig(func(c *config) {
+		c.DefaultVersion = requirement
+	}); err != nil {
+		return err
+	}
+	s.defaultVersion = requirement
+	return nil
+}
+
+// DefaultVersion returns the version requirement set with SetDefaultVersion
+func (s *PHPStore) DefaultVersion() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.defaultVersion
+}
+
+// matchInstalledVersion returns the most recent version matching the given
+// requirement (or alias), or nil
+func (s *PHPStore) matchInstalledVersion(requirement string) *Version {
+	requirement, aliasPath := s.resolveAlias(requirement)
+	if aliasPath != "" {
+		_, flavor := splitFlavor(requirement)
+		if v := s.versionAtPath(aliasPath); v != nil && v.SupportsFlavor(flavor) {
+			return v
+		}
+		return nil
+	}
+	requirement, flavor := splitFlavor(requirement)
+	if isConstraint(requirement) {
+		cs, err := parseConstraints(requirement)
+		if err != nil {
+			return nil
+		}
+		v, _ := s.matchConstraint(s.versions, cs, flavor)
+		return v
+	}
+	// start from the end as versions are always sorted
+	for i := len(s.versions) - 1; i >= 0; i-- {
+		v := s.versions[i]
+		if hasVersionPrefix(v.Version, requirement) && v.SupportsFlavor(flavor) {
+			return v
+		}
+	}
+	return nil
+}
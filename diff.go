@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// VersionsDiff describes the changes between two lists of versions (like the
+// cache and a fresh discovery), versions being identified by their binary
+type VersionsDiff struct {
+	Added   []*Version
+	Removed []*Version
+	// Changed lists the versions whose binary changed (like an in-place
+	// upgrade from 8.3.1 to 8.3.2)
+	Changed []*VersionChange
+}
+
+// VersionChange is a version whose binary changed
+type VersionChange struct {
+	Old *Version
+	New *Version
+}
+
+// DiffVersions returns the changes from the previous versions to the current
+// ones
+func DiffVersions(previous, current []*Version) *VersionsDiff {
+	d := &VersionsDiff{}
+	known := make(map[string]*Version, len(previous))
+	for _, v := range previous {
+		known[v.binary()] = v
+	}
+	for _, v := range current {
+		old, ok := known[v.binary()]
+		if !ok {
+			d.Added = append(d.Added, v)
+			continue
+		}
+		delete(known, v.binary())
+		if old.changedTo(v) {
+			d.Changed = append(d.Changed, &VersionChange{Old: old, New: v})
+		}
+	}
+	// in the order of the previous versions
+	for _, v := range previous {
+		if _, ok := known[v.binary()]; ok {
+			d.Removed = append(d.Removed, v)
+		}
+	}
+	return d
+}
+
+// changedTo returns true if the binary of the version changed
+func (v *Version) changedTo(other *Version) bool {
+	if v.Version != other.Version || v.VersionSuffix != other.VersionSuffix {
+		return true
+	}
+	// unknown for versions cached before binaries were stamped
+	if v.BinaryModTime.IsZero() || other.BinaryModTime.IsZero() {
+		return false
+	}
+	return !v.BinaryModTime.Equal(other.BinaryModTime) || v.BinarySize != other.BinarySize
+}
+
+// Empty returns true when there are no changes
+func (d *VersionsDiff) Empty() bool {
+	return d == nil || len(d.Added)+len(d.Removed)+len(d.Changed) == 0
+}
+
+// String returns one line per change, like "New PHP 8.4.1 detected (Homebrew)"
+func (d *VersionsDiff) String() string {
+	if d.Empty() {
+		return ""
+	}
+	var lines []string
+	for _, v := range d.Added {
+		if source := v.sourceLabel(); source != "" {
+			lines = append(lines, fmt.Sprintf("New PHP %s%s detected (%s)", v.Version, v.VersionSuffix, source))
+		} else {
+			lines = append(lines, fmt.Sprintf("New PHP %s%s detected", v.Version, v.VersionSuffix))
+		}
+	}
+	for _, c := range d.Changed {
+		if c.Old.Version != c.New.Version {
+			lines = append(lines, fmt.Sprintf("PHP %s upgraded to %s (%s)", c.Old.Version, c.New.Version, c.New.binary()))
+		} else {
+			lines = append(lines, fmt.Sprintf("PHP %s%s changed (%s)", c.New.Version, c.New.VersionSuffix, c.New.binary()))
+		}
+	}
+	for _, v := range d.Removed {
+		lines = append(lines, fmt.Sprintf("PHP %s%s removed (%s)", v.Version, v.VersionSuffix, v.binary()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// copy returns a copy of the changes with copies of the versions
+func (d *VersionsDiff) copy() *VersionsDiff {
+	if d == nil {
+		return nil
+	}
+	c := &VersionsDiff{Added: copyVersions(d.Added), Removed: copyVersions(d.Removed)}
+	for _, change := range d.Changed {
+		o, n := *change.Old, *change.New
+		c.Changed = append(c.Changed, &VersionChange{Old: &o, New: &n})
+	}
+	return c
+}
+
+// Changes returns the changes made to the versions by the last discovery or
+// refresh (see Refresh and Watch), compared to the versions known before
+// (like the ones of the cache); it is empty when versions were loaded from
+// an unchanged cache
+func (s *PHPStore) Changes() *VersionsDiff {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.changes == nil {
+		return &VersionsDiff{}
+	}
+	return s.changes
+}
+
+// cachedVersions returns the versions of the cache as is (without migrations
+// nor validation), to compare them with the discovered ones
+func (s *PHPStore) cachedVersions() versions {
+	contents, err := s.readCache()
+	if err != nil {
+		return nil
+	}
+	var cache cacheFile
+	if err := json.Unmarshal(contents, &cache); err != nil {
+		// schema version 1 was a list of versions
+		if err := json.Unmarshal(contents, &cache.Versions); err != nil {
+			return nil
+		}
+	}
+	return cache.Versions
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// environmentVersions caches the versions of the PHP binaries injected in the
+// PATH by direnv or Nix, which are usually not discovered (see SourceDirenv)
+type environmentVersions struct {
+	mu       sync.Mutex
+	versions map[string]*environmentVersion
+}
+
+type environmentVersion struct {
+	version *Version
+	stamp   fileStamp
+}
+
+// probe probes the given PHP binary, unless its version is already cached
+func (e *environmentVersions) probe(php string) {
+	stamp := statFile(php)
+	e.mu.Lock()
+	cached, ok := e.versions[php]
+	e.mu.Unlock()
+	if ok && cached.stamp == stamp {
+		return
+	}
+	v, err := NewVersionFromPath(php)
+	if err != nil {
+		v = nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.versions == nil {
+		e.versions = make(map[string]*environmentVersion)
+	}
+	e.versions[php] = &environmentVersion{version: v, stamp: stamp}
+}
+
+// get returns the cached version of the given PHP binary (see probe)
+func (e *environmentVersions) get(php string) *Version {
+	stamp := statFile(php)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if cached, ok := e.versions[php]; ok && cached.stamp == stamp {
+		return cached.version
+	}
+	return nil
+}
+
+// probeEnvironmentPHP probes the PHP binary found by SourceDirenv for dir, if
+// enabled; it must be called before locking the store, which only reads the
+// probed versions (see environmentVersion)
+func (s *PHPStore) probeEnvironmentPHP(dir string) {
+	s.mu.RLock()
+	enabled := false
+	for _, name := range s.sources {
+		if name == SourceDirenv {
+			enabled = true
+		}
+	}
+	s.mu.RUnlock()
+	if !enabled {
+		return
+	}
+	if php, _ := environmentPHP(dir); php != "" && s.environmentVersionAtPath(php) == nil {
+		s.environment.probe(php)
+	}
+}
+
+// environmentVersionAtPath returns the version of a discovered binary
+func (s *PHPStore) environmentVersionAtPath(php string) *Version {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.versionAtPath(php)
+}
+
+// environmentVersion returns the version of the PHP binary found by
+// SourceDirenv: the discovered one, or the probed one (like binaries of the
+// Nix store, which are not discovered)
+func (s *PHPStore) environmentVersion(php string) *Version {
+	if v := s.versionAtPath(php); v != nil {
+		return v
+	}
+	return s.environment.get(php)
+}
+
+// bestVersionForEnvironment returns the version of the PHP binary found by
+// SourceDirenv (see bestVersion)
+func (s *PHPStore) bestVersionForEnvironment(php, flavor, source string) (*Version, string, *Warning, error) {
+	v := s.environmentVersion(php)
+	if v == nil {
+		return s.unsatisfiedVersion(&Warning{Kind: WarningVersionNotAvailable, Requested: withFlavor(php, flavor), Source: source})
+	}
+	if !v.SupportsFlavor(flavor) {
+		return s.unsatisfiedVersion(&Warning{Kind: WarningFlavorNotAvailable, Requested: withFlavor(v.Version, flavor), Source: source})
+	}
+	return v, source, nil, nil
+}
+
+// environmentPHP returns the PHP binary of the PATH of the current process
+// when it was injected for the project of dir by direnv (DIRENV_DIR) or by a
+// Nix shell (IN_NIX_SHELL, for the working directory), and a description of
+// where it comes from
+func environmentPHP(dir string) (string, string) {
+	php := lookPathIn("php", os.Getenv("PATH"))
+	if php == "" {
+		return "", ""
+	}
+	// like "-/home/user/project"
+	if root := strings.TrimPrefix(os.Getenv("DIRENV_DIR"), "-"); root != "" && isSubDir(dir, root) {
+		// only when direnv changed the PHP binary of the PATH
+		if previous, ok := direnvPreviousPath(); ok && lookPathIn("php", previous) != php {
+			return php, fmt.Sprintf("direnv: %s", filepath.Join(root, ".envrc"))
+		}
+	}
+	if os.Getenv("IN_NIX_SHELL") != "" && strings.HasPrefix(php, "/nix/store/") {
+		if wd, err := os.Getwd(); err == nil && isSubDir(dir, wd) {
+			return php, "Nix shell"
+		}
+	}
+	return "", ""
+}
+
+// direnvPreviousPath returns the PATH before direnv loaded the environment,
+// read from the DIRENV_DIFF environment variable (zlib compressed JSON, base64
+// encoded)
+func direnvPreviousPath() (string, bool) {
+	encoded := os.Getenv("DIRENV_DIFF")
+	if encoded == "" {
+		return "", false
+	}
+	compressed, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		if compressed, err = base64.RawURLEncoding.DecodeString(encoded); err != nil {
+			return "", false
+		}
+	}
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", false
+	}
+	defer r.Close()
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return "", false
+	}
+	var diff struct {
+		Previous map[string]string `json:"p"`
+	}
+	if err := json.Unmarshal(contents, &diff); err != nil {
+		return "", false
+	}
+	path, ok := diff.Previous["PATH"]
+	if !ok {
+		// PATH was not changed by direnv
+		return os.Getenv("PATH"), true
+	}
+	return path, true
+}
+
+// lookPathIn returns the path of an executable in the given PATH, symlinks
+// resolved, or an empty string
+func lookPathIn(name, path string) string {
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			continue
+		}
+		file := filepath.Join(dir, name)
+		fi, err := os.Stat(file)
+		if err != nil || fi.IsDir() || (runtime.GOOS != "windows" && fi.Mode()&0111 == 0) {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(file); err == nil {
+			return resolved
+		}
+		return file
+	}
+	return ""
+}
+
+// isSubDir returns true if dir is root or one of its subdirectories
+func isSubDir(dir, root string) bool {
+	rel, err := filepath.Rel(root, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -24,11 +24,13 @@ import (
 	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	version "github.com/hashicorp/go-version"
 	"github.com/pkg/errors"
@@ -36,63 +38,289 @@ import (
 
 // discover tries to find all PHP versions on the current machine
 func (s *PHPStore) discover() {
+	start := time.Now()
+	s.problems = nil
+	s.fs = newFSCache()
+	defer func() { s.fs = nil }()
 	s.doDiscover()
 
+	// Registered via RegisterPath
+	if s.scansSource("registered") {
+		s.queueProbes("registered")
+		for _, path := range s.registeredPaths {
+			if p, err := s.probeForPath(path, "registered"); err == nil {
+				s.queueProbes("registered", p)
+			} else {
+				s.logWith([]interface{}{"source", "registered", "path", path, "verdict", "skipped"}, "  Skipping %s: %s", path, err)
+				s.problems = append(s.problems, &Problem{Kind: ProblemProbeFailed, Path: path, Source: "registered", Err: err})
+			}
+		}
+	}
+
 	// Under $PATH
-	paths := s.pathDirectories(s.configDir)
-	s.log("Looking for PHP in the PATH (%s)", paths)
-	for _, path := range paths {
-		for _, version := range s.findFromDir(path, nil, "PATH") {
-			idx := s.addVersion(version)
-			// the first one is the default/system PHP binary
-			if s.pathVersion == nil {
-				s.pathVersion = s.versions[idx]
-				s.pathVersion.IsSystem = true
-				s.log("  System PHP version (first in PATH)")
+	if s.scansSource("PATH") {
+		paths := s.pathDirectories(s.configDir)
+		s.log("Looking for PHP in the PATH (%s)", paths)
+		s.queueProbes("PATH")
+		for _, path := range paths {
+			if s.skipsDir(path, "PATH", true) {
+				continue
+			}
+			s.roots = appendPath(s.roots, path)
+			// version manager shims (like ~/.phpenv/shims/php) are
+			// replaced by the installation they run, which is the system one
+			probes := []*probe{s.resolveShim(filepath.Join(path, "php"), "PATH")}
+			if probes[0] == nil {
+				probes = s.findFromDir(path, nil, "PATH")
+			}
+			for _, p := range probes {
+				p.inPath = true
+			}
+			// FrankenPHP is never the php command of the PATH
+			if p := s.frankenPHPProbe(path, "PATH"); p != nil {
+				probes = append(probes, p)
+			}
+			s.queueProbes("PATH", probes...)
+		}
+	}
+
+	s.runProbes()
+	s.report.Duration = time.Since(start)
+}
+
+// probe is a potential PHP binary found during discovery, to be checked by runProbes
+type probe struct {
+	dir     string
+	binName string
+	why     string
+	inPath  bool
+	version *Version
+}
+
+// binary returns the path of the PHP binary of the probe
+func (p *probe) binary() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(p.dir, p.binName+".exe")
+	}
+	return filepath.Join(p.dir, "bin", p.binName)
+}
+
+// DiscoveryEvents are callbacks notified of the discovery progress; they are
+// never called concurrently
+type DiscoveryEvents struct {
+	// OnSourceStart is called when the first binary of a source is probed
+	OnSourceStart func(source string)
+	// OnVersionFound is called for each PHP binary found
+	OnVersionFound func(source string, v *Version)
+	// OnSourceDone is called when all binaries of a source have been probed
+	OnSourceDone func(source string, found int)
+}
+
+// queueProbes registers probes to be checked by runProbes; the source is
+// registered even if there are no probes so that its events are triggered
+func (s *PHPStore) queueProbes(why string, probes ...*probe) {
+	seen := false
+	for _, source := range s.probeSources {
+		if source == why {
+			seen = true
+			break
+		}
+	}
+	if !seen {
+		s.probeSources = append(s.probeSources, why)
+	}
+	for _, p := range probes {
+		// ignored binaries are never run, as they might be broken
+		if s.ignoresPath(p.binary()) || s.ignoresPath(p.dir) {
+			s.logWith([]interface{}{"source", why, "path", p.binary(), "verdict", "ignored"}, "  Skipping %s as it is ignored", p.binary())
+			continue
+		}
+		s.probes = append(s.probes, p)
+	}
+}
+
+// runProbes checks all queued probes concurrently (as running PHP binaries is
+// slow), and adds the found versions in the order probes were queued
+func (s *PHPStore) runProbes() {
+	probes := s.probes
+	sources := s.probeSources
+	s.probes = nil
+	s.probeSources = nil
+
+	// the same binary can be found by several sources
+	unique := map[string]*probe{}
+	var uniqueProbes []*probe
+	pending := map[string]int{}
+	for _, p := range probes {
+		key := filepath.Join(p.dir, p.binName)
+		if _, ok := unique[key]; ok {
+			continue
+		}
+		unique[key] = p
+		uniqueProbes = append(uniqueProbes, p)
+		pending[p.why]++
+	}
+
+	// sources without anything to probe are done right away
+	for _, source := range sources {
+		if pending[source] == 0 {
+			s.sourceStarted(source)
+			s.sourceDone(source)
+		}
+	}
+
+	jobs := make(chan *probe)
+	var wg sync.WaitGroup
+	for i := 0; i < s.discoveryConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				s.eventsMu.Lock()
+				s.sourceStarted(p.why)
+				s.eventsMu.Unlock()
+
+				var err error
+				p.version, err = s.discoverPHP(p.dir, p.binName)
+				if p.version != nil && s.ignores(p.version) {
+					s.logWith([]interface{}{"source", p.why, "path", p.version.binary(), "version", p.version.Version, "verdict", "ignored"}, "  Skipping %s as version %s is ignored", p.version.binary(), p.version.Version)
+					p.version = nil
+				}
+				if p.version != nil {
+					p.version.Source = p.why
+					p.version.stampBinary()
+				}
+
+				s.eventsMu.Lock()
+				source := s.report.source(p.why)
+				source.Probed++
+				if err != nil {
+					source.Errors = append(source.Errors, err)
+					s.problems = append(s.problems, &Problem{Kind: ProblemProbeFailed, Path: p.binary(), Source: p.why, Err: err})
+				}
+				if p.version != nil {
+					for _, warning := range p.version.warnings {
+						s.problems = append(s.problems, &Problem{Kind: ProblemStartupWarning, Path: p.version.binary(), Source: p.why, Err: errors.New(warning)})
+					}
+					if problem := p.version.runtimeProblem(); problem != nil {
+						problem.Source = p.why
+						s.problems = append(s.problems, problem)
+					}
+					source.Found++
+					if s.discoveryEvents.OnVersionFound != nil {
+						s.discoveryEvents.OnVersionFound(p.why, p.version)
+					}
+				}
+				if pending[p.why]--; pending[p.why] == 0 {
+					s.sourceDone(p.why)
+				}
+				s.eventsMu.Unlock()
 			}
+		}()
+	}
+	for _, p := range uniqueProbes {
+		jobs <- p
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, p := range probes {
+		version := unique[filepath.Join(p.dir, p.binName)].version
+		if version == nil {
+			continue
+		}
+		idx := s.addVersion(version)
+		// the first one is the default/system PHP binary (FPM-only
+		// installations cannot be used from the command line)
+		if p.inPath && s.pathVersion == nil && version.PHPPath != "" {
+			s.pathVersion = s.versions[idx]
+			s.pathVersion.IsSystem = true
+			s.logWith([]interface{}{"source", p.why, "path", s.pathVersion.PHPPath, "version", s.pathVersion.Version, "verdict", "system"}, "  System PHP version (first in PATH)")
 		}
 	}
 }
 
+// scansSource returns false when the source must be skipped (see Refresh)
+func (s *PHPStore) scansSource(why string) bool {
+	return s.onlySource == "" || strings.EqualFold(s.onlySource, why)
+}
+
 func (s *PHPStore) discoverFromDir(root string, phpRegexp *regexp.Regexp, pathRegexp *regexp.Regexp, why string) {
+	if !s.scansSource(why) || s.skipsDir(root, why, true) {
+		return
+	}
+	s.roots = appendPath(s.roots, root)
 	maxDepth := 1
 	if pathRegexp != nil {
 		maxDepth += strings.Count(pathRegexp.String(), "/")
 	}
-	filepath.Walk(root, func(path string, finfo os.FileInfo, err error) error {
-		if err != nil {
-			// prevent panic by handling failure accessing a path
-			return nil
-		}
-		// bypass current directory and non-directory
-		if root == path || !finfo.IsDir() {
-			return nil
-		}
-		rel, err := filepath.Rel(root, path)
-		if err != nil {
-			return errors.WithStack(err)
+	s.queueProbes(why)
+	s.scanDir(root, "", 0, maxDepth, phpRegexp, pathRegexp, why)
+}
+
+// scanDir looks for installations in the subdirectories of root/rel, rel
+// being at the given depth; os.ReadDir is used instead of filepath.Walk as it
+// does not stat every entry, which is slow for directories with thousands of
+// entries (like the Homebrew Cellar)
+func (s *PHPStore) scanDir(root, rel string, depth, maxDepth int, phpRegexp *regexp.Regexp, pathRegexp *regexp.Regexp, why string) {
+	entries, err := os.ReadDir(filepath.Join(root, rel))
+	if err != nil {
+		s.walkProblem(filepath.Join(root, rel), why, err)
+		return
+	}
+	for _, entry := range entries {
+		// symlinks are not followed, like with filepath.Walk
+		if !entry.IsDir() {
+			continue
 		}
-		// only maxDepth+1 levels of depth
-		if strings.Count(rel, string(os.PathSeparator)) > maxDepth {
-			return filepath.SkipDir
+		rel := filepath.Join(rel, entry.Name())
+		path := filepath.Join(root, rel)
+		if s.skipsDir(path, why, false) {
+			continue
 		}
-		s.log("Looking for PHP in %s (%+v) -- %s", path, pathRegexp, why)
+		s.logWith([]interface{}{"source", why, "path", path}, "Looking for PHP in %s (%+v) -- %s", path, pathRegexp, why)
 		if pathRegexp == nil || pathRegexp.MatchString(rel) {
 			s.addFromDir(path, phpRegexp, why)
-			return filepath.SkipDir
+			continue
+		}
+		// only maxDepth+1 levels of depth
+		if depth < maxDepth {
+			s.scanDir(root, rel, depth+1, maxDepth, phpRegexp, pathRegexp, why)
 		}
-		return nil
-	})
+	}
 }
 
+// addFromDir queues the PHP binaries found in dir (see runProbes)
 func (s *PHPStore) addFromDir(dir string, phpRegexp *regexp.Regexp, why string) {
-	for _, v := range s.findFromDir(dir, phpRegexp, why) {
-		s.addVersion(v)
+	if !s.scansSource(why) || s.skipsDir(dir, why, true) {
+		return
 	}
+	s.roots = appendPath(s.roots, dir)
+	s.queueProbes(why, s.findFromDir(dir, phpRegexp, why)...)
 }
 
-func (s *PHPStore) findFromDir(dir string, phpRegexp *regexp.Regexp, why string) []*Version {
-	s.log("Looking for PHP in %s (%+v) -- %s", dir, phpRegexp, why)
+// sourceStarted adds the source to the report and triggers the OnSourceStart
+// event the first time a source is seen
+func (s *PHPStore) sourceStarted(name string) {
+	if s.report.source(name) != nil {
+		return
+	}
+	s.report.Sources = append(s.report.Sources, &SourceReport{Name: name, start: time.Now()})
+	if s.discoveryEvents.OnSourceStart != nil {
+		s.discoveryEvents.OnSourceStart(name)
+	}
+}
+
+func (s *PHPStore) sourceDone(name string) {
+	source := s.report.source(name)
+	source.Duration = time.Since(source.start)
+	if s.discoveryEvents.OnSourceDone != nil {
+		s.discoveryEvents.OnSourceDone(name, source.Found)
+	}
+}
+
+func (s *PHPStore) findFromDir(dir string, phpRegexp *regexp.Regexp, why string) []*probe {
+	s.logWith([]interface{}{"source", why, "path", dir}, "Looking for PHP in %s (%+v) -- %s", dir, phpRegexp, why)
 
 	root := dir
 	if filepath.Base(dir) == "bin" {
@@ -102,44 +330,44 @@ func (s *PHPStore) findFromDir(dir string, phpRegexp *regexp.Regexp, why string)
 	}
 
 	if phpRegexp == nil {
-		if v := s.discoverPHP(dir, "php"); v != nil {
-			return []*Version{v}
-		}
-		return nil
+		return []*probe{{dir: dir, binName: "php", why: why}}
 	}
 
-	if _, err := os.Stat(root); err != nil {
-		s.log("  Skipping %s as it does not exist", root)
-		return nil
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.logWith([]interface{}{"source", why, "path", root, "verdict", "skipped"}, "  Skipping %s as it does not exist", root)
+		}
+		s.walkProblem(root, why, err)
+		return s.fpmOnlyProbes(dir, phpRegexp, why)
 	}
 
-	var versions []*Version
-	filepath.Walk(root, func(path string, finfo os.FileInfo, err error) error {
-		if err != nil {
-			// prevent panic by handling failure accessing a path
-			return nil
-		}
-		if root != path && finfo.IsDir() {
-			return filepath.SkipDir
-		}
-		if phpRegexp.MatchString(filepath.Base(path)) {
-			if i := s.discoverPHP(dir, filepath.Base(path)); i != nil {
-				versions = append(versions, i)
-			}
-			return nil
+	var probes []*probe
+	for _, entry := range entries {
+		if !entry.IsDir() && phpRegexp.MatchString(entry.Name()) {
+			probes = append(probes, &probe{dir: dir, binName: entry.Name(), why: why})
 		}
-		return nil
-	})
-	return versions
+	}
+	return append(probes, s.fpmOnlyProbes(dir, phpRegexp, why)...)
 }
 
-func (s *PHPStore) discoverPHP(dir, binName string) *Version {
+// discoverPHP returns the PHP version installed in dir, if any; an error is
+// returned when a binary exists but cannot be used
+func (s *PHPStore) discoverPHP(dir, binName string) (*Version, error) {
+	if isFrankenPHPBinary(binName) {
+		return s.discoverFrankenPHP(dir, binName)
+	}
+
 	// when php-config is not available/useable, fallback to discovering via php, slower but always work
 	if runtime.GOOS == "windows" {
 		// php-config does not exist on Windows
 		return s.discoverPHPViaPHP(dir, binName)
 	}
 
+	if _, err := s.fs.stat(filepath.Join(dir, "bin", binName)); os.IsNotExist(err) {
+		return s.discoverFPMOnly(dir, binName)
+	}
+
 	phpConfigPath := filepath.Join(dir, "bin", strings.Replace(binName, "php", "php-config", 1))
 	fi, err := os.Lstat(phpConfigPath)
 	if err != nil {
@@ -156,75 +384,163 @@ func (s *PHPStore) discoverPHP(dir, binName string) *Version {
 	return s.discoverPHPViaPHPConfig(dir, binName)
 }
 
-func (s *PHPStore) discoverPHPViaPHP(dir, binName string) *Version {
+func (s *PHPStore) discoverPHPViaPHP(dir, binName string) (*Version, error) {
 	php := filepath.Join(dir, "bin", binName)
 	if runtime.GOOS == "windows" {
-		binName += ".exe"
-		php = filepath.Join(dir, binName)
+		if php = resolveWindowsBinary(dir, binName); php == "" {
+			return nil, nil
+		}
+		// companion binaries are next to the real executable, not to the
+		// wrappers (like php.bat)
+		dir = filepath.Dir(php)
+		binName = strings.TrimSuffix(filepath.Base(php), filepath.Ext(php)) + ".exe"
 	}
 
-	if _, err := os.Stat(php); err != nil {
-		return nil
+	if _, err := s.fs.stat(php); err != nil {
+		return nil, nil
 	}
 
-	var buf bytes.Buffer
-	cmd := exec.Command(php, "--version")
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
-	if err := cmd.Run(); err != nil {
-		s.log(`  Unable to run "%s --version: %s"`, php, err)
-		return nil
+	// the version and the build flags are read from the binary, as running
+	// PHP is slow; otherwise, a single run of PHP gets all metadata; when it
+	// fails (broken builds, versions without the json extension), use the
+	// version read from the binary or fallback to php --version. The other
+	// metadata of versions that were not run is probed when needed (see
+	// probeMetadata)
+	rawVersion := ""
+	threadSafe, debugBuild := false, false
+	var warnings []string
+	compiler := ""
+	var info *phpProbe
+	var build *windowsBuild
+	var err error
+	// official Windows builds are not run when the name of their directory
+	// tells their metadata, as starting PHP is slow on Windows
+	if runtime.GOOS == "windows" {
+		build = parseWindowsBuildName(filepath.Base(dir))
 	}
-	r := regexp.MustCompile("PHP (\\d+\\.\\d+\\.\\d+)")
-	data := r.FindSubmatch(buf.Bytes())
-	if data == nil {
-		s.log("  %s is not a PHP binary", php)
-		return nil
+	if build != nil {
+		s.logWith([]interface{}{"path", php}, "  Using the metadata of the build directory name %s", filepath.Base(dir))
+		rawVersion, threadSafe = build.version, build.threadSafe
+	} else if binVersion, ts, debug, ok := buildFromBinary(php); ok {
+		s.logWith([]interface{}{"path", php}, "  Using the version read from %s", php)
+		rawVersion, threadSafe, debugBuild = binVersion, ts, debug
+	} else if info, err = runProbe(php); err == nil {
+		rawVersion = info.Version
+		warnings = info.Warnings
+	} else {
+		s.logWith([]interface{}{"path", php, "error", err}, "  Unable to get metadata from %s: %s", php, err)
+		rawVersion = binVersion
+	}
+	if rawVersion == "" {
+		var buf bytes.Buffer
+		// php.ini is not loaded as broken extensions or auto_prepend_file
+		// might pollute the output
+		cmd, cancel := probeCommand(php, "-n", "--version")
+		defer cancel()
+		cmd.Stdout = &buf
+		cmd.Stderr = &buf
+		if err := cmd.Run(); err != nil {
+			s.logWith([]interface{}{"path", php, "verdict", "error", "error", err}, `  Unable to run "%s --version: %s"`, php, err)
+			return nil, errors.Wrapf(err, "unable to run %s --version", php)
+		}
+		var banner string
+		rawVersion, banner, warnings = parseVersionBanner(buf.Bytes())
+		if rawVersion == "" {
+			s.logWith([]interface{}{"path", php, "verdict", "not_php"}, "  %s is not a PHP binary", php)
+			return nil, errors.Errorf("%s is not a PHP binary", php)
+		}
+		// like "PHP 8.3.4 (cli) (built: Mar 12 2024 23:42:26) (ZTS Visual C++ 2019 x64)"
+		threadSafe = strings.Contains(banner, "(ZTS")
+		debugBuild = strings.Contains(banner, " DEBUG")
+		compiler = bannerCompiler(banner)
 	}
 	php = filepath.Clean(php)
-	var err error
-	php, err = filepath.EvalSymlinks(php)
+	php, err = s.fs.evalSymlinks(php)
 	if err != nil {
-		s.log("  %s is not a valid symlink", php)
-		return nil
+		s.logWith([]interface{}{"path", php, "verdict", "error"}, "  %s is not a valid symlink", php)
+		return nil, errors.Errorf("%s is not a valid symlink", php)
 	}
-	v := s.validateVersion(dir, normalizeVersion(string(data[1])))
-	if v == nil {
-		return nil
+	version, err := s.newVersion(dir, rawVersion)
+	if err != nil {
+		return nil, err
 	}
-	version := &Version{
-		Path:        dir,
-		Version:     v.String(),
-		FullVersion: v,
-		PHPPath:     php,
+	version.PHPPath = php
+	version.ThreadSafe = threadSafe
+	version.DebugBuild = debugBuild
+	version.warnings = warnings
+	for _, warning := range warnings {
+		s.logWith([]interface{}{"path", php, "warning", warning}, "  %s displays a warning on startup: %s", php, warning)
+	}
+	if info != nil {
+		info.apply(version)
+	}
+	version.Compiler = compiler
+	if build != nil {
+		version.Compiler, version.Arch = build.compiler, build.arch
 	}
+	version.inspectBinary()
 
 	fpm := filepath.Join(dir, "sbin", strings.Replace(binName, "php", "php-fpm", 1))
-	if _, err := os.Stat(fpm); os.IsNotExist(err) {
+	if _, err := s.fs.stat(fpm); os.IsNotExist(err) {
 		fpm = filepath.Join(dir, "bin", strings.Replace(binName, "php", "php-fpm", 1))
 	}
 
 	cgi := filepath.Join(dir, "bin", strings.Replace(binName, "php", "php-cgi", 1))
+	// LiteSpeed (like /usr/local/lsws/lsphp83/bin/lsphp)
+	lsapi := filepath.Join(dir, "bin", strings.Replace(binName, "php", "lsphp", 1))
 	phpconfig := filepath.Join(dir, "bin", strings.Replace(binName, "php", "php-config", 1))
 	phpize := filepath.Join(dir, "bin", strings.Replace(binName, "php", "phpize", 1))
 	phpdbg := filepath.Join(dir, "bin", strings.Replace(binName, "php", "phpdbg", 1))
 	if runtime.GOOS == "windows" {
 		fpm = filepath.Join(dir, strings.Replace(binName, "php", "php-fpm", 1))
 		cgi = filepath.Join(dir, strings.Replace(binName, "php", "php-cgi", 1))
+		lsapi = filepath.Join(dir, strings.Replace(binName, "php", "lsphp", 1))
 		phpconfig = filepath.Join(dir, strings.Replace(binName, "php", "php-config", 1))
 		phpize = filepath.Join(dir, strings.Replace(binName, "php", "phpize", 1))
 		phpdbg = filepath.Join(dir, strings.Replace(binName, "php", "phpdbg", 1))
 	}
-	s.log(version.setServer(fpm, cgi, phpconfig, phpize, phpdbg))
-	return version
+	pecl := filepath.Join(dir, "bin", strings.Replace(binName, "php", "pecl", 1))
+	pear := filepath.Join(dir, "bin", strings.Replace(binName, "php", "pear", 1))
+	pie := filepath.Join(dir, "bin", strings.Replace(binName, "php", "pie", 1))
+	if runtime.GOOS == "windows" {
+		pecl = filepath.Join(dir, strings.Replace(binName, "php", "pecl", 1)+".bat")
+		pear = filepath.Join(dir, strings.Replace(binName, "php", "pear", 1)+".bat")
+		pie = filepath.Join(dir, strings.Replace(binName, "php", "pie", 1)+".bat")
+	}
+	s.logWith([]interface{}{"path", version.PHPPath, "version", version.Version, "verdict", "found"}, version.setServer(s.fs, fpm, cgi, lsapi, phpconfig, phpize, phpdbg)+version.setInstallers(s.fs, pecl, pear, pie)+version.setEmbed()+version.setApacheModule()+version.setFPMConfig())
+	return version, nil
+}
+
+// newVersion returns the version installed in dir from the version reported
+// by one of its binaries (like 8.4.0RC2 or 8.3.6-1ubuntu1.2)
+func (s *PHPStore) newVersion(dir, rawVersion string) (*Version, error) {
+	rawVersion, suffix := splitVersionSuffix(rawVersion)
+	release, prerelease := splitPrerelease(rawVersion)
+	v, err := s.validateVersion(dir, normalizeVersion(release))
+	if err != nil {
+		return nil, err
+	}
+	versionString := v.String()
+	if prerelease != "" {
+		versionString += prerelease
+		if v, err = parsePHPVersion(versionString); err != nil {
+			return nil, errors.Wrapf(err, "unable to parse version %s for PHP at %s", versionString, dir)
+		}
+	}
+	return &Version{
+		Path:          dir,
+		Version:       versionString,
+		VersionSuffix: suffix,
+		FullVersion:   v,
+	}, nil
 }
 
-func (s *PHPStore) discoverPHPViaPHPConfig(dir, binName string) *Version {
+func (s *PHPStore) discoverPHPViaPHPConfig(dir, binName string) (*Version, error) {
 	phpConfig := filepath.Join(dir, "bin", strings.Replace(binName, "php", "php-config", 1))
 	file, err := os.Open(phpConfig)
 	if err != nil {
-		s.log("  Unable to open %s: %s", phpConfig, err)
-		return nil
+		s.logWith([]interface{}{"path", phpConfig, "verdict", "error", "error", err}, "  Unable to open %s: %s", phpConfig, err)
+		return nil, errors.Wrapf(err, "unable to open %s", phpConfig)
 	}
 	version := &Version{
 		Path: dir,
@@ -234,12 +550,22 @@ func (s *PHPStore) discoverPHPViaPHPConfig(dir, binName string) *Version {
 	programSuffix := ""
 	programExtension := ""
 	phpCgiBinary := ""
+	prerelease := ""
+	iniPath := ""
 	allFound := 0
 	for sc.Scan() {
+		if strings.HasPrefix(sc.Text(), "version=") {
+			// vernum does not include the pre-release (like RC2) nor the
+			// suffix (like -1ubuntu1)
+			v, suffix := splitVersionSuffix(strings.Trim(sc.Text()[len("version="):], `"`))
+			_, prerelease = splitPrerelease(v)
+			version.VersionSuffix = suffix
+			continue
+		}
 		if strings.HasPrefix(sc.Text(), "vernum=") {
-			v := s.validateVersion(dir, strings.Trim(sc.Text()[len("vernum="):], `"`))
-			if v == nil {
-				return nil
+			v, err := s.validateVersion(dir, strings.Trim(sc.Text()[len("vernum="):], `"`))
+			if err != nil {
+				return nil, err
 			}
 			version.Version = v.String()
 			version.FullVersion = v
@@ -256,15 +582,39 @@ func (s *PHPStore) discoverPHPViaPHPConfig(dir, binName string) *Version {
 		} else if strings.HasPrefix(sc.Text(), "exe_extension=") {
 			programExtension = strings.Trim(sc.Text()[len("exe_extension="):], `"`)
 			allFound++
+		} else if strings.HasPrefix(sc.Text(), "extension_dir=") {
+			version.ExtensionDir = strings.Trim(sc.Text()[len("extension_dir="):], `"'`)
+		} else if strings.HasPrefix(sc.Text(), "ini_path=") {
+			iniPath = strings.Trim(sc.Text()[len("ini_path="):], `"'`)
+		} else if strings.HasPrefix(sc.Text(), "ini_dir=") {
+			version.IniScanDir = strings.Trim(sc.Text()[len("ini_dir="):], `"'`)
+		} else if strings.HasPrefix(sc.Text(), "configure_options=") {
+			// like --enable-zts (--enable-maintainer-zts before PHP 8) or --enable-debug
+			for _, option := range strings.Fields(strings.Trim(sc.Text()[len("configure_options="):], `"'`)) {
+				switch option {
+				case "--enable-zts", "--enable-maintainer-zts":
+					version.ThreadSafe = true
+				case "--enable-debug":
+					version.DebugBuild = true
+				}
+			}
 		}
 	}
 	if version.FullVersion == nil {
-		s.log("  Unable to find version in %s", phpConfig)
-		return nil
+		s.logWith([]interface{}{"path", phpConfig, "verdict", "invalid_version"}, "  Unable to find version in %s", phpConfig)
+		return nil, errors.Errorf("unable to find version in %s", phpConfig)
 	}
 	if allFound != 5 {
-		s.log("  Unable to parse all information from %s", phpConfig)
-		return nil
+		s.logWith([]interface{}{"path", phpConfig, "verdict", "error"}, "  Unable to parse all information from %s", phpConfig)
+		return nil, errors.Errorf("unable to parse all information from %s", phpConfig)
+	}
+	if prerelease != "" {
+		v, err := parsePHPVersion(version.Version + prerelease)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse version %s%s in %s", version.Version, prerelease, phpConfig)
+		}
+		version.Version += prerelease
+		version.FullVersion = v
 	}
 	if phpCgiBinary == "" {
 		phpCgiBinary = fmt.Sprintf("%sphp%s-cgi%s", programPrefix, programSuffix, programExtension)
@@ -276,32 +626,55 @@ func (s *PHPStore) discoverPHPViaPHPConfig(dir, binName string) *Version {
 		phpCgiBinary = strings.Replace(phpCgiBinary, "bin/", "", 1)
 	}
 	version.PHPPath = filepath.Join(version.Path, "bin", fmt.Sprintf("%sphp%s%s", programPrefix, programSuffix, programExtension))
-	s.log(version.setServer(
+	// ini_path is the directory of php.ini, which might not exist
+	if ini := filepath.Join(iniPath, "php.ini"); iniPath != "" {
+		if _, err := s.fs.stat(ini); err == nil {
+			version.IniPath = ini
+		}
+	}
+	// the other metadata (like loaded extensions) requires running PHP, which
+	// is done when needed (see probeMetadata)
+	version.inspectBinary()
+	s.logWith([]interface{}{"path", version.PHPPath, "version", version.Version, "verdict", "found"}, version.setServer(s.fs,
 		filepath.Join(version.Path, "sbin", fmt.Sprintf("%sphp-fpm%s%s", programPrefix, programSuffix, programExtension)),
 		filepath.Join(version.Path, "bin", phpCgiBinary),
+		filepath.Join(version.Path, "bin", fmt.Sprintf("%slsphp%s%s", programPrefix, programSuffix, programExtension)),
 		filepath.Join(version.Path, "bin", fmt.Sprintf("%sphp-config%s%s", programPrefix, programSuffix, programExtension)),
 		filepath.Join(version.Path, "bin", fmt.Sprintf("%sphpize%s%s", programPrefix, programSuffix, programExtension)),
 		filepath.Join(version.Path, "bin", fmt.Sprintf("%sphpdbg%s%s", programPrefix, programSuffix, programExtension)),
-	))
-	return version
+	)+version.setInstallers(s.fs,
+		filepath.Join(version.Path, "bin", fmt.Sprintf("%specl%s%s", programPrefix, programSuffix, programExtension)),
+		filepath.Join(version.Path, "bin", fmt.Sprintf("%spear%s%s", programPrefix, programSuffix, programExtension)),
+		filepath.Join(version.Path, "bin", fmt.Sprintf("%spie%s%s", programPrefix, programSuffix, programExtension)),
+	)+version.setEmbed()+version.setApacheModule()+version.setFPMConfig())
+	return version, nil
 }
 
-func (s *PHPStore) validateVersion(path, v string) *version.Version {
+// validateVersion parses a version number (vernum, like 80312 for 8.3.12),
+// computed as major * 10000 + minor * 100 + patch
+func (s *PHPStore) validateVersion(path, v string) (*version.Version, error) {
+	vernum, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || vernum < 10000 {
+		s.logWith([]interface{}{"path", path, "verdict", "invalid_version"}, "  Unable to parse version %s for PHP at %s: version is non-standard", v, path)
+		return nil, errors.Errorf("unable to parse version %s for PHP at %s: version is non-standard", v, path)
+	}
 	if len(v) != 5 {
-		s.log("  Unable to parse version %s for PHP at %s: version is non-standard", v, path)
-		return nil
+		s.logWith([]interface{}{"path", path, "version", v}, "  Unusual version number %s for PHP at %s", v, path)
 	}
-	version, err := version.NewVersion(fmt.Sprintf("%c.%s.%s", v[0], v[1:3], v[3:5]))
+	version, err := version.NewVersion(fmt.Sprintf("%d.%d.%d", vernum/10000, vernum/100%100, vernum%100))
 	if err != nil {
-		s.log("  Unable to parse version %s for PHP at %s: %s", v, path, err)
-		return nil
+		s.logWith([]interface{}{"path", path, "verdict", "invalid_version", "error", err}, "  Unable to parse version %s for PHP at %s: %s", v, path, err)
+		return nil, errors.Wrapf(err, "unable to parse version %s for PHP at %s", v, path)
 	}
-	return version
+	return version, nil
 }
 
 func normalizeVersion(v string) string {
 	// version is XYYZZ
 	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return v
+	}
 	version := parts[0]
 	if len(parts[1]) == 1 {
 		version += "0"
@@ -315,16 +688,13 @@ func normalizeVersion(v string) string {
 
 func (s *PHPStore) pathDirectories(configDir string) []string {
 	phpShimDir := filepath.Join(configDir, "bin")
-	path := os.Getenv("PATH")
-	if runtime.GOOS == "windows" {
-		path = os.Getenv("Path")
-	}
-	user := os.Getenv("USERPROFILE")
 	dirs := []string{}
 	seen := make(map[string]bool)
-	for _, dir := range filepath.SplitList(path) {
-		dir = strings.Replace(dir, "%%USERPROFILE%%", user, 1)
-		edir, err := filepath.EvalSymlinks(dir)
+	for _, dir := range filepath.SplitList(pathEnv()) {
+		if runtime.GOOS == "windows" {
+			dir = expandWindowsVars(dir)
+		}
+		edir, err := s.fs.evalSymlinks(dir)
 		if err != nil {
 			continue
 		}
@@ -336,9 +706,9 @@ func (s *PHPStore) pathDirectories(configDir string) []string {
 		}
 		if _, ok := seen[edir]; ok {
 			if dir != edir {
-				s.log("  Skipping %s (alias of %s), already in the PATH", dir, edir)
+				s.logWith([]interface{}{"source", "PATH", "path", dir, "verdict", "duplicate"}, "  Skipping %s (alias of %s), already in the PATH", dir, edir)
 			} else {
-				s.log("  Skipping %s, already in the PATH", dir)
+				s.logWith([]interface{}{"source", "PATH", "path", dir, "verdict", "duplicate"}, "  Skipping %s, already in the PATH", dir)
 			}
 			continue
 		}
@@ -44,9 +44,9 @@ func (s *PHPStore) doDiscover() {
 		s.log("Could not find home directory: %s", err)
 	}
 
-	// phpbrew
-	if homeDir != "" {
-		s.discoverFromDir(filepath.Join(homeDir, ".phpbrew", "php"), nil, nil, "phpbrew")
+	// phpbrew (builds can have a custom name, like php-8.2.0+fpm or legacy)
+	if dir := phpbrewBuildsDir(); dir != "" {
+		s.discoverFromDir(dir, nil, nil, "phpbrew")
 	}
 
 	// phpenv
@@ -58,7 +58,9 @@ func (s *PHPStore) doDiscover() {
 	s.addFromDir("/opt/lampp", nil, "XAMPP")
 
 	// homebrew
-	if out, err := exec.Command("brew", "--cellar").Output(); err == nil {
+	if !s.scansSource("homebrew") || s.dryRun {
+		// no need to run brew
+	} else if out, err := exec.Command("brew", "--cellar").Output(); err == nil {
 		prefix := strings.Trim(string(out), "\n")
 		// pattern example: php@5.6/5.6.33_9
 		s.discoverFromDir(prefix, nil, regexp.MustCompile("^php@(?:[\\d\\.]+)/(?:[\\d\\._]+)$"), "homebrew")
@@ -71,18 +73,21 @@ func (s *PHPStore) doDiscover() {
 		s.discoverFromDir("/usr/local", nil, regexp.MustCompile("^php5\\-[\\d\\.]+(?:RC|BETA)?\\d*\\-\\d+\\-\\d+$"), "Liip PHP")
 
 		// MAMP
-		s.discoverFromDir("/Applications/MAMP/bin/php/", nil, regexp.MustCompile("^php[\\d\\.]+(?:RC|BETA)?$"), "MAMP")
+		s.discoverFromDir("/Applications/MAMP/bin/php/", nil, phpVersionedName, "MAMP")
 
 		// MacPorts (/opt/local/sbin/php-fpm71, /opt/local/bin/php71)
-		s.discoverFromDir("/opt/local", regexp.MustCompile("^php(?:[\\d\\.]+)$"), nil, "MacPorts")
+		s.discoverFromDir("/opt/local", phpVersionedName, nil, "MacPorts")
 	}
 
 	if runtime.GOOS == "linux" {
 		// Ondrej PPA on Linux (bin/php7.2)
-		s.discoverFromDir("/usr", regexp.MustCompile("^php(?:[\\d\\.]+)$"), nil, "Ondrej PPA")
+		s.discoverFromDir("/usr", phpVersionedName, nil, "Ondrej PPA")
 
 		// Remi's RPM repository
-		s.discoverFromDir("/opt/remi", nil, regexp.MustCompile("^php(?:\\d+)/root/usr$"), "Remi's RPM")
+		s.discoverFromDir("/opt/remi", nil, regexp.MustCompile("^"+versionedNamePattern("php")+"/root/usr$"), "Remi's RPM")
+
+		// LiteSpeed (pattern example: lsphp83/bin/lsphp)
+		s.discoverFromDir("/usr/local/lsws", nil, lsphpVersionedName, "LiteSpeed")
 	}
 
 	// asdf-vm
@@ -90,7 +95,9 @@ func (s *PHPStore) doDiscover() {
 	cmd := exec.Command("asdf", "where", "php")
 	cmd.Stdout = &buf
 	cmd.Stderr = &buf
-	if err := cmd.Run(); err == nil {
+	if !s.scansSource("asdf-vm") || s.dryRun {
+		// no need to run asdf
+	} else if err := cmd.Run(); err == nil {
 		s.discoverFromDir(filepath.Dir(buf.String()), nil, nil, "asdf-vm")
 	}
 }
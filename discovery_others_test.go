@@ -0,0 +1,1689 @@
+//go:build !windows
+// +build !windows
+
+package phpstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakePHP creates a PHP installation in dir whose php binary reports the given version
+func fakePHP(t *testing.T, dir, version string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf("#!/bin/sh\necho 'PHP %s (cli) (built: Jan  1 2024 00:00:00) (NTS)'\n", version)
+	if err := os.WriteFile(filepath.Join(dir, "bin", "php"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParallelDiscovery(t *testing.T) {
+	root := t.TempDir()
+	store := New(t.TempDir(), false, nil, WithDiscoveryConcurrency(4))
+	store.versions = nil
+	store.pathVersion = nil
+	store.seen = make(map[string]int)
+
+	expected := []string{"8.2.1", "7.4.33", "8.3.4", "8.1.14", "8.0.27", "8.2.12"}
+	for i, v := range expected {
+		dir := filepath.Join(root, fmt.Sprintf("php%d", i))
+		fakePHP(t, dir, v)
+		store.addFromDir(dir, nil, "testing")
+	}
+	// the same binary found twice is only probed and added once
+	store.addFromDir(filepath.Join(root, "php0"), nil, "testing")
+	store.runProbes()
+
+	if len(store.versions) != len(expected) {
+		t.Fatalf("%d versions should have been discovered, got %d", len(expected), len(store.versions))
+	}
+	for i, v := range store.versions {
+		if v.Version != expected[i] {
+			t.Errorf("versions should be added in discovery order: expected %s at position %d, got %s", expected[i], i, v.Version)
+		}
+		if v.Source != "testing" {
+			t.Errorf("the discovery source should be recorded, got %q", v.Source)
+		}
+	}
+}
+
+func TestDiscoveryEvents(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, filepath.Join(root, "php1"), "8.1.14")
+	fakePHP(t, filepath.Join(root, "php2"), "8.2.1")
+
+	started := map[string]bool{}
+	found := map[string][]string{}
+	done := map[string]int{}
+	store := New(t.TempDir(), false, nil, WithDiscoveryEvents(DiscoveryEvents{
+		OnSourceStart: func(source string) {
+			started[source] = true
+		},
+		OnVersionFound: func(source string, v *Version) {
+			if !started[source] {
+				t.Errorf("source %s should be started before finding versions", source)
+			}
+			found[source] = append(found[source], v.Version)
+		},
+		OnSourceDone: func(source string, count int) {
+			done[source] = count
+		},
+	}))
+	// forget about the events triggered by the discovery of New()
+	started, found, done = map[string]bool{}, map[string][]string{}, map[string]int{}
+
+	store.addFromDir(filepath.Join(root, "php1"), nil, "first")
+	store.addFromDir(filepath.Join(root, "php2"), nil, "second")
+	store.addFromDir(filepath.Join(root, "missing"), nil, "second")
+	store.discoverFromDir(filepath.Join(root, "missing"), nil, nil, "empty")
+	store.runProbes()
+
+	for source, count := range map[string]int{"first": 1, "second": 1, "empty": 0} {
+		if !started[source] {
+			t.Errorf("source %s should have been started", source)
+		}
+		if c, ok := done[source]; !ok || c != count {
+			t.Errorf("source %s should be done with %d versions, got %d", source, count, c)
+		}
+		if len(found[source]) != count {
+			t.Errorf("source %s should have found %d versions, got %v", source, count, found[source])
+		}
+	}
+}
+
+type recordingLogger struct {
+	records []map[string]interface{}
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) {
+	record := map[string]interface{}{"msg": msg}
+	for i := 0; i+1 < len(args); i += 2 {
+		record[args[i].(string)] = args[i+1]
+	}
+	l.records = append(l.records, record)
+}
+
+func TestStructuredLogger(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, filepath.Join(root, "php"), "8.2.1")
+
+	logger := &recordingLogger{}
+	store := New(t.TempDir(), false, nil, WithStructuredLogger(logger))
+	logger.records = nil
+	store.addFromDir(filepath.Join(root, "php"), nil, "testing")
+	store.runProbes()
+
+	for _, record := range logger.records {
+		if record["verdict"] == "found" {
+			if record["version"] != "8.2.1" {
+				t.Errorf("found record should have the version, got %v", record)
+			}
+			if !strings.HasPrefix(record["msg"].(string), "Found PHP: ") {
+				t.Errorf("found record should have a message, got %v", record)
+			}
+			return
+		}
+	}
+	t.Errorf("a found record should have been logged, got %v", logger.records)
+}
+
+func TestDiscoveryStoreLogs(t *testing.T) {
+	logger := &recordingLogger{}
+	var messages []string
+	store := New(t.TempDir(), false, func(msg string, a ...interface{}) {
+		messages = append(messages, fmt.Sprintf(msg, a...))
+	}, WithStructuredLogger(logger), WithNativePreference())
+	logger.records, messages = nil, nil
+
+	fresh := store.newDiscoveryStore()
+	fresh.log("refreshing")
+	if len(messages) != 1 || len(logger.records) != 1 {
+		t.Errorf("the discovery store should log through the loggers of the store, got %v and %v", messages, logger.records)
+	}
+	if !fresh.preferNative {
+		t.Error("the discovery store should share the options of the store")
+	}
+}
+
+func TestDiscoveryReport(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, filepath.Join(root, "php1"), "8.1.14")
+	fakePHP(t, filepath.Join(root, "php2"), "8.2.1")
+	if err := os.MkdirAll(filepath.Join(root, "broken", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "broken", "bin", "php"), []byte("#!/bin/sh\necho 'not PHP'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	store := New(t.TempDir(), false, nil)
+	store.report = &DiscoveryReport{}
+	store.addFromDir(filepath.Join(root, "php1"), nil, "first")
+	store.addFromDir(filepath.Join(root, "php2"), nil, "second")
+	store.addFromDir(filepath.Join(root, "broken"), nil, "second")
+	store.runProbes()
+
+	report := store.DiscoveryReport()
+	if len(report.Sources) != 2 {
+		t.Fatalf("2 sources should be reported, got %d", len(report.Sources))
+	}
+	second := report.source("second")
+	if second.Probed != 2 || second.Found != 1 || len(second.Errors) != 1 {
+		t.Errorf("second source should have probed 2 binaries, found 1 version and 1 error, got %+v", second)
+	}
+	if !strings.Contains(report.String(), "is not a PHP binary") {
+		t.Errorf("the report dump should contain errors, got %s", report)
+	}
+
+	cached := New(store.configDir, false, nil)
+	if !cached.DiscoveryReport().FromCache {
+		t.Error("the report should tell that versions were loaded from the cache")
+	}
+}
+
+func TestReprobeChangedBinaries(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, filepath.Join(root, "php"), "8.2.1")
+	fakePHP(t, filepath.Join(root, "other"), "8.1.14")
+
+	configDir := t.TempDir()
+	store := New(configDir, false, nil)
+	store.versions = nil
+	store.seen = make(map[string]int)
+	store.addFromDir(filepath.Join(root, "php"), nil, "testing")
+	store.addFromDir(filepath.Join(root, "other"), nil, "testing")
+	store.runProbes()
+	discoveredAt := make(map[string]time.Time)
+	for _, v := range store.versions {
+		if v.DiscoveredAt.IsZero() || !v.LastVerifiedAt.Equal(v.DiscoveredAt) {
+			t.Fatalf("discovered versions should record when they were found, got %+v", v)
+		}
+		discoveredAt[v.PHPPath] = v.DiscoveredAt
+	}
+	store.writeCache()
+
+	// in-place upgrade
+	fakePHP(t, filepath.Join(root, "php"), "8.2.2")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "php", "bin", "php"), future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	store = New(configDir, false, nil)
+	var found []string
+	for _, v := range store.Versions() {
+		found = append(found, v.Version)
+	}
+	if strings.Join(found, " ") != "8.1.14 8.2.2" {
+		t.Errorf("changed binaries should be probed again, got %v", found)
+	}
+	for _, v := range store.Versions() {
+		if !v.DiscoveredAt.Equal(discoveredAt[v.PHPPath]) {
+			t.Errorf("probing a version again should keep its discovery time, got %s", v.DiscoveredAt)
+		}
+		if v.Version == "8.2.2" && !v.LastVerifiedAt.After(v.DiscoveredAt) {
+			t.Errorf("probing a version again should update its verification time, got %s", v.LastVerifiedAt)
+		}
+	}
+}
+
+func TestCacheMigration(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, filepath.Join(root, "php"), "8.2.1")
+
+	configDir := t.TempDir()
+	// schema version 1 was a list of versions
+	legacy := fmt.Sprintf(`[{"version": "8.2.0", "path": %q, "php_path": %q, "is_system": true}]`, filepath.Join(root, "php"), filepath.Join(root, "php", "bin", "php"))
+	if err := os.WriteFile(filepath.Join(configDir, "php_versions.json"), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := New(configDir, false, nil)
+	versions := store.Versions()
+	if len(versions) != 1 || versions[0].Version != "8.2.1" || versions[0].BinarySize == 0 || !versions[0].IsSystem {
+		t.Fatalf("cached versions should be probed again when migrating, got %+v", versions)
+	}
+	contents, err := os.ReadFile(store.cachePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), fmt.Sprintf(`"schema_version": %d`, SchemaVersion)) {
+		t.Errorf("the migrated cache should be stored with the current schema version, got %s", contents)
+	}
+	if len(cacheMigrations) != SchemaVersion-1 {
+		t.Errorf("each schema version should have a migration, got %d for schema version %d", len(cacheMigrations), SchemaVersion)
+	}
+}
+
+func TestCacheMigrationReprobesOnce(t *testing.T) {
+	root := t.TempDir()
+	runs := filepath.Join(root, "runs")
+	if err := os.MkdirAll(filepath.Join(root, "php", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho run >> " + runs + "\necho 'PHP 8.2.1 (cli) (built: Jan  1 2024 00:00:00) (NTS)'\n"
+	if err := os.WriteFile(filepath.Join(root, "php", "bin", "php"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	store := New(t.TempDir(), false, nil)
+	countRuns := func(migrations []cacheMigration) int {
+		defer func(m []cacheMigration) { cacheMigrations = m }(cacheMigrations)
+		cacheMigrations = migrations
+		os.Remove(runs)
+		vs := store.migrateCache(versions{{Version: "8.2.0", Path: filepath.Join(root, "php"), PHPPath: filepath.Join(root, "php", "bin", "php")}}, 1)
+		if len(vs) != 1 || vs[0].Version != "8.2.1" {
+			t.Fatalf("cached versions should be probed again, got %v", vs)
+		}
+		contents, _ := os.ReadFile(runs)
+		return strings.Count(string(contents), "run")
+	}
+	once := countRuns([]cacheMigration{{reprobe: true}})
+	migrated := 0
+	chain := countRuns([]cacheMigration{{reprobe: true}, {reprobe: true}, {reprobe: true, migrate: func(s *PHPStore, vs versions) versions {
+		migrated++
+		return vs
+	}}})
+	if once == 0 || chain != once {
+		t.Errorf("versions should be probed once when migrating through several schema versions, got %d runs instead of %d", chain, once)
+	}
+	if migrated != 1 {
+		t.Errorf("the migrations should be run, got %d", migrated)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	root := t.TempDir()
+	configDir := t.TempDir()
+	t.Setenv("PATH", filepath.Join(root, "path", "bin"))
+	store := New(configDir, false, nil)
+	store.setVersions(nil)
+
+	fakePHP(t, filepath.Join(root, "manual"), "8.4.1")
+	store.RefreshDir(filepath.Join(root, "manual", "bin"))
+	if v, err := store.BestVersionForConstraint("8.4", ""); err != nil || v.Version != "8.4.1" {
+		t.Errorf("the refreshed directory should be added to the store, got %v", v)
+	}
+
+	fakePHP(t, filepath.Join(root, "path"), "8.3.2")
+	store.Refresh("path")
+	if store.pathVersion == nil || store.pathVersion.Version != "8.3.2" {
+		t.Errorf("the refreshed source should be discovered again, got %v", store.pathVersion)
+	}
+
+	if err := os.RemoveAll(filepath.Join(root, "manual")); err != nil {
+		t.Fatal(err)
+	}
+	store.Refresh("PATH")
+	var found []string
+	for _, v := range New(configDir, false, nil).Versions() {
+		found = append(found, v.Version)
+	}
+	if strings.Join(found, " ") != "8.3.2" {
+		t.Errorf("removed versions should be removed from the cache, got %v", found)
+	}
+}
+
+func TestDiscoverFrankenPHP(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("PATH", filepath.Join(root, "bin"))
+	fakePHP(t, root, "8.3.2")
+	script := `#!/bin/sh
+[ "$1" = "php-cli" ] || exit 1
+case "$2" in
+-v) echo 'PHP 8.4.3 (cli) (built: Jan 21 2025 10:12:32) (ZTS)';;
+-m) printf '[PHP Modules]\nCore\nZend OPcache\nintl\n\n[Zend Modules]\nZend OPcache\n';;
+*) exit 1;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(root, "bin", "frankenphp"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	store := New(t.TempDir(), false, nil)
+	store.setVersions(nil)
+	store.Refresh("PATH")
+	if store.pathVersion == nil || store.pathVersion.Version != "8.3.2" {
+		t.Errorf("FrankenPHP should not be the PHP of the PATH, got %v", store.pathVersion)
+	}
+	v, err := store.BestVersionForConstraint("8.4", FlavorFrankenPHP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.FrankenPHP || !v.ThreadSafe || v.PHPPath != filepath.Join(root, "bin", "frankenphp") {
+		t.Errorf("the embedded PHP of FrankenPHP should be discovered, got %+v", v)
+	}
+	if strings.Join(v.Extensions, " ") != "core zend-opcache intl" || !v.HasExtension("ext-intl") {
+		t.Errorf("the embedded extensions of FrankenPHP should be reported, got %v", v.Extensions)
+	}
+	if problems := v.Validate(); len(problems) != 0 {
+		t.Errorf("FrankenPHP should be validated via php-cli, got %v", problems)
+	}
+}
+
+func TestDirenvSource(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "project")
+	fakePHP(t, filepath.Join(project, ".direnv"), "8.4.1")
+	fakePHP(t, filepath.Join(root, "system"), "8.3.2")
+	for path, contents := range map[string]string{
+		filepath.Join(project, ".envrc"):       "PATH_add .direnv/bin\n",
+		filepath.Join(project, ".php-version"): "8.3\n",
+	} {
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	systemPath := filepath.Join(root, "system", "bin")
+	direnvDiff := func(previous map[string]string) string {
+		contents, _ := json.Marshal(map[string]interface{}{"p": previous, "n": map[string]string{}})
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(contents)
+		w.Close()
+		return base64.URLEncoding.EncodeToString(buf.Bytes())
+	}
+
+	store := New(t.TempDir(), false, nil)
+	store.setVersions(nil)
+	store.addVersion(&Version{Version: "8.3.2", Path: filepath.Join(root, "system"), PHPPath: filepath.Join(systemPath, "php")})
+
+	t.Setenv("PATH", filepath.Join(project, ".direnv", "bin")+string(os.PathListSeparator)+systemPath)
+	t.Setenv("DIRENV_DIR", "-"+project)
+	t.Setenv("DIRENV_DIFF", direnvDiff(map[string]string{"PATH": systemPath}))
+	v, source, _, err := store.BestVersionForDir(filepath.Join(project, "src"))
+	if err != nil || v.Version != "8.4.1" || v.PHPPath != filepath.Join(project, ".direnv", "bin", "php") {
+		t.Errorf("the PHP binary injected by direnv should be used, got %v (%v)", v, err)
+	}
+	if source != "direnv: "+filepath.Join(project, ".envrc") {
+		t.Errorf("the .envrc file should be the source, got %s", source)
+	}
+	if v, _, _, _ := store.BestVersionForDir(root); v == nil || v.Version != "8.3.2" {
+		t.Errorf("the PHP binary injected by direnv should only be used in the project, got %v", v)
+	}
+
+	t.Setenv("PATH", systemPath)
+	t.Setenv("DIRENV_DIFF", direnvDiff(map[string]string{"FOO": "bar"}))
+	if v, source, _, _ := store.BestVersionForDir(project); v == nil || v.Version != "8.3.2" || !strings.Contains(source, ".php-version") {
+		t.Errorf("the PHP binary should be ignored when direnv did not change it, got %v (%s)", v, source)
+	}
+
+	// only SourceDirenv requires a binary: paths found elsewhere are not run
+	marker := filepath.Join(root, "ran")
+	script := filepath.Join(root, "script", "bin", "php")
+	if err := os.MkdirAll(filepath.Dir(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n: > "+marker+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(project, ".php-version"), []byte(filepath.Join(root, "script")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _, _ := store.BestVersionForDir(project); v == nil || v.Version != "8.3.2" {
+		t.Errorf("a path in .php-version should fall back to the default version, got %v", v)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("a path in .php-version should not be executed")
+	}
+}
+
+func TestDiscoverContainers(t *testing.T) {
+	root := t.TempDir()
+	// the PHP binaries of the container, run on the host by the fake toolbox
+	fakePHP(t, filepath.Join(root, "container"), "8.3.2")
+	if err := os.WriteFile(filepath.Join(root, "container", "bin", "php-fpm"), []byte("#!/bin/sh\necho 'PHP 8.3.2 (fpm-fcgi)'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	toolbox := `#!/bin/sh
+case "$1" in
+list)
+	echo "CONTAINER ID  CONTAINER NAME  CREATED     STATUS   IMAGE NAME"
+	echo "a1b2c3d4e5f6  fedora-php      2 days ago  running  registry.fedoraproject.org/fedora-toolbox:40"
+	echo "f6e5d4c3b2a1  empty           1 day ago   exited   registry.fedoraproject.org/fedora-toolbox:40"
+	;;
+run)
+	name=$3
+	shift 3
+	if [ "$name" = empty ]; then
+		PATH=/nonexistent exec /bin/sh -c "$3"
+	fi
+	exec "$@"
+	;;
+esac
+`
+	if err := os.MkdirAll(filepath.Join(root, "tools"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "tools", "toolbox"), []byte(toolbox), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", strings.Join([]string{filepath.Join(root, "tools"), filepath.Join(root, "container", "bin"), "/usr/bin", "/bin"}, string(os.PathListSeparator)))
+
+	configDir := t.TempDir()
+	store := New(configDir, false, nil)
+	store.setVersions(nil)
+	vs, err := store.DiscoverContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 1 {
+		t.Fatalf("the version of the container with PHP should be registered, got %v", vs)
+	}
+	v := vs[0]
+	wrappers := filepath.Join(configDir, "containers", ContainerToolbox, "fedora-php")
+	if v.Version != "8.3.2" || v.Container != "fedora-php" || v.ContainerRuntime != ContainerToolbox || v.Label() != "8.3.2 (toolbox: fedora-php, FPM)" {
+		t.Errorf("the container should be recorded, got %+v", v)
+	}
+	if v.PHPPath != filepath.Join(wrappers, "bin", "php") || v.FPMPath != filepath.Join(wrappers, "sbin", "php-fpm") {
+		t.Errorf("the wrappers should be the binaries of the version, got %s and %s", v.PHPPath, v.FPMPath)
+	}
+	if out, err := v.Command(context.Background(), "-v").Output(); err != nil || !strings.HasPrefix(string(out), "PHP 8.3.2 (cli)") {
+		t.Errorf("the PHP binary of the container should be run, got %q (%v)", out, err)
+	}
+	if got, err := New(configDir, false, nil).BestVersionForConstraint("8.3", FlavorFPM); err != nil || got.Container != "fedora-php" {
+		t.Errorf("the version of the container should be cached, got %v (%v)", got, err)
+	}
+
+	if _, err := store.Unregister(v.PHPPath, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(wrappers); !os.IsNotExist(err) {
+		t.Errorf("the wrappers should be removed, got %v", err)
+	}
+}
+
+func TestDiscoverPodmanContainers(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, filepath.Join(root, "container"), "8.2.18")
+	podman := `#!/bin/sh
+case "$1" in
+ps)
+	echo "php-app map[maintainer:me]"
+	echo "fedora-toolbox-40 map[com.github.containers.toolbox:true]"
+	;;
+exec)
+	[ "$2" = -i ] && [ "$3" = php-app ] || exit 125
+	shift 3
+	exec "$@"
+	;;
+esac
+`
+	if err := os.MkdirAll(filepath.Join(root, "tools"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "tools", "podman"), []byte(podman), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", strings.Join([]string{filepath.Join(root, "tools"), filepath.Join(root, "container", "bin"), "/usr/bin", "/bin"}, string(os.PathListSeparator)))
+
+	store := New(t.TempDir(), false, nil)
+	store.setVersions(nil)
+	vs, err := store.DiscoverContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 1 || vs[0].Version != "8.2.18" || vs[0].Container != "php-app" || vs[0].ContainerRuntime != ContainerPodman {
+		t.Errorf("only the Podman container not managed by toolbox should be registered, got %v", vs)
+	}
+	if _, err := store.RegisterContainer(context.Background(), ContainerPodman, "stopped"); err == nil {
+		t.Error("registering a container that cannot run commands should fail")
+	}
+}
+
+func TestRegisterPath(t *testing.T) {
+	root := t.TempDir()
+	configDir := t.TempDir()
+	t.Setenv("PATH", "")
+	store := New(configDir, false, nil)
+	store.setVersions(nil)
+
+	if _, err := store.RegisterPath(filepath.Join(root, "missing")); err == nil {
+		t.Error("registering a missing path should fail")
+	}
+
+	fakePHP(t, filepath.Join(root, "custom"), "8.4.2")
+	v, err := store.RegisterPath(filepath.Join(root, "custom", "bin", "php"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != "8.4.2" || v.Source != "registered" {
+		t.Errorf("the registered version should be returned, got %s (%s)", v.Version, v.Source)
+	}
+	if _, err := store.RegisterPath(filepath.Join(root, "custom")); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Versions()) != 1 {
+		t.Errorf("registering the same installation twice should not duplicate it, got %d versions", len(store.Versions()))
+	}
+
+	// registered paths survive a full discovery
+	reloaded := New(configDir, true, nil)
+	if len(reloaded.registeredPaths) != 2 {
+		t.Errorf("registered paths should be persisted, got %v", reloaded.registeredPaths)
+	}
+	if _, err := reloaded.BestVersionForConstraint("8.4.2", ""); err != nil {
+		t.Errorf("registered paths should be discovered again: %s", err)
+	}
+
+	// registered paths that were unavailable (like an unmounted network
+	// share) are restored when loading the cache
+	if err := os.Rename(filepath.Join(root, "custom"), filepath.Join(root, "unmounted")); err != nil {
+		t.Fatal(err)
+	}
+	if len(New(configDir, false, nil).Versions()) != 0 {
+		t.Error("unavailable registered paths should be removed from the cache")
+	}
+	if err := os.Rename(filepath.Join(root, "unmounted"), filepath.Join(root, "custom")); err != nil {
+		t.Fatal(err)
+	}
+	if vs := New(configDir, false, nil).Versions(); len(vs) != 1 || vs[0].Source != "registered" {
+		t.Errorf("registered paths should be probed again when missing from the cache, got %v", vs)
+	}
+	if paths := store.RegisteredPaths(); len(paths) != 2 {
+		t.Errorf("RegisteredPaths should return the registered paths, got %v", paths)
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	root := t.TempDir()
+	configDir := t.TempDir()
+	fakePHP(t, filepath.Join(root, "xampp"), "8.2.1")
+	fakePHP(t, filepath.Join(root, "old"), "7.4.33")
+	fakePHP(t, filepath.Join(root, "new"), "8.3.2")
+	t.Setenv("PATH", strings.Join([]string{filepath.Join(root, "xampp", "bin"), filepath.Join(root, "old", "bin"), filepath.Join(root, "new", "bin")}, string(os.PathListSeparator)))
+
+	store := New(configDir, true, nil)
+	if len(store.Versions()) != 3 {
+		t.Fatalf("3 versions should be discovered, got %v", store.Versions())
+	}
+	if err := store.IgnorePath(filepath.Join(root, "xampp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.IgnoreVersion("7.4"); err != nil {
+		t.Fatal(err)
+	}
+	if vs := store.Versions(); len(vs) != 1 || vs[0].Version != "8.3.2" {
+		t.Errorf("ignored versions should be removed from the store, got %v", vs)
+	}
+
+	// the ignored binary must not even be run
+	if err := os.WriteFile(filepath.Join(root, "xampp", "bin", "php"), []byte("#!/bin/sh\nsleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, reload := range []bool{false, true} {
+		if vs := New(configDir, reload, nil).Versions(); len(vs) != 1 || vs[0].Version != "8.3.2" {
+			t.Errorf("ignored versions should stay hidden (reload: %v), got %v", reload, vs)
+		}
+	}
+}
+
+func TestVersionCommand(t *testing.T) {
+	root := t.TempDir()
+	script := "#!/bin/sh\necho \"$(basename $0) $* $PHP_FCGI_MAX_REQUESTS\"\n"
+	for _, name := range []string{"php", "php-fpm", "php-cgi"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v := &Version{Version: "8.3.2", PHPPath: filepath.Join(root, "php"), CGIPath: filepath.Join(root, "php-cgi")}
+
+	run := func(cmd *exec.Cmd) string {
+		t.Helper()
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if out := run(v.Command(context.Background(), "-v")); out != "php -v" {
+		t.Errorf("unexpected CLI command output %q", out)
+	}
+	if out := run(v.ServerCommand(context.Background(), "-b", "127.0.0.1:9000")); out != "php-cgi -b 127.0.0.1:9000 0" {
+		t.Errorf("unexpected CGI command output %q", out)
+	}
+	v.FPMPath = filepath.Join(root, "php-fpm")
+	if out := run(v.ServerCommand(context.Background(), "-y", "fpm.conf")); out != "php-fpm --nodaemonize -y fpm.conf" {
+		t.Errorf("unexpected FPM command output %q", out)
+	}
+	v.FrankenPHP = true
+	if out := run(v.Command(context.Background(), "-v")); out != "php php-cli -v" {
+		t.Errorf("unexpected FrankenPHP command output %q", out)
+	}
+}
+
+func TestVersionValidate(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, root, "8.3.2")
+	v, err := NewVersionFromPath(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := v.Validate(); problems != nil {
+		t.Errorf("a valid version should have no problems, got %s", problems)
+	}
+
+	fakePHP(t, root, "8.3.3")
+	v.FPMPath = filepath.Join(root, "sbin", "php-fpm")
+	problems := v.Validate()
+	if len(problems) != 2 || problems[0].Kind != ProblemVersionMismatch || problems[0].Actual != "8.3.3" || problems[1].Kind != ProblemCompanionMissing {
+		t.Errorf("upgraded binaries and missing companions should be reported, got %s", problems)
+	}
+
+	if err := os.Remove(v.PHPPath); err != nil {
+		t.Fatal(err)
+	}
+	if problems := v.Validate(); len(problems) != 1 || problems[0].Kind != ProblemBinaryMissing {
+		t.Errorf("removed binaries should be reported, got %s", problems)
+	}
+}
+
+func TestImport(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("PATH", "")
+	fakePHP(t, filepath.Join(root, "baked"), "8.2.1")
+	fakePHP(t, filepath.Join(root, "local"), "8.3.2")
+
+	baked := New(t.TempDir(), false, nil)
+	baked.RefreshDir(filepath.Join(root, "baked"))
+	baked.RefreshDir(filepath.Join(root, "local"))
+	baked.versions = append(baked.versions, &Version{Version: "7.4.33", Path: filepath.Join(root, "missing"), PHPPath: filepath.Join(root, "missing", "bin", "php")})
+	contents, err := baked.MarshalReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configDir := t.TempDir()
+	store := New(configDir, false, nil)
+	store.RefreshDir(filepath.Join(root, "local"))
+	added, err := store.Import(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Errorf("only unknown versions with an existing binary should be imported, got %d", added)
+	}
+	var found []string
+	for _, v := range New(configDir, false, nil).Versions() {
+		found = append(found, v.Version)
+	}
+	if strings.Join(found, " ") != "8.2.1 8.3.2" {
+		t.Errorf("imported versions should be cached, got %v", found)
+	}
+
+	if _, err := store.Import([]byte("not json")); err == nil {
+		t.Error("importing an invalid document should fail")
+	}
+}
+
+func TestDiscoverRemote(t *testing.T) {
+	bin := t.TempDir()
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	// the fake ssh command records its arguments and runs the script locally
+	args := filepath.Join(bin, "args")
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte("#!/bin/sh\necho \"$*\" >> "+args+"\nexec sh -s\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := `#!/bin/sh
+case "$*" in
+*-r*) echo '{"version":"8.3.9","zts":false,"debug":false,"extension_dir":"/nonexistent","extensions":["Core","intl"],"extension_versions":["8.3.9",""],"arch":"x86_64"}' ;;
+*) echo 'PHP 8.3.9 (cli)' ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, "php"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	store := New(t.TempDir(), false, nil)
+	local := len(store.Versions())
+	vs, err := store.DiscoverRemote(context.Background(), "deploy@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 1 || vs[0].Version != "8.3.9" || !vs[0].Remote || vs[0].Host != "deploy@example.com" || vs[0].PHPPath != filepath.Join(bin, "php") || !vs[0].HasExtension("intl") {
+		t.Fatalf("remote versions should be discovered, got %+v", vs)
+	}
+	if len(store.RemoteVersions("deploy@example.com")) != 1 {
+		t.Error("remote versions should be kept by the store")
+	}
+	if len(store.Versions()) != local {
+		t.Error("remote versions should not be added to local versions")
+	}
+
+	if _, err := store.DiscoverRemote(context.Background(), "-oProxyCommand=touch /tmp/pwned"); err == nil {
+		t.Error("hosts starting with a dash should be rejected")
+	}
+	if contents, _ := os.ReadFile(args); string(contents) != "-o BatchMode=yes -- deploy@example.com sh -s\n" {
+		t.Errorf("the host should be passed after the options only, got %q", contents)
+	}
+}
+
+func TestDiscoveryShims(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, filepath.Join(root, "versions", "8.3.2"), "8.3.2")
+	if err := os.MkdirAll(filepath.Join(root, "shims"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "shims", "php"), []byte("#!/usr/bin/env bash\nexec phpenv exec php \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	manager := fmt.Sprintf("#!/bin/sh\n[ \"$1\" = which ] && echo %s\n", filepath.Join(root, "versions", "8.3.2", "bin", "php"))
+	if err := os.WriteFile(filepath.Join(root, "bin", "phpenv"), []byte(manager), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", filepath.Join(root, "shims")+string(os.PathListSeparator)+filepath.Join(root, "bin"))
+
+	store := New(t.TempDir(), false, nil)
+	store.versions = nil
+	store.pathVersion = nil
+	store.seen = make(map[string]int)
+	store.discover()
+	if store.pathVersion == nil || store.pathVersion.PHPPath != filepath.Join(root, "versions", "8.3.2", "bin", "php") {
+		t.Fatalf("the shim should be resolved to the real installation, got %v", store.pathVersion)
+	}
+	for _, v := range store.versions {
+		if strings.HasPrefix(v.PHPPath, filepath.Join(root, "shims")) {
+			t.Errorf("the shim should not be registered as an installation, got %s", v.PHPPath)
+		}
+	}
+}
+
+func TestDiscoveryPrerelease(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, root, "8.4.0RC2")
+	v, err := NewVersionFromPath(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != "8.4.0RC2" || v.FullVersion.Prerelease() == "" {
+		t.Errorf("pre-releases should be discovered, got %s", v.Version)
+	}
+}
+
+func TestDiscoveryVersionSuffix(t *testing.T) {
+	for raw, expected := range map[string][2]string{
+		"8.3.6-1ubuntu1":     {"8.3.6", "-1ubuntu1"},
+		"8.1.2-1ubuntu2.14":  {"8.1.2", "-1ubuntu2.14"},
+		"8.2.20-dev":         {"8.2.20", "-dev"},
+		"8.4.0RC2+ubuntu1.2": {"8.4.0RC2", "+ubuntu1.2"},
+	} {
+		root := t.TempDir()
+		fakePHP(t, root, raw)
+		v, err := NewVersionFromPath(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Version != expected[0] || v.VersionSuffix != expected[1] {
+			t.Errorf("%s should be discovered as %s with suffix %s, got %s and %s", raw, expected[0], expected[1], v.Version, v.VersionSuffix)
+		}
+	}
+}
+
+func TestDiscoveryProblems(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, filepath.Join(root, "good"), "8.3.2")
+	if err := os.MkdirAll(filepath.Join(root, "broken", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "broken", "bin", "php"), []byte("#!/bin/sh\necho 'not PHP'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", filepath.Join(root, "good", "bin")+string(os.PathListSeparator)+filepath.Join(root, "broken", "bin"))
+
+	store := New(t.TempDir(), true, nil)
+	reported := false
+	for _, p := range store.Problems() {
+		if p.Path == filepath.Join(root, "broken", "bin", "php") {
+			reported = p.Kind == ProblemProbeFailed && p.Source == "PATH" && p.Err != nil
+		}
+	}
+	if !reported {
+		t.Errorf("unusable binaries should be reported, got %s", store.Problems())
+	}
+	if problems := New(store.configDir, false, nil).Problems(); len(problems) != 0 {
+		t.Errorf("no problems should be reported when loading from the cache, got %s", problems)
+	}
+}
+
+func TestProbeTimeoutAndEnv(t *testing.T) {
+	defer func(timeout time.Duration) { probeTimeout = timeout }(probeTimeout)
+	probeTimeout = 200 * time.Millisecond
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "hanging", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "hanging", "bin", "php"), []byte("#!/bin/sh\nexec /bin/sleep 30\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the binary fails when the user configuration leaks into the probe
+	if err := os.MkdirAll(filepath.Join(root, "sanitized", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\n[ -n \"$PHPRC$PHP_INI_SCAN_DIR$XDEBUG_CONFIG\" ] && exit 1\necho 'PHP 8.3.2 (cli) (built: Jan  1 2024 00:00:00) (NTS)'\n"
+	if err := os.WriteFile(filepath.Join(root, "sanitized", "bin", "php"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PHPRC", filepath.Join(root, "broken.ini"))
+	t.Setenv("PHP_INI_SCAN_DIR", root)
+	t.Setenv("XDEBUG_CONFIG", "idekey=PHPSTORM")
+	t.Setenv("PATH", filepath.Join(root, "hanging", "bin")+string(os.PathListSeparator)+filepath.Join(root, "sanitized", "bin"))
+
+	start := time.Now()
+	store := New(t.TempDir(), true, nil)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("hanging binaries should be killed, discovery took %s", elapsed)
+	}
+	found := false
+	for _, v := range store.Versions() {
+		if v.PHPPath == filepath.Join(root, "hanging", "bin", "php") {
+			t.Errorf("hanging binaries should not be discovered, got %s", v)
+		}
+		found = found || v.PHPPath == filepath.Join(root, "sanitized", "bin", "php")
+	}
+	if !found {
+		t.Errorf("binaries should be probed without the PHP configuration of the environment, got %v", store.Versions())
+	}
+}
+
+func TestDiscoveryStartupWarnings(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho \"PHP Warning:  Failed loading Zend extension 'xdebug.so' (PHP 8.2.0 required)\"\necho 'PHP 8.3.6 (cli) (built: Apr 15 2024 19:21:47) (NTS)'\n"
+	if err := os.WriteFile(filepath.Join(root, "bin", "php"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", filepath.Join(root, "bin"))
+
+	store := New(t.TempDir(), true, nil)
+	if versions := store.Versions(); len(versions) != 1 || versions[0].Version != "8.3.6" {
+		t.Fatalf("the version should be read after the startup warnings, got %v", versions)
+	}
+	problems := store.Problems()
+	if len(problems) != 1 || problems[0].Kind != ProblemStartupWarning || problems[0].Source != "PATH" || !strings.Contains(problems[0].Err.Error(), "xdebug.so") {
+		t.Errorf("the startup warnings should be reported as problems, got %s", problems)
+	}
+}
+
+func TestDiscoveryFPMOnly(t *testing.T) {
+	root := t.TempDir()
+	for path, banner := range map[string]string{
+		filepath.Join(root, "bin", "php8.2"):      "PHP 8.2.18 (cli) (built: Apr 11 2024 22:07:45) (NTS)",
+		filepath.Join(root, "sbin", "php-fpm8.2"): "PHP 8.2.18 (fpm-fcgi) (built: Apr 11 2024 22:07:45)",
+		filepath.Join(root, "sbin", "php-fpm8.3"): "PHP 8.3.6 (fpm-fcgi) (built: Apr 15 2024 19:21:47)",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("#!/bin/sh\necho '%s'\n", banner)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := New(t.TempDir(), false, nil)
+	store.setVersions(nil)
+	store.addFromDir(root, regexp.MustCompile("^php(?:[\\d\\.]+)$"), "testing")
+	store.runProbes()
+	if len(store.versions) != 2 {
+		t.Fatalf("2 versions should have been discovered, got %v", store.versions)
+	}
+	if v := store.versions[0]; v.Version != "8.2.18" || v.PHPPath != filepath.Join(root, "bin", "php8.2") || v.FPMPath != filepath.Join(root, "sbin", "php-fpm8.2") {
+		t.Errorf("FPM should be a companion of the PHP binary, got %+v", v)
+	}
+	if v := store.versions[1]; v.Version != "8.3.6" || v.PHPPath != "" || v.FPMPath != filepath.Join(root, "sbin", "php-fpm8.3") {
+		t.Errorf("FPM without PHP should be discovered, got %+v", v)
+	}
+}
+
+func TestDiscoverFromDir(t *testing.T) {
+	root := t.TempDir()
+	// like the Homebrew Cellar
+	fakePHP(t, filepath.Join(root, "php@8.2", "8.2.18"), "8.2.18")
+	fakePHP(t, filepath.Join(root, "php", "8.3.6"), "8.3.6")
+	fakePHP(t, filepath.Join(root, "php", "8.3.6", "nested", "8.3.1"), "8.3.1")
+	if err := os.WriteFile(filepath.Join(root, "php@8.1"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "php@8.2"), filepath.Join(root, "php@8.4")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "unreadable"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	store := New(t.TempDir(), false, nil)
+	store.setVersions(nil)
+	store.discoverFromDir(root, nil, regexp.MustCompile("^php(?:@[\\d\\.]+)?/(?:[\\d\\._]+)$"), "testing")
+	store.runProbes()
+	var found []string
+	for _, v := range store.versions {
+		found = append(found, v.Version)
+	}
+	if strings.Join(found, " ") != "8.3.6 8.2.18" {
+		t.Errorf("installations should be found up to the depth of the pattern, without following symlinks, got %v", found)
+	}
+	if os.Getuid() != 0 && (len(store.problems) != 1 || store.problems[0].Kind != ProblemDirUnreadable) {
+		t.Errorf("unreadable directories should be reported, got %s", store.problems)
+	}
+}
+
+func TestExcludedPaths(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, filepath.Join(root, "local"), "8.3.2")
+	fakePHP(t, filepath.Join(root, "share1"), "8.2.1")
+	fakePHP(t, filepath.Join(root, "cellar", "php", "8.1.2"), "8.1.2")
+	fakePHP(t, filepath.Join(root, "cellar", "php", "8.0.3"), "8.0.3")
+	t.Setenv("PATH", strings.Join([]string{filepath.Join(root, "local", "bin"), filepath.Join(root, "share1", "bin")}, string(os.PathListSeparator)))
+
+	store := New(t.TempDir(), true, nil, WithSources(), WithExcludedPaths(filepath.Join(root, "share*"), filepath.Join(root, "cellar", "*", "8.0.*")))
+	store.discoverFromDir(filepath.Join(root, "cellar"), nil, regexp.MustCompile("^php/(?:[\\d\\._]+)$"), "testing")
+	store.runProbes()
+	var found []string
+	for _, v := range store.versions {
+		found = append(found, v.Version)
+	}
+	sort.Strings(found)
+	if strings.Join(found, " ") != "8.1.2 8.3.2" {
+		t.Errorf("excluded directories should be skipped, got %v", found)
+	}
+}
+
+func TestConcurrentNew(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the binary is slow so that all stores are created during the discovery
+	script := fmt.Sprintf("#!/bin/sh\necho run >> %s\n/bin/sleep 0.2\necho 'PHP 8.3.2 (cli) (built: Jan  1 2024 00:00:00) (NTS)'\n", filepath.Join(root, "runs"))
+	if err := os.WriteFile(filepath.Join(root, "bin", "php"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", filepath.Join(root, "bin"))
+
+	configDir := t.TempDir()
+	stores := make([]*PHPStore, 5)
+	var wg sync.WaitGroup
+	for i := range stores {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stores[i] = New(configDir, false, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	runs, _ := os.ReadFile(filepath.Join(root, "runs"))
+	single := New(t.TempDir(), false, nil)
+	if len(single.Versions()) != 1 {
+		t.Fatalf("a version should be discovered, got %v", single.Versions())
+	}
+	singleRuns, _ := os.ReadFile(filepath.Join(root, "runs"))
+	if expected := len(singleRuns) - len(runs); len(runs) != expected {
+		t.Errorf("the binary should be run %d times, got %d", expected, len(runs))
+	}
+	for i, store := range stores {
+		versions := store.Versions()
+		if len(versions) != 1 || !versions[0].IsSystem {
+			t.Fatalf("all stores should get the discovered versions, got %v", versions)
+		}
+		if i > 0 && versions[0] == stores[0].Versions()[0] {
+			t.Error("all stores should get their own copy of the versions")
+		}
+	}
+}
+
+func TestCacheModes(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, root, "8.3.2")
+	t.Setenv("PATH", filepath.Join(root, "bin"))
+
+	configDir := t.TempDir()
+	cache := New(configDir, false, nil, WithNoCacheWrite()).cachePath()
+	if store := New(configDir, false, nil, WithMemoryCache()); len(store.Versions()) != 1 || store.DiscoveryReport().FromCache {
+		t.Fatalf("versions should be discovered, got %v", store.Versions())
+	}
+	if _, err := os.Stat(cache); !os.IsNotExist(err) {
+		t.Errorf("the memory cache should not be written on disk")
+	}
+	if store := New(configDir, false, nil, WithMemoryCache()); len(store.Versions()) != 1 || !store.DiscoveryReport().FromCache {
+		t.Errorf("the memory cache should be shared by stores, got %v", store.Versions())
+	}
+
+	if store := New(configDir, false, nil, WithNoCacheWrite()); len(store.Versions()) != 1 || store.DiscoveryReport().FromCache {
+		t.Fatalf("versions should be discovered, got %v", store.Versions())
+	}
+	if _, err := os.Stat(cache); !os.IsNotExist(err) {
+		t.Errorf("the cache should not be written")
+	}
+	New(configDir, false, nil)
+	if store := New(configDir, false, nil, WithNoCacheWrite()); !store.DiscoveryReport().FromCache {
+		t.Errorf("an existing cache should be used")
+	}
+	if store := New(configDir, true, nil, WithNoCacheWrite()); store.DiscoveryReport().FromCache {
+		t.Errorf("an existing cache should be ignored when reloading")
+	}
+	if _, err := os.Stat(cache); err != nil {
+		t.Errorf("the cache should not be removed when reloading, got %s", err)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, filepath.Join(root, "php"), "8.3.1")
+	t.Setenv("PATH", filepath.Join(root, "php", "bin"))
+
+	configDir := t.TempDir()
+	store := New(configDir, false, nil)
+	if changes := store.Changes(); len(changes.Added) != 1 || len(changes.Removed)+len(changes.Changed) != 0 {
+		t.Errorf("the discovered version should be added, got %q", changes)
+	}
+	if changes := New(configDir, false, nil).Changes(); !changes.Empty() {
+		t.Errorf("an unchanged cache should not have changes, got %q", changes)
+	}
+
+	// make sure the modification time changes
+	time.Sleep(10 * time.Millisecond)
+	fakePHP(t, filepath.Join(root, "php"), "8.3.2")
+	fakePHP(t, filepath.Join(root, "other"), "8.4.1")
+	t.Setenv("PATH", filepath.Join(root, "php", "bin")+string(os.PathListSeparator)+filepath.Join(root, "other", "bin"))
+	store.Refresh("PATH")
+	expected := fmt.Sprintf("New PHP 8.4.1 detected (PATH)\nPHP 8.3.1 upgraded to 8.3.2 (%s)", filepath.Join(root, "php", "bin", "php"))
+	if changes := store.Changes().String(); changes != expected {
+		t.Errorf("expected changes %q, got %q", expected, changes)
+	}
+
+	if err := os.RemoveAll(filepath.Join(root, "other")); err != nil {
+		t.Fatal(err)
+	}
+	if changes := New(configDir, true, nil).Changes(); len(changes.Removed) != 1 || changes.Removed[0].Version != "8.4.1" {
+		t.Errorf("the removed version should be reported when reloading, got %q", changes)
+	}
+}
+
+func TestNewVersionFromPath(t *testing.T) {
+	root := t.TempDir()
+	fakePHP(t, filepath.Join(root, "custom"), "8.4.2")
+	if err := os.WriteFile(filepath.Join(root, "custom", "bin", "php-cgi"), []byte("#!/bin/sh\necho 'PHP 8.4.2 (cgi-fcgi)'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{filepath.Join(root, "custom"), filepath.Join(root, "custom", "bin", "php")} {
+		v, err := NewVersionFromPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Version != "8.4.2" || v.CGIPath != filepath.Join(root, "custom", "bin", "php-cgi") || v.BinarySize == 0 {
+			t.Errorf("the version at %s should be fully probed, got %+v", path, v)
+		}
+	}
+	if _, err := NewVersionFromPath(filepath.Join(root, "missing")); err == nil {
+		t.Error("probing a missing path should fail")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	root := t.TempDir()
+	configDir := t.TempDir()
+	t.Setenv("PATH", filepath.Join(root, "bin"))
+	store := New(configDir, false, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 10)
+	go store.Watch(ctx, 10*time.Millisecond, func([]*Version) {
+		changed <- struct{}{}
+	})
+	waitFor := func(available bool) bool {
+		timeout := time.After(5 * time.Second)
+		for store.IsVersionAvailable("8.4.3") != available {
+			select {
+			case <-changed:
+			case <-timeout:
+				return false
+			}
+		}
+		return true
+	}
+
+	// let the watcher take a snapshot of the directories first
+	time.Sleep(100 * time.Millisecond)
+	fakePHP(t, root, "8.4.3")
+	if !waitFor(true) {
+		t.Fatal("the installed version should be added to the store")
+	}
+	if source := store.DiscoveryReport().source("PATH"); source == nil || source.Found != 1 {
+		t.Errorf("the discovery report should be updated, got %s", store.DiscoveryReport())
+	}
+	if _, err := New(configDir, false, nil).BestVersionForConstraint("8.4.3", ""); err != nil {
+		t.Errorf("the installed version should be added to the cache: %s", err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(root, "bin")); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(false) {
+		t.Fatal("the removed version should be removed from the store")
+	}
+}
+
+func TestWatchedRoots(t *testing.T) {
+	root := t.TempDir()
+	ran := filepath.Join(root, "ran")
+	for _, name := range []string{"brew", "asdf"} {
+		if err := os.MkdirAll(filepath.Join(root, "tools"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "tools", name), []byte("#!/bin/sh\necho "+name+" >> "+ran+"\nexit 1\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fakePHP(t, filepath.Join(root, "versions", "8.3.2"), "8.3.2")
+	t.Setenv("PATH", filepath.Join(root, "tools"))
+	configDir := t.TempDir()
+	if _, err := New(configDir, false, nil).RegisterPath(filepath.Join(root, "versions", "8.3.2")); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(ran)
+
+	// versions are loaded from the cache
+	store := New(configDir, false, nil)
+	store.load()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			roots := store.watchedRoots()
+			if !containsPath(roots, filepath.Join(root, "versions")) || !containsPath(roots, filepath.Join(root, "tools")) {
+				t.Errorf("the directories of the versions and of the PATH should be watched, got %v", roots)
+			}
+		}()
+	}
+	wg.Wait()
+	if contents, err := os.ReadFile(ran); err == nil {
+		t.Errorf("version managers should not be run to list the watched directories, got %s", contents)
+	}
+	if store.roots != nil {
+		t.Error("listing the watched directories should not change the store")
+	}
+}
+
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDiscoveryWithoutExecutingPHP(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// not executable: the version must be read from the binary
+	if err := os.WriteFile(filepath.Join(dir, "bin", "php"), []byte("\x7fELF\x00X-Powered-By: PHP/8.3.9\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := New(t.TempDir(), false, nil)
+	v, err := store.discoverPHPViaPHP(dir, "php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == nil || v.Version != "8.3.9" {
+		t.Errorf("the version should be read from the binary, got %v", v)
+	}
+
+	// executable, but not run when the binary tells its version and build
+	ran := filepath.Join(dir, "ran")
+	script := "#!/bin/sh\necho ran > " + ran + "\n# X-Powered-By: PHP/8.4.1\x00API20240924,TS,debug\x00\n"
+	if err := os.WriteFile(filepath.Join(dir, "bin", "php"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	v, err = store.discoverPHPViaPHP(dir, "php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == nil || v.Version != "8.4.1" || !v.ThreadSafe || !v.DebugBuild {
+		t.Errorf("the version and the build flags should be read from the binary, got %+v", v)
+	}
+	if _, err := os.Stat(ran); err == nil {
+		t.Error("PHP should not be run when the binary tells its version and build")
+	}
+}
+
+func TestDiscoveryMetadata(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the metadata are printed after a startup warning on stdout
+	script := `#!/bin/sh
+case "$*" in
+*-r*) echo 'PHP Warning: Module "foo" is already loaded'
+      echo '{"version":"8.3.9","zts":true,"debug":false,"ini":"/etc/php.ini","ini_scan_dir":"/etc/php.d","extension_dir":"/usr/lib/php/modules","extensions":["Core","intl","Zend OPcache"],"extension_versions":["8.3.9","","8.3.9"],"arch":"arm64"}' ;;
+*) echo 'PHP 8.3.9 (cli)' ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "bin", "php"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	store := New(t.TempDir(), false, nil)
+	v, err := store.discoverPHPViaPHP(dir, "php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != "8.3.9" || !v.ThreadSafe || v.DebugBuild || v.IniPath != "/etc/php.ini" || v.IniScanDir != "/etc/php.d" || v.ExtensionDir != "/usr/lib/php/modules" || v.Arch != "arm64" {
+		t.Errorf("metadata should be read from the probe, got %+v", v)
+	}
+	if !v.HasExtension("intl") || !v.HasExtension("zend-opcache") || v.HasExtension("xdebug") {
+		t.Errorf("extensions should be read from the probe, got %v", v.Extensions)
+	}
+	if v.ExtensionVersion("ext-zend-opcache") != "8.3.9" || v.ExtensionVersion("intl") != "" {
+		t.Errorf("extension versions should be read from the probe, got %v", v.ExtensionVersions)
+	}
+	if !v.HasExtensions("core", "intl") || v.HasExtensions("intl", "xdebug") {
+		t.Error("HasExtensions should check all extensions")
+	}
+}
+
+func TestDiscoveryViaPHPConfigProbesMetadataWhenNeeded(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	phpConfig := `#!/bin/sh
+version="8.3.9"
+vernum="80309"
+program_prefix=""
+program_suffix=""
+exe_extension=""
+extension_dir='/usr/lib/php/20230831'
+ini_path="/etc/php/8.3/cli"
+ini_dir="/etc/php/8.3/cli/conf.d"
+configure_options=" --prefix=/usr --enable-zts --with-openssl"
+    php_cgi_binary=""
+`
+	if err := os.WriteFile(filepath.Join(dir, "bin", "php-config"), []byte(phpConfig), 0755); err != nil {
+		t.Fatal(err)
+	}
+	runs := filepath.Join(dir, "runs")
+	script := `#!/bin/sh
+echo run >> ` + runs + `
+echo '{"version":"8.3.9","zts":true,"debug":false,"ini":"","ini_scan_dir":"/etc/php/8.3/cli/conf.d","extension_dir":"/usr/lib/php/20230831","extensions":["Core","intl"],"extension_versions":["8.3.9",""],"arch":"x86_64"}'
+`
+	if err := os.WriteFile(filepath.Join(dir, "bin", "php"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	countRuns := func() int {
+		contents, _ := os.ReadFile(runs)
+		return strings.Count(string(contents), "run")
+	}
+
+	store := New(t.TempDir(), false, nil)
+	v, err := store.discoverPHPViaPHPConfig(dir, "php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countRuns(); n != 0 {
+		t.Errorf("PHP should not be run when php-config is available, ran %d times", n)
+	}
+	if v.Version != "8.3.9" || !v.ThreadSafe || v.DebugBuild || v.ExtensionDir != "/usr/lib/php/20230831" || v.IniScanDir != "/etc/php/8.3/cli/conf.d" || v.Extensions != nil {
+		t.Errorf("metadata should be read from php-config, got %+v", v)
+	}
+
+	store.setVersions(versions{v})
+	if len(store.Find()) != 1 || countRuns() != 0 {
+		t.Error("listing versions should not run PHP")
+	}
+	for i := 0; i < 2; i++ {
+		if found := store.Find(FilterExtension("intl")); len(found) != 1 {
+			t.Errorf("extensions should be probed when needed, got %v", found)
+		}
+	}
+	if n := countRuns(); n != 1 {
+		t.Errorf("PHP should be run once to get the metadata, ran %d times", n)
+	}
+}
+
+func TestDiscoveryThreadSafety(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho 'PHP 8.3.9 (cli) (built: Jan  1 2024 00:00:00) (ZTS DEBUG)'\n"
+	if err := os.WriteFile(filepath.Join(dir, "bin", "php"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	store := New(t.TempDir(), false, nil)
+	v, err := store.discoverPHPViaPHP(dir, "php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.ThreadSafe || !v.DebugBuild {
+		t.Error("thread safety and debug build should be read from php --version")
+	}
+
+	fakePHP(t, dir, "8.3.9")
+	if v, _ := store.discoverPHPViaPHP(dir, "php"); v.ThreadSafe || v.DebugBuild {
+		t.Error("NTS release builds should not be thread-safe nor debug builds")
+	}
+}
+
+func TestDiscoveryInstallers(t *testing.T) {
+	dir := t.TempDir()
+	fakePHP(t, dir, "8.3.9")
+	for _, name := range []string{"pecl", "pie"} {
+		if err := os.WriteFile(filepath.Join(dir, "bin", name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := New(t.TempDir(), false, nil)
+	v, err := store.discoverPHPViaPHP(dir, "php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(v.PECLPath) != "pecl" || filepath.Base(v.PIEPath) != "pie" || v.PEARPath != "" {
+		t.Errorf("installers should be detected, got pecl=%q pear=%q pie=%q", v.PECLPath, v.PEARPath, v.PIEPath)
+	}
+}
+
+func TestDiscoveryEmbed(t *testing.T) {
+	dir := t.TempDir()
+	fakePHP(t, dir, "8.3.9")
+	store := New(t.TempDir(), false, nil)
+	if v, _ := store.discoverPHPViaPHP(dir, "php"); v.EmbedPath != "" {
+		t.Errorf("no embed SAPI should be detected, got %s", v.EmbedPath)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "lib", "libphp8.3.so"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.discoverPHPViaPHP(dir, "php"); filepath.Base(v.EmbedPath) != "libphp8.3.so" {
+		t.Errorf("the embed SAPI should be detected, got %q", v.EmbedPath)
+	}
+}
+
+func TestDiscoveryApacheModule(t *testing.T) {
+	dir := t.TempDir()
+	fakePHP(t, dir, "8.3.9")
+	modules := filepath.Join(dir, "lib", "apache2", "modules")
+	if err := os.MkdirAll(modules, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the module of another version
+	if err := os.WriteFile(filepath.Join(modules, "libphp8.2.so"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := New(t.TempDir(), false, nil)
+	if v, _ := store.discoverPHPViaPHP(dir, "php"); v.ApacheModulePath != "" {
+		t.Errorf("the Apache module of another version should be ignored, got %s", v.ApacheModulePath)
+	}
+
+	if err := os.WriteFile(filepath.Join(modules, "libphp8.3.so"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.discoverPHPViaPHP(dir, "php"); filepath.Base(v.ApacheModulePath) != "libphp8.3.so" || v.EmbedPath != "" {
+		t.Errorf("the Apache module should be detected, got %q (embed: %q)", v.ApacheModulePath, v.EmbedPath)
+	}
+}
+
+func TestDiscoveryFPMConfig(t *testing.T) {
+	dir := t.TempDir()
+	fakePHP(t, dir, "8.3.9")
+	for path, contents := range map[string]string{
+		"sbin/php-fpm":                fmt.Sprintf("#!/bin/sh\necho \"$*\" >> %s/args\necho 'PHP 8.3.9 (fpm-fcgi)'\nexit\n\x00%s/etc/fpm/php-fpm.conf\x00", dir, dir),
+		"etc/fpm/php-fpm.conf":        "[global]\npid = run/php-fpm.pid\ninclude=etc/php-fpm.d/*.conf\n",
+		"etc/php-fpm.d/www.conf":      "[www]\n; listen = 127.0.0.1:9000\nlisten = /run/php-fpm.sock\n",
+		"etc/php-fpm.d/www.conf.dist": "[www]\nlisten = 127.0.0.1:9001\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(contents), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := New(t.TempDir(), false, nil)
+	v, err := store.discoverPHPViaPHP(dir, "php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.FPMConfigPath != filepath.Join(dir, "etc", "fpm", "php-fpm.conf") {
+		t.Errorf("the FPM configuration should be read from the binary, got %q", v.FPMConfigPath)
+	}
+	if args, _ := os.ReadFile(filepath.Join(dir, "args")); strings.Contains(string(args), "-t") {
+		t.Errorf("the FPM configuration should not be tested, got %q", args)
+	}
+	if v.FPMPoolDir != filepath.Join(dir, "etc", "php-fpm.d") || v.FPMListen != "/run/php-fpm.sock" {
+		t.Errorf("the FPM pools should be read from the configuration, got %q (listen: %q)", v.FPMPoolDir, v.FPMListen)
+	}
+}
+
+func TestDiscoveryCompanionVersions(t *testing.T) {
+	dir := t.TempDir()
+	fakePHP(t, dir, "8.3.9")
+	for name, version := range map[string]string{"php-cgi": "8.3.9", "php-fpm": "8.2.1"} {
+		script := fmt.Sprintf("#!/bin/sh\necho 'PHP %s (%s)'\n", version, name)
+		if err := os.WriteFile(filepath.Join(dir, "bin", name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := New(t.TempDir(), false, nil)
+	v, err := store.discoverPHPViaPHP(dir, "php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.FPMPath != "" {
+		t.Errorf("an FPM binary of another version should be ignored, got %s", v.FPMPath)
+	}
+	if filepath.Base(v.CGIPath) != "php-cgi" {
+		t.Errorf("a CGI binary of the same version should be used, got %q", v.CGIPath)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "bin", "php-cgi"), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.discoverPHPViaPHP(dir, "php"); v.CGIPath != "" {
+		t.Errorf("a CGI binary of an unknown version should be ignored, got %s", v.CGIPath)
+	}
+}
+
+func TestInstallableWithHomebrew(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "brew"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	store := New(t.TempDir(), false, nil)
+	for requirement, name := range map[string]string{
+		"7.4": "shivammathur/php/php@7.4",
+		"*":   "php",
+	} {
+		installables := store.Installable(requirement)
+		if len(installables) == 0 || installables[0].Channel != ChannelHomebrew || installables[0].Command != "brew install "+name {
+			t.Errorf("%s should be installable with brew install %s, got %v", requirement, name, installables)
+		}
+	}
+}
+
+func TestInstall(t *testing.T) {
+	if _, err := buildURLs("8.3.12"); err != nil {
+		t.Skip(err)
+	}
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	script := []byte("#!/bin/sh\necho 'PHP 8.3.12 (cli) (built: Sep 24 2024 00:00:00) (NTS)'\n")
+	if err := tw.WriteHeader(&tar.Header{Name: "php", Mode: 0755, Size: int64(len(script)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(script)
+	tw.Close()
+	gz.Close()
+
+	sum := sha256.Sum256(archive.Bytes())
+	checksum := hex.EncodeToString(sum[:])
+
+	var downloads []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/releases":
+			fmt.Fprint(w, `{"8": {"8.3": {"version": "8.3.12"}}}`)
+		case strings.HasPrefix(r.URL.Path, "/php-8.3.12-cli-") && strings.HasSuffix(r.URL.Path, ".sha256"):
+			fmt.Fprintln(w, checksum)
+		case strings.HasPrefix(r.URL.Path, "/php-8.3.12-cli-"):
+			downloads = append(downloads, r.URL.Path)
+			w.Write(archive.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer func(url string) { staticBuildsURL = url }(staticBuildsURL)
+	staticBuildsURL = server.URL
+
+	t.Setenv("PATH", "")
+	configDir := t.TempDir()
+	store := New(configDir, false, nil, WithOutdatedPatchCheck(server.URL+"/releases"))
+	if _, err := store.Install(context.Background(), "8.2.1"); err == nil || !strings.Contains(err.Error(), "no build of PHP 8.2.1") {
+		t.Errorf("missing builds should be reported, got %v", err)
+	}
+	if _, err := store.Install(context.Background(), "8.3-fpm"); err == nil {
+		t.Error("only the CLI should be installable")
+	}
+	checksum = strings.Repeat("0", 64)
+	if _, err := store.Install(context.Background(), "8.3"); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("builds with an invalid checksum should be rejected, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(configDir, "php", "8.3.12")); !os.IsNotExist(err) {
+		t.Error("builds with an invalid checksum should not be installed")
+	}
+	checksum = hex.EncodeToString(sum[:])
+	downloads = nil
+	v, err := store.Install(context.Background(), "8.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != "8.3.12" || v.PHPPath != filepath.Join(configDir, "php", "8.3.12", "bin", "php") {
+		t.Errorf("PHP 8.3.12 should be installed in the configuration directory, got %s (%s)", v.Version, v.PHPPath)
+	}
+	if !store.IsVersionAvailable("8.3") || len(store.RegisteredPaths()) != 1 {
+		t.Error("the installed version should be registered")
+	}
+	if _, err := store.Install(context.Background(), "8.3.12"); err != nil || len(downloads) != 1 {
+		t.Errorf("an installed version should not be downloaded again, got %v (%d downloads)", err, len(downloads))
+	}
+	if entries, _ := os.ReadDir(filepath.Join(configDir, "php")); len(entries) != 1 {
+		t.Errorf("temporary files should be removed, got %d entries", len(entries))
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	root := t.TempDir()
+	configDir := t.TempDir()
+	fakePHP(t, filepath.Join(root, "discovered"), "8.2.1")
+	fakePHP(t, filepath.Join(root, "custom"), "8.4.2")
+	fakePHP(t, filepath.Join(configDir, "php", "8.3.12"), "8.3.12")
+	t.Setenv("PATH", filepath.Join(root, "discovered", "bin"))
+	store := New(configDir, false, nil)
+	for _, path := range []string{filepath.Join(root, "custom", "bin", "php"), filepath.Join(configDir, "php", "8.3.12")} {
+		if _, err := store.RegisterPath(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.Unregister(filepath.Join(root, "missing"), false); err == nil {
+		t.Error("unregistering an unknown path should fail")
+	}
+	if _, err := store.Unregister(filepath.Join(root, "discovered"), false); err == nil {
+		t.Error("discovered versions cannot be unregistered")
+	}
+	v, err := store.Unregister(filepath.Join(root, "custom"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != "8.4.2" || store.IsVersionAvailable("8.4") {
+		t.Errorf("8.4.2 should be unregistered, got %s", v.Version)
+	}
+	if _, err := os.Stat(filepath.Join(root, "custom", "bin", "php")); err != nil {
+		t.Error("only builds downloaded by Install should be deleted")
+	}
+	if _, err := store.Unregister(filepath.Join(configDir, "php", "8.3.12", "bin", "php"), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(configDir, "php", "8.3.12")); !os.IsNotExist(err) {
+		t.Error("builds downloaded by Install should be deleted")
+	}
+
+	reloaded := New(configDir, false, nil)
+	if len(reloaded.RegisteredPaths()) != 0 || len(reloaded.Versions()) != 1 {
+		t.Errorf("unregistered versions should be removed from the configuration and the cache, got %v", reloaded.Versions())
+	}
+}
+
+func TestWatchIgnoresOtherBinaries(t *testing.T) {
+	root := t.TempDir()
+	bin := filepath.Join(root, "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	inPath := map[string]bool{bin: true}
+	for name, expected := range map[string]bool{
+		filepath.Join(bin, "python3"):     false,
+		filepath.Join(bin, "php8.4"):      true,
+		filepath.Join(root, "8.4.1"):      true,
+		filepath.Join(root, "8.4.1", "x"): true,
+	} {
+		if isRelevantEvent(fsnotify.Event{Name: name, Op: fsnotify.Create}, inPath) != expected {
+			t.Errorf("creating %s being relevant should be %v", name, expected)
+		}
+	}
+	if isRelevantEvent(fsnotify.Event{Name: filepath.Join(bin, "php"), Op: fsnotify.Chmod}, inPath) {
+		t.Error("permission changes should be ignored")
+	}
+
+	before := fingerprintDirs([]string{bin}, inPath)
+	if err := os.WriteFile(filepath.Join(bin, "python3"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if fingerprintDirs([]string{bin}, inPath) != before {
+		t.Error("other binaries of the PATH should be ignored")
+	}
+	fakePHP(t, root, "8.4.1")
+	if fingerprintDirs([]string{bin}, inPath) == before {
+		t.Error("PHP binaries of the PATH should be taken into account")
+	}
+}
@@ -22,7 +22,6 @@ package phpstore
 import (
 	"os"
 	"path/filepath"
-	"regexp"
 )
 
 // see https://github.com/composer/windows-setup/blob/master/src/composer.iss
@@ -38,18 +37,18 @@ func (s *PHPStore) doDiscover() {
 	s.addFromDir(filepath.Join(systemDir, "cygwin", "bin"), nil, "Cygwin")
 
 	// Chocolatey
-	s.discoverFromDir(filepath.Join(systemDir, "tools"), nil, regexp.MustCompile("^php\\d+$"), "Chocolatey")
+	s.discoverFromDir(filepath.Join(systemDir, "tools"), nil, phpVersionedName, "Chocolatey")
 
 	// WAMP
-	s.discoverFromDir(filepath.Join(systemDir, "wamp64", "bin", "php"), nil, regexp.MustCompile("^php[\\d\\.]+$"), "WAMP")
-	s.discoverFromDir(filepath.Join(systemDir, "wamp", "bin", "php"), nil, regexp.MustCompile("^php[\\d\\.]+$"), "WAMP")
+	s.discoverFromDir(filepath.Join(systemDir, "wamp64", "bin", "php"), nil, phpVersionedName, "WAMP")
+	s.discoverFromDir(filepath.Join(systemDir, "wamp", "bin", "php"), nil, phpVersionedName, "WAMP")
 
 	// MAMP
-	s.discoverFromDir(filepath.Join(systemDir, "mamp", "bin", "php"), nil, regexp.MustCompile("^php[\\d\\.]+$"), "MAMP")
+	s.discoverFromDir(filepath.Join(systemDir, "mamp", "bin", "php"), nil, phpVersionedName, "MAMP")
 
 	// Herd
 	if userHomeDir != "" {
-		s.discoverFromDir(filepath.Join(userHomeDir, ".config", "herd", "bin"), nil, regexp.MustCompile("^php\\d{2}$"), "Herd")
+		s.discoverFromDir(filepath.Join(userHomeDir, ".config", "herd", "bin"), nil, phpVersionedName, "Herd")
 	}
 }
 
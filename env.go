@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Env returns the environment variables (as KEY=value strings) needed to run
+// tools like Composer or Symfony console commands with this version, to be
+// appended to the current environment:
+//
+//   - PATH starts with the directory of the PHP binary;
+//   - PHPRC and PHP_INI_SCAN_DIR point to the configuration of this version,
+//     so that the ones of another version are not used;
+//   - PHP_IDE_CONFIG defines a server name for IDEs when Xdebug is enabled
+//     (unless already set).
+func (v *Version) Env() []string {
+	pathName := "PATH"
+	if runtime.GOOS == "windows" {
+		pathName = "Path"
+	}
+	path := filepath.Dir(v.PHPPath)
+	if current := pathEnv(); current != "" {
+		path += string(os.PathListSeparator) + current
+	}
+	env := []string{pathName + "=" + path}
+
+	if v.IniPath != "" {
+		env = append(env, "PHPRC="+filepath.Dir(v.IniPath))
+	}
+	if v.IniScanDir != "" {
+		env = append(env, "PHP_INI_SCAN_DIR="+v.IniScanDir)
+	}
+
+	if v.Xdebug != nil && v.Xdebug.Enabled && os.Getenv("PHP_IDE_CONFIG") == "" {
+		env = append(env, "PHP_IDE_CONFIG=serverName=localhost")
+	}
+	return env
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"path/filepath"
+)
+
+// excludePattern returns the exclusion pattern (see WithExcludedPaths)
+// matching the directory or one of its parents, if any
+func (s *PHPStore) excludePattern(dir string) string {
+	for _, pattern := range s.excludedPaths {
+		for path := dir; ; path = filepath.Dir(path) {
+			if ok, _ := filepath.Match(pattern, path); ok {
+				return pattern
+			}
+			if filepath.Dir(path) == path {
+				break
+			}
+		}
+	}
+	return ""
+}
+
+// skipsDir returns true when discovery must not look for PHP in the
+// directory: when it is excluded (see WithExcludedPaths), or when it is on a
+// network filesystem (see WithNetworkFilesystems) which might take tens of
+// seconds to scan; filesystems are only checked when checkFS is true as it
+// costs a syscall
+func (s *PHPStore) skipsDir(dir, why string, checkFS bool) bool {
+	if pattern := s.excludePattern(dir); pattern != "" {
+		s.logWith([]interface{}{"source", why, "path", dir, "verdict", "excluded"}, "  Skipping %s as it matches %s", dir, pattern)
+		return true
+	}
+	if checkFS && !s.scanNetworkFilesystems {
+		if fs := networkFilesystem(dir); fs != "" {
+			s.logWith([]interface{}{"source", why, "path", dir, "verdict", "network_filesystem"}, "  Skipping %s as it is on a network filesystem (%s)", dir, fs)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SourceForced is the FORCED_PHP_VERSION environment variable, which takes
+// precedence over all sources
+const SourceForced = "forced"
+
+// sourceFiles are the files read by the sources, looked for in the directory
+// and up (see sourceFileNames)
+var sourceFiles = map[string][]string{
+	SourceDirenv:               {".envrc"},
+	SourcePHPVersion:           {".php-version"},
+	SourceSymfonyLocal:         {".symfony.local.yaml"},
+	SourceComposerPlatform:     {"composer.json"},
+	SourceWorkingDirPHPVersion: {".php-version"},
+	SourceSymfonyCloud:         {".symfony.cloud.yaml"},
+	SourcePlatformSH:           {".platform.app.yaml"},
+	SourceWPEnv:                {".wp-env.json", ".wp-env.override.json"},
+	SourceAppEngine:            {"app.yaml"},
+	SourceBref:                 {"serverless.yml"},
+	SourceComposerRequire:      {"composer.json"},
+	SourceGitLabCI:             {".gitlab-ci.yml"},
+	SourceGitHubActions:        {filepath.Join(".github", "workflows")},
+}
+
+// sourceFileNames returns the files read by a source, composer.json being
+// replaced by the manifest set by the COMPOSER environment variable
+func sourceFileNames(source string) []string {
+	names := make([]string, len(sourceFiles[source]))
+	for i, name := range sourceFiles[source] {
+		if name == "composer.json" {
+			name = composerFile()
+		}
+		names[i] = name
+	}
+	return names
+}
+
+// Explanation is the decision trail of BestVersionForDir (see
+// ExplainVersionForDir)
+type Explanation struct {
+	Dir string
+	// Steps are the sources checked, by order of precedence
+	Steps []*ExplanationStep
+	// Requirement is the requirement used, empty when no source provides one
+	Requirement string
+	// Extensions are the extensions required by composer.json
+	Extensions []string
+	// Candidates are the versions matching the requirement, most recent first
+	Candidates []*ExplanationCandidate
+	// Version is the selected version, nil when none is available
+	Version *Version
+	// Source describes where the requirement comes from, or how the version
+	// was selected when there is no requirement (like the default version in
+	// the PATH)
+	Source string
+	// Reason explains why Version was selected
+	Reason   string
+	Warnings Warnings
+	Err      error
+}
+
+// ExplanationStep is a source checked by ExplainVersionForDir
+type ExplanationStep struct {
+	// Source is the name of the source (see DefaultSources and SourceForced)
+	Source string
+	// Files are the files of the source found in the directory or up
+	Files []string
+	// Requirement is the requirement found, if any
+	Requirement string
+	// Description describes where the requirement was found
+	Description string
+	// Used is true for the source providing the requirement; the
+	// requirements of the next sources are ignored
+	Used bool
+}
+
+// ExplanationCandidate is a version matching the requirement
+type ExplanationCandidate struct {
+	Version *Version
+	// Exact is true when the requirement is this patch version
+	Exact bool
+	// Flavor is true when the version supports the required flavor
+	Flavor bool
+	// MissingExtensions are the required extensions not provided by the version
+	MissingExtensions []string
+}
+
+// ExplainVersionForDir returns how BestVersionForDir selects the version of
+// the given directory: the checked sources, the requirement found, the
+// matching versions, and why the selected one was preferred
+func (s *PHPStore) ExplainVersionForDir(dir string, opts ...LookupOption) *Explanation {
+	l := newLookup(opts)
+	s.load()
+	// downloaded and probed before locking, as it can take a while
+	releases := s.latestReleases()
+	s.probeEnvironmentPHP(dir)
+	s.probeMetadataForDir(dir)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e := &Explanation{Dir: dir, Extensions: s.requiredExtensionsForDir(dir)}
+	if forced := os.Getenv("FORCED_PHP_VERSION"); forced != "" {
+		step := &ExplanationStep{Source: SourceForced, Requirement: forced, Description: "FORCED_PHP_VERSION environment variable"}
+		e.Steps = append(e.Steps, step)
+		// same rules as bestVersionForDir
+		minorPHPVersion := strings.Join(strings.Split(forced, ".")[0:2], ".")
+		if _, err := parsePHPVersion(minorPHPVersion); err == nil {
+			step.Used = true
+			e.Requirement = overrideFlavor(minorPHPVersion, l.flavor)
+		}
+	}
+	wd, _ := os.Getwd()
+	usedSource := ""
+	for _, name := range s.sources {
+		requirement, description := sourceResolvers[name](s, dir)
+		step := &ExplanationStep{Source: name, Requirement: requirement, Description: description}
+		from := dir
+		if name == SourceWorkingDirPHPVersion {
+			from = wd
+		}
+		for _, file := range sourceFileNames(name) {
+			if path := findUp(from, file); path != "" {
+				step.Files = append(step.Files, path)
+			}
+		}
+		if requirement != "" && e.Requirement == "" {
+			step.Used = true
+			usedSource = name
+			e.Requirement = requirement
+			if name != SourceDirenv {
+				e.Requirement = overrideFlavor(requirement, l.flavor)
+			}
+		}
+		e.Steps = append(e.Steps, step)
+	}
+
+	if usedSource == SourceDirenv {
+		// the requirement is the path of the binary
+		if v := s.environmentVersion(e.Requirement); v != nil {
+			e.Candidates = append(e.Candidates, &ExplanationCandidate{
+				Version:           v,
+				Exact:             true,
+				Flavor:            v.SupportsFlavor(l.flavor),
+				MissingExtensions: v.missingExtensions(e.Extensions),
+			})
+		}
+	} else if e.Requirement != "" {
+		for i := len(s.versions) - 1; i >= 0; i-- {
+			v := s.versions[i]
+			if ok, exact, flavor := s.matchAliasedRequirement(v, e.Requirement); ok {
+				e.Candidates = append(e.Candidates, &ExplanationCandidate{
+					Version:           v,
+					Exact:             exact,
+					Flavor:            flavor,
+					MissingExtensions: v.missingExtensions(e.Extensions),
+				})
+			}
+		}
+	}
+
+	e.Version, e.Source, e.Warnings, e.Err = s.bestVersionForDirWithWarnings(dir, l.flavor, e.Extensions, releases)
+	e.Reason = e.reason()
+	return e
+}
+
+// reason explains why the version was selected
+func (e *Explanation) reason() string {
+	if e.Version == nil {
+		if e.Err != nil {
+			return e.Err.Error()
+		}
+		return "no PHP versions available"
+	}
+	if e.Requirement == "" {
+		return fmt.Sprintf("no sources require a version: using the %s", e.Source)
+	}
+	var candidate *ExplanationCandidate
+	for _, c := range e.Candidates {
+		if c.Version == e.Version {
+			candidate = c
+			break
+		}
+	}
+	if candidate == nil {
+		return fmt.Sprintf("no installed version satisfies %s: falling back to the %s", e.Requirement, e.Source)
+	}
+
+	var reasons []string
+	if candidate.Exact {
+		reasons = append(reasons, fmt.Sprintf("it is exactly %s", e.Requirement))
+	} else {
+		reasons = append(reasons, fmt.Sprintf("it is the most recent version matching %s", e.Requirement))
+	}
+	if _, flavor := splitFlavor(e.Requirement); flavor != "" && candidate.Flavor {
+		reasons = append(reasons, fmt.Sprintf("it supports the %s flavor", flavor))
+	}
+	if len(e.Extensions) > 0 && len(candidate.MissingExtensions) == 0 {
+		reasons = append(reasons, "it provides the extensions required by composer.json")
+	}
+	return strings.Join(reasons, ", and ")
+}
+
+// String returns the decision trail for humans (like in a doctor command)
+func (e *Explanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "PHP version for %s\n", e.Dir)
+	fmt.Fprintln(&b, "\nSources, by order of precedence:")
+	for _, step := range e.Steps {
+		status := "no requirement"
+		if step.Requirement != "" {
+			status = fmt.Sprintf("requires %s (%s)", step.Requirement, step.Description)
+			if !step.Used {
+				status += ", ignored"
+			}
+		}
+		fmt.Fprintf(&b, "  %-25s %s\n", step.Source, status)
+		for _, file := range step.Files {
+			fmt.Fprintf(&b, "  %-25s   %s\n", "", file)
+		}
+	}
+	if len(e.Extensions) > 0 {
+		fmt.Fprintf(&b, "\nRequired extensions: %s\n", strings.Join(e.Extensions, ", "))
+	}
+	if e.Requirement != "" {
+		fmt.Fprintf(&b, "\nVersions matching %s:\n", e.Requirement)
+		if len(e.Candidates) == 0 {
+			fmt.Fprintln(&b, "  none")
+		}
+		for _, c := range e.Candidates {
+			var details []string
+			if _, flavor := splitFlavor(e.Requirement); flavor != "" && !c.Flavor {
+				details = append(details, fmt.Sprintf("no %s flavor", flavor))
+			}
+			if len(c.MissingExtensions) > 0 {
+				details = append(details, "missing "+strings.Join(c.MissingExtensions, ", "))
+			}
+			line := "  " + c.Version.String()
+			if len(details) > 0 {
+				line += " (" + strings.Join(details, "; ") + ")"
+			}
+			fmt.Fprintln(&b, line)
+		}
+	}
+	fmt.Fprintln(&b)
+	if e.Version != nil {
+		fmt.Fprintf(&b, "Selected %s: %s\n", e.Version, e.Reason)
+	} else {
+		fmt.Fprintf(&b, "No version selected: %s\n", e.Reason)
+	}
+	for _, warning := range e.Warnings {
+		fmt.Fprintf(&b, "Warning: %s\n", warning)
+	}
+	return b.String()
+}
+
+// findUp returns the path of the given file in dir or up, or an empty string
+func findUp(dir, name string) string {
+	if filepath.IsAbs(name) {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+		return ""
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return filepath.Join(dir, name)
+		}
+		upDir := filepath.Dir(dir)
+		if upDir == dir || upDir == "." {
+			return ""
+		}
+		dir = upDir
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import "strings"
+
+// Filter restricts the versions returned by Find; filters are named Filter*
+// (like FilterFlavor), With* being the options of New and of the lookups (like
+// WithFlavor)
+type Filter func(*Version) bool
+
+// Find returns the versions matching all the given filters; when filtering,
+// PHP binaries not run during discovery are run once to get the metadata
+// used by filters (like loaded extensions, see probeMetadata)
+func (s *PHPStore) Find(filters ...Filter) []*Version {
+	s.load()
+	if len(filters) > 0 {
+		s.probeMetadata()
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found []*Version
+	for _, v := range s.versions {
+		matches := true
+		for _, filter := range filters {
+			if !filter(v) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			found = append(found, v)
+		}
+	}
+	return found
+}
+
+// FilterFlavor keeps versions supporting the given flavor (see Flavor* constants)
+func FilterFlavor(flavor string) Filter {
+	return func(v *Version) bool {
+		return v.SupportsFlavor(flavor)
+	}
+}
+
+// FilterConstraint keeps versions matching the given constraint expression (like ^8.2)
+func FilterConstraint(expr string) Filter {
+	cs, err := parseConstraints(expr)
+	return func(v *Version) bool {
+		if err != nil {
+			return false
+		}
+		fv := v.fullVersion()
+		return fv != nil && cs.check(fv)
+	}
+}
+
+// FilterMinVersion keeps versions greater than or equal to the given one
+func FilterMinVersion(min string) Filter {
+	return FilterConstraint(">=" + min)
+}
+
+// FilterMaxVersion keeps versions lower than or equal to the given one (8.2
+// includes all 8.2 patch versions)
+func FilterMaxVersion(max string) Filter {
+	return FilterConstraint("<=" + max)
+}
+
+// FilterExtension keeps versions known to provide the given extension
+func FilterExtension(name string) Filter {
+	return func(v *Version) bool {
+		return v.HasExtension(name)
+	}
+}
+
+// FilterThreadSafe keeps thread-safe (ZTS) versions when true, and non
+// thread-safe (NTS) versions when false
+func FilterThreadSafe(threadSafe bool) Filter {
+	return func(v *Version) bool {
+		return v.ThreadSafe == threadSafe
+	}
+}
+
+// FilterDebugBuild keeps versions compiled with --enable-debug when true, and
+// release builds when false
+func FilterDebugBuild(debugBuild bool) Filter {
+	return func(v *Version) bool {
+		return v.DebugBuild == debugBuild
+	}
+}
+
+// FilterArch keeps versions running natively on the given architecture (like
+// x86_64 or arm64), including universal binaries
+func FilterArch(arch string) Filter {
+	return func(v *Version) bool {
+		return v.SupportsArch(arch)
+	}
+}
+
+// FilterJIT keeps versions with an OPcache supporting the JIT compiler
+func FilterJIT() Filter {
+	return func(v *Version) bool {
+		return v.OPcache != nil && v.OPcache.JIT
+	}
+}
+
+// FilterSource keeps versions found by the given discovery source (like
+// homebrew or PATH; case insensitive)
+func FilterSource(source string) Filter {
+	return func(v *Version) bool {
+		return strings.EqualFold(v.Source, source)
+	}
+}
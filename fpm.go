@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// the default configuration file of FPM is compiled in the binary (like
+// /etc/php/8.3/fpm/php-fpm.conf), as a C string
+var fpmConfigRegexp = regexp.MustCompile(`\x00(/[^\x00\s]*/php-fpm\.conf)\x00`)
+
+// setFPMConfig records the configuration file, pool directory, and listen
+// address of FPM
+func (v *Version) setFPMConfig() string {
+	if v.FPMPath == "" {
+		return ""
+	}
+	v.FPMConfigPath = v.fpmConfigPath()
+	if v.FPMConfigPath == "" {
+		return ""
+	}
+	v.FPMPoolDir, v.FPMListen = v.fpmPools()
+	return fmt.Sprintf(", with FPM configuration: %s", v.FPMConfigPath)
+}
+
+// fpmConfigPath returns the default configuration file of FPM, read from the
+// binary (running php-fpm -t would test the whole configuration, which can
+// fail for non-root users), or from the usual locations
+func (v *Version) fpmConfigPath() string {
+	path := scanBinary(v.FPMPath, func(data []byte) string {
+		if m := fpmConfigRegexp.FindSubmatch(data); m != nil {
+			return string(m[1])
+		}
+		return ""
+	})
+	if path != "" {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	candidates := []string{filepath.Join(v.Path, "etc", "php-fpm.conf")}
+	if fv := v.fullVersion(); fv != nil && v.Path == "/usr" {
+		minor := fmt.Sprintf("%d.%d", fv.Segments()[0], fv.Segments()[1])
+		candidates = append(candidates, filepath.Join("/etc/php", minor, "fpm", "php-fpm.conf"), "/etc/php-fpm.conf")
+	}
+	return firstExistingPath(candidates...)
+}
+
+// fpmPools returns the directory of pool configurations included by the FPM
+// configuration, and the listen address of the first pool
+func (v *Version) fpmPools() (string, string) {
+	poolDir := ""
+	files := []string{v.FPMConfigPath}
+	for _, line := range iniLines(v.FPMConfigPath) {
+		key, value := line[0], line[1]
+		if key != "include" {
+			continue
+		}
+		// relative paths are relative to the installation prefix
+		if !filepath.IsAbs(value) {
+			value = filepath.Join(v.Path, value)
+		}
+		poolDir = filepath.Dir(value)
+		matches, _ := filepath.Glob(value)
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	for _, file := range files {
+		for _, line := range iniLines(file) {
+			if line[0] == "listen" {
+				return poolDir, line[1]
+			}
+		}
+	}
+	return poolDir, ""
+}
+
+// iniLines returns the key/value pairs of an ini file
+func iniLines(path string) [][2]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines [][2]string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' || line[0] == '[' {
+			continue
+		}
+		pos := strings.IndexByte(line, '=')
+		if pos == -1 {
+			continue
+		}
+		lines = append(lines, [2]string{
+			strings.TrimSpace(line[:pos]),
+			strings.Trim(strings.TrimSpace(line[pos+1:]), `"'`),
+		})
+	}
+	return lines
+}
+
+// fpmOnlyProbes returns the probes of the FPM binaries of dir/sbin (like
+// php-fpm8.3) without a PHP binary next to them (like php8.3) matching
+// phpRegexp (see discoverFPMOnly)
+func (s *PHPStore) fpmOnlyProbes(dir string, phpRegexp *regexp.Regexp, why string) []*probe {
+	if runtime.GOOS == "windows" || phpRegexp == nil {
+		return nil
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "sbin", "php-fpm*"))
+	var probes []*probe
+	for _, fpm := range matches {
+		binName := strings.Replace(filepath.Base(fpm), "php-fpm", "php", 1)
+		if !phpRegexp.MatchString(binName) {
+			continue
+		}
+		if _, err := s.fs.stat(filepath.Join(dir, "bin", binName)); err == nil {
+			continue
+		}
+		probes = append(probes, &probe{dir: dir, binName: binName, why: why})
+	}
+	return probes
+}
+
+// discoverFPMOnly returns the version of an installation providing FPM but
+// no PHP binary (like Docker images or distribution packages installed
+// without the CLI); its PHPPath is empty, and it is only selected for the fpm
+// flavor (see SupportsFlavor)
+func (s *PHPStore) discoverFPMOnly(dir, binName string) (*Version, error) {
+	fpmName := strings.Replace(binName, "php", "php-fpm", 1)
+	fpm := firstExistingPath(filepath.Join(dir, "sbin", fpmName), filepath.Join(dir, "bin", fpmName))
+	if fpm == "" {
+		return nil, nil
+	}
+	fpm, err := s.fs.evalSymlinks(filepath.Clean(fpm))
+	if err != nil {
+		s.logWith([]interface{}{"path", fpm, "verdict", "error"}, "  %s is not a valid symlink", fpm)
+		return nil, errors.Errorf("%s is not a valid symlink", fpm)
+	}
+
+	var buf bytes.Buffer
+	cmd, cancel := probeCommand(fpm, "-n", "-v")
+	defer cancel()
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	if err := cmd.Run(); err != nil {
+		s.logWith([]interface{}{"path", fpm, "verdict", "error", "error", err}, `  Unable to run "%s -v": %s`, fpm, err)
+		return nil, errors.Wrapf(err, "unable to run %s -v", fpm)
+	}
+	// like "PHP 8.3.6 (fpm-fcgi) (built: Apr 15 2024 19:21:47)"
+	rawVersion, banner, warnings := parseVersionBanner(buf.Bytes())
+	if rawVersion == "" {
+		s.logWith([]interface{}{"path", fpm, "verdict", "not_php"}, "  %s is not a PHP-FPM binary", fpm)
+		return nil, errors.Errorf("%s is not a PHP-FPM binary", fpm)
+	}
+	version, err := s.newVersion(dir, rawVersion)
+	if err != nil {
+		return nil, err
+	}
+	version.FPMPath = fpm
+	version.ThreadSafe = strings.Contains(banner, "(ZTS")
+	version.DebugBuild = strings.Contains(banner, " DEBUG")
+	version.warnings = warnings
+	version.inspectBinary()
+	s.logWith([]interface{}{"path", fpm, "version", version.Version, "verdict", "found"}, fmt.Sprintf("  Found PHP-FPM without PHP: %s", fpm)+version.setFPMConfig())
+	return version, nil
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// isFrankenPHPBinary returns true if binName is a FrankenPHP binary (like
+// frankenphp or frankenphp-8.4)
+func isFrankenPHPBinary(binName string) bool {
+	return strings.HasPrefix(binName, "frankenphp")
+}
+
+// frankenPHPProbe returns a probe for the FrankenPHP binary of a bin
+// directory, or nil when there is none
+func (s *PHPStore) frankenPHPProbe(path, why string) *probe {
+	// FrankenPHP is not available on Windows
+	if runtime.GOOS == "windows" || filepath.Base(path) != "bin" {
+		return nil
+	}
+	if _, err := s.fs.stat(filepath.Join(path, "frankenphp")); err != nil {
+		return nil
+	}
+	return &probe{dir: filepath.Dir(path), binName: "frankenphp", why: why}
+}
+
+// discoverFrankenPHP returns the PHP version embedded in a FrankenPHP binary;
+// its metadata is read by running PHP via the php-cli command
+func (s *PHPStore) discoverFrankenPHP(dir, binName string) (*Version, error) {
+	bin := filepath.Join(dir, "bin", binName)
+	if _, err := s.fs.stat(bin); err != nil {
+		return nil, nil
+	}
+
+	rawVersion := ""
+	threadSafe, debugBuild := false, false
+	var extensions, warnings []string
+	info, err := runProbe(bin, "php-cli")
+	if err == nil {
+		rawVersion = info.Version
+		warnings = info.Warnings
+	} else {
+		s.logWith([]interface{}{"path", bin, "error", err}, "  Unable to get metadata from %s: %s", bin, err)
+		// like "PHP 8.4.3 (cli) (built: Jan 21 2025 10:12:32) (ZTS)"
+		out, err := frankenPHPOutput(bin, "-v")
+		if err != nil {
+			s.logWith([]interface{}{"path", bin, "verdict", "error", "error", err}, `  Unable to run "%s php-cli -v": %s`, bin, err)
+			return nil, err
+		}
+		var banner string
+		rawVersion, banner, warnings = parseVersionBanner(out)
+		if rawVersion == "" {
+			s.logWith([]interface{}{"path", bin, "verdict", "not_php"}, "  %s does not embed PHP", bin)
+			return nil, errors.Errorf("%s does not embed PHP", bin)
+		}
+		threadSafe = strings.Contains(banner, "(ZTS")
+		debugBuild = strings.Contains(banner, " DEBUG")
+		if out, err := frankenPHPOutput(bin, "-m"); err == nil {
+			extensions = parseModules(out)
+		}
+	}
+
+	bin, err = s.fs.evalSymlinks(filepath.Clean(bin))
+	if err != nil {
+		s.logWith([]interface{}{"path", bin, "verdict", "error"}, "  %s is not a valid symlink", bin)
+		return nil, errors.Errorf("%s is not a valid symlink", bin)
+	}
+	version, err := s.newVersion(dir, rawVersion)
+	if err != nil {
+		return nil, err
+	}
+	version.PHPPath = bin
+	version.FrankenPHP = true
+	version.ThreadSafe = threadSafe
+	version.DebugBuild = debugBuild
+	version.Extensions = extensions
+	version.warnings = warnings
+	if info != nil {
+		info.apply(version)
+	}
+	version.inspectBinary()
+	s.logWith([]interface{}{"path", bin, "version", version.Version, "verdict", "found"}, "  Found FrankenPHP with PHP %s", version.Version)
+	return version, nil
+}
+
+// frankenPHPOutput returns the output of the php-cli command of FrankenPHP;
+// php.ini is loaded so that -m reports the configured extensions
+func frankenPHPOutput(bin string, args ...string) ([]byte, error) {
+	cmd, cancel := probeCommand(bin, append([]string{"php-cli"}, args...)...)
+	defer cancel()
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to run %s php-cli", bin)
+	}
+	return out, nil
+}
+
+// parseModules returns the extensions listed by php -m, skipping the section
+// headers (like [PHP Modules] and [Zend Modules])
+func parseModules(out []byte) []string {
+	var extensions []string
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "[") {
+			continue
+		}
+		ext := normalizeExtensionName(line)
+		// Zend extensions (like OPcache) are listed in both sections
+		if !seen[ext] {
+			seen[ext] = true
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}
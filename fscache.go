@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fsCache memoizes the results of os.Stat and filepath.EvalSymlinks during a
+// discovery run, as the same paths are resolved several times (per binary,
+// per companion, and when adding versions), which is slow on network
+// filesystems; a nil cache does not memoize anything
+type fsCache struct {
+	mu       sync.Mutex
+	stats    map[string]statResult
+	symlinks map[string]symlinkResult
+}
+
+type statResult struct {
+	fi  os.FileInfo
+	err error
+}
+
+type symlinkResult struct {
+	path string
+	err  error
+}
+
+func newFSCache() *fsCache {
+	return &fsCache{
+		stats:    make(map[string]statResult),
+		symlinks: make(map[string]symlinkResult),
+	}
+}
+
+// stat is a memoized os.Stat
+func (c *fsCache) stat(path string) (os.FileInfo, error) {
+	if c == nil {
+		return os.Stat(path)
+	}
+	c.mu.Lock()
+	r, ok := c.stats[path]
+	c.mu.Unlock()
+	if !ok {
+		r.fi, r.err = os.Stat(path)
+		c.mu.Lock()
+		c.stats[path] = r
+		c.mu.Unlock()
+	}
+	return r.fi, r.err
+}
+
+// evalSymlinks is a memoized filepath.EvalSymlinks
+func (c *fsCache) evalSymlinks(path string) (string, error) {
+	if c == nil {
+		return filepath.EvalSymlinks(path)
+	}
+	c.mu.Lock()
+	r, ok := c.symlinks[path]
+	c.mu.Unlock()
+	if !ok {
+		r.path, r.err = filepath.EvalSymlinks(path)
+		c.mu.Lock()
+		c.symlinks[path] = r
+		c.mu.Unlock()
+	}
+	return r.path, r.err
+}
@@ -3,8 +3,11 @@ module github.com/symfony-cli/phpstore
 go 1.17
 
 require (
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/hashicorp/go-version v1.6.0
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/pkg/errors v0.9.1
 	gopkg.in/yaml.v2 v2.4.0
 )
+
+require golang.org/x/sys v0.7.0 // indirect
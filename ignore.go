@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IgnorePath hides the PHP installations at or under the given path (like a
+// broken XAMPP installation); their binaries are not even run during
+// discoveries. The path is saved in the configuration and the versions
+// already known are removed from the store and from the cache.
+func (s *PHPStore) IgnorePath(path string) error {
+	s.load()
+	path, err := expandPath(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.updateConfig(func(c *config) {
+		c.IgnoredPaths = appendPath(c.IgnoredPaths, path)
+	}); err != nil {
+		return err
+	}
+	s.ignoredPaths = appendPath(s.ignoredPaths, path)
+	s.removeIgnoredVersions()
+	return nil
+}
+
+// IgnoreVersion hides the given PHP version (like 7.4.3, or 7.4 for all
+// 7.4 patch versions). The version is saved in the configuration and the
+// matching versions are removed from the store and from the cache.
+func (s *PHPStore) IgnoreVersion(version string) error {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.updateConfig(func(c *config) {
+		c.IgnoredVersions = appendPath(c.IgnoredVersions, version)
+	}); err != nil {
+		return err
+	}
+	s.ignoredVersions = appendPath(s.ignoredVersions, version)
+	s.removeIgnoredVersions()
+	return nil
+}
+
+// removeIgnoredVersions removes ignored versions from the store and updates
+// the cache
+func (s *PHPStore) removeIgnoredVersions() {
+	vs, changed := s.withoutIgnoredVersions(s.versions)
+	if !changed {
+		return
+	}
+	s.setVersions(vs)
+	s.writeCache()
+}
+
+// withoutIgnoredVersions returns the versions that are not ignored, and
+// whether some were removed
+func (s *PHPStore) withoutIgnoredVersions(vs versions) (versions, bool) {
+	kept := versions{}
+	for _, v := range vs {
+		if s.ignores(v) {
+			s.log("Ignoring %s as configured", v.binary())
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return kept, len(kept) != len(vs)
+}
+
+// ignores returns true when the version or its installation is ignored
+func (s *PHPStore) ignores(v *Version) bool {
+	if s.ignoresPath(v.binary()) || s.ignoresPath(v.Path) {
+		return true
+	}
+	for _, ignored := range s.ignoredVersions {
+		if hasVersionPrefix(v.Version, ignored) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoresPath returns true when the path is an ignored path or is under one
+func (s *PHPStore) ignoresPath(path string) bool {
+	for _, ignored := range s.ignoredPaths {
+		if path == ignored || strings.HasPrefix(path, strings.TrimSuffix(ignored, string(os.PathSeparator))+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPaths expands the paths of the configuration, which can be edited by
+// hand
+func expandPaths(paths []string) []string {
+	var expanded []string
+	for _, path := range paths {
+		if p, err := expandPath(path); err == nil {
+			path = p
+		}
+		expanded = appendPath(expanded, filepath.Clean(path))
+	}
+	return expanded
+}
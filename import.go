@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Import merges the versions of a JSON document produced by MarshalReport
+// (like an inventory generated when building a container image) with the
+// versions of the store, and updates the cache. Versions already known by
+// the store (with the same PHP binary) are kept as is, and versions whose
+// binary does not exist on this machine are skipped. It returns the number
+// of versions added.
+func (s *PHPStore) Import(contents []byte) (int, error) {
+	s.load()
+	imported, _, err := s.decodeCache(contents)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to decode the versions to import")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	added := 0
+	for _, v := range imported {
+		if _, ok := s.seen[v.binary()]; ok {
+			continue
+		}
+		if _, err := os.Stat(v.binary()); err != nil {
+			s.log("Skipping imported version %s as %s does not exist", v.Version, v.binary())
+			continue
+		}
+		if s.ignores(v) {
+			continue
+		}
+		if v.FullVersion, err = parsePHPVersion(v.Version); err != nil {
+			s.log("Skipping imported version %s as it is invalid", v.Version)
+			continue
+		}
+		// the system version is the one of the local PATH
+		v.IsSystem = false
+		if v.Source == "" {
+			v.Source = "imported"
+		}
+		s.addVersion(v)
+		added++
+	}
+	if added > 0 {
+		s.setVersions(s.versions)
+		s.writeCache()
+	}
+	return added, nil
+}
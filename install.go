@@ -0,0 +1,347 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// staticBuildsURL is where static-php-cli builds are downloaded from
+	staticBuildsURL = "https://dl.static-php.dev/static-php-cli/common"
+	// windowsBuildsURL is where official Windows builds are downloaded from;
+	// builds of older releases are moved to the archives/ directory
+	windowsBuildsURL = "https://windows.php.net/downloads/releases"
+	// downloadTimeout is the maximum duration of the download of a build
+	downloadTimeout = 10 * time.Minute
+)
+
+// like 8.3.12
+var releaseRegexp = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+// errBuildNotFound is returned when a build does not exist at an URL
+var errBuildNotFound = errors.New("build not found")
+
+// Install downloads a build of the most recent release matching the given
+// requirement (like 8.3, ^8.2, or 8.3.12) and registers it with
+// RegisterPath: a static-php-cli build on Linux and macOS, or an official
+// build on Windows. Requirements that are not a release are resolved with the
+// php.net releases (see DefaultReleasesURL). Builds are installed in the
+// php/ directory of the configuration directory (like php/8.3.12), and only
+// provide the CLI. Archives are verified with the SHA-256 checksums published
+// along with the builds before being extracted.
+func (s *PHPStore) Install(ctx context.Context, requirement string) (*Version, error) {
+	s.load()
+	release, err := s.resolveRelease(requirement)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(s.installDir(), release)
+	if _, err := os.Stat(dir); err == nil {
+		s.log("PHP %s is already installed in %s", release, dir)
+		return s.RegisterPath(dir)
+	}
+	urls, err := buildURLs(release)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp := dir + ".download"
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer os.RemoveAll(tmp)
+	for _, url := range urls {
+		s.log("Downloading PHP %s from %s", release, url)
+		if err = downloadBuild(ctx, url, tmp); err != errBuildNotFound {
+			break
+		}
+	}
+	if err == errBuildNotFound {
+		return nil, errors.Errorf("no build of PHP %s is available for %s/%s", release, runtime.GOOS, hostArch())
+	} else if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	v, err := s.RegisterPath(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return v, nil
+}
+
+// installDir returns the directory of the builds downloaded by Install
+func (s *PHPStore) installDir() string {
+	return filepath.Join(s.configDir, "php")
+}
+
+// resolveRelease returns the most recent release matching the requirement
+func (s *PHPStore) resolveRelease(requirement string) (string, error) {
+	requirement, flavor := splitFlavor(requirement)
+	if flavor != "" && flavor != FlavorCLI {
+		return "", errors.Errorf("unable to install the %s flavor of PHP, only the CLI is available", flavor)
+	}
+	if releaseRegexp.MatchString(requirement) {
+		return requirement, nil
+	}
+	releases := s.loadReleases()
+	s.mu.RLock()
+	installables := s.installable(requirement, releases)
+	s.mu.RUnlock()
+	if len(installables) == 0 || installables[0].Release == "" {
+		return "", errors.Errorf("unable to find a PHP release matching %q", requirement)
+	}
+	return installables[0].Release, nil
+}
+
+// buildURLs returns the URLs where the build of the given release for the
+// current platform can be found, by order of preference
+func buildURLs(release string) ([]string, error) {
+	fv, err := parsePHPVersion(release)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	segments := fv.Segments()
+	arch := hostArch()
+	switch runtime.GOOS {
+	case "linux", "darwin":
+		platform := "linux"
+		if runtime.GOOS == "darwin" {
+			platform = "macos"
+		}
+		if arch == "arm64" {
+			arch = "aarch64"
+		} else if arch != "x86_64" {
+			return nil, errors.Errorf("static builds of PHP are not available for %s", arch)
+		}
+		return []string{fmt.Sprintf("%s/php-%s-cli-%s-%s.tar.gz", staticBuildsURL, release, platform, arch)}, nil
+	case "windows":
+		// x64 builds also run on ARM64 thanks to emulation
+		if arch != "x86_64" && arch != "arm64" {
+			return nil, errors.Errorf("Windows builds of PHP are not available for %s", arch)
+		}
+		var compiler string
+		switch {
+		case segments[0] > 8 || segments[0] == 8 && segments[1] >= 4:
+			compiler = "vs17"
+		case segments[0] == 8:
+			compiler = "vs16"
+		case segments[0] == 7 && segments[1] >= 2:
+			compiler = "vc15"
+		default:
+			return nil, errors.Errorf("Windows builds of PHP %s are not available", release)
+		}
+		name := fmt.Sprintf("php-%s-nts-Win32-%s-x64.zip", release, compiler)
+		return []string{windowsBuildsURL + "/" + name, windowsBuildsURL + "/archives/" + name}, nil
+	}
+	return nil, errors.Errorf("builds of PHP are not available for %s", runtime.GOOS)
+}
+
+// checksumURL returns the URL of the SHA-256 checksum of a build: the
+// sha256sum.txt file of the directory for Windows builds, and a .sha256 file
+// next to the archive for static builds
+func checksumURL(url string) string {
+	if strings.HasSuffix(url, ".zip") {
+		return path.Dir(url) + "/sha256sum.txt"
+	}
+	return url + ".sha256"
+}
+
+// downloadBuild downloads the archive at the given URL, verifies its
+// checksum (see checksumURL), and extracts it in dir: static builds in
+// dir/bin, and Windows builds in dir
+func downloadBuild(ctx context.Context, url, dir string) error {
+	client := &http.Client{Timeout: downloadTimeout}
+	resp, err := httpGet(ctx, client, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	// archives are extracted once verified
+	archive, err := os.CreateTemp(filepath.Dir(dir), "php-*"+path.Ext(url))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(archive, hash), resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "unable to download %s", url)
+	}
+	expected, err := fetchChecksum(ctx, client, checksumURL(url), path.Base(url))
+	if err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return errors.Errorf("the checksum of %s does not match: expected %s, got %s", url, expected, actual)
+	}
+
+	if strings.HasSuffix(url, ".tar.gz") {
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			return errors.WithStack(err)
+		}
+		return extractTarGz(archive, filepath.Join(dir, "bin"))
+	}
+	return extractZip(archive, size, dir)
+}
+
+// fetchChecksum returns the SHA-256 checksum of the given file, read from a
+// checksum file in the sha256sum format (like "<hash> *php-8.3.12.zip"), or
+// containing the checksum only
+func fetchChecksum(ctx context.Context, client *http.Client, url, name string) (string, error) {
+	resp, err := httpGet(ctx, client, url)
+	if err == errBuildNotFound {
+		return "", errors.Errorf("unable to verify the build: no checksum found at %s", url)
+	} else if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to download %s", url)
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 1 || len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			if checksum := strings.ToLower(fields[0]); sha256Regexp.MatchString(checksum) {
+				return checksum, nil
+			}
+		}
+	}
+	return "", errors.Errorf("unable to verify the build: no checksum of %s found at %s", name, url)
+}
+
+// like 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+var sha256Regexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// httpGet sends a GET request, returning errBuildNotFound on 404
+func httpGet(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to download %s", url)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errBuildNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("unexpected status %s for %s", resp.Status, url)
+	}
+	return resp, nil
+}
+
+// extractTarGz extracts the files of a .tar.gz archive in dir
+func extractTarGz(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return errors.Wrap(err, "unable to read the archive")
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to read the archive")
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := extractFile(tr, dir, header.Name, header.FileInfo().Mode()); err != nil {
+			return err
+		}
+	}
+}
+
+// extractZip extracts the files of a .zip archive in dir
+func extractZip(r io.ReaderAt, size int64, dir string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return errors.Wrap(err, "unable to read the archive")
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return errors.Wrapf(err, "unable to read %s from the archive", f.Name)
+		}
+		err = extractFile(rc, dir, f.Name, f.Mode())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile writes a file of an archive in dir, rejecting names outside of
+// it (like ../../bin/php)
+func extractFile(r io.Reader, dir, name string, mode os.FileMode) error {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+		return errors.Errorf("invalid file %s in the archive", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "unable to extract %s", name)
+	}
+	return errors.WithStack(f.Close())
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-version"
+)
+
+// Channels through which PHP versions can be installed (see Installable)
+const (
+	ChannelHomebrew     = "homebrew"
+	ChannelStaticPHPCLI = "static-php-cli"
+	ChannelPHPNet       = "php.net"
+)
+
+// Installable describes how to install a PHP version that is not available
+// locally
+type Installable struct {
+	// Version is the minor version (like 8.2), and Release its latest known
+	// release (like 8.2.24), if any
+	Version string
+	Release string
+	// Channel is where the version is available from (see Channel*
+	// constants), and Name the name of the package in this channel (like
+	// php@8.2)
+	Channel string
+	Name    string
+	// Command installs the version (like "brew install php@8.2"), and URL is
+	// the download page; only one of them is set
+	Command string
+	URL     string
+}
+
+func (i *Installable) String() string {
+	how := i.URL
+	if i.Command != "" {
+		how = fmt.Sprintf(`run "%s"`, i.Command)
+	}
+	switch i.Channel {
+	case ChannelHomebrew:
+		return fmt.Sprintf("PHP %s is available as %s via Homebrew (%s)", i.Version, i.Name, how)
+	case ChannelStaticPHPCLI:
+		return fmt.Sprintf("PHP %s is available as a static build (%s)", i.Version, how)
+	default:
+		return fmt.Sprintf("PHP %s is available on php.net (%s)", i.Version, how)
+	}
+}
+
+// staticPHPCLIMinimum is the oldest version built by static-php-cli
+var staticPHPCLIMinimum = version.Must(version.NewVersion("8.1.0"))
+
+// Installable returns the ways to install the most recent minor version
+// matching the given requirement (like 8.2, ^8.1, or 8.3.4), by order of
+// preference for the current platform; it returns nil when no known version
+// matches. Latest releases are only known when they were downloaded (see
+// WithOutdatedPatchCheck).
+func (s *PHPStore) Installable(requirement string) []*Installable {
+	latest := s.latestReleases()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.installable(requirement, latest)
+}
+
+func (s *PHPStore) installable(requirement string, latest map[string]string) []*Installable {
+	requirement, _ = s.resolveAlias(requirement)
+	requirement, _ = splitFlavor(requirement)
+	var candidates versions
+	addCandidate := func(minor string) {
+		// the latest release when known, any patch version of the minor otherwise
+		release := minor + ".99"
+		if r, ok := latest[minor]; ok {
+			release = r
+		}
+		if fv, err := parsePHPVersion(release); err == nil {
+			candidates = append(candidates, &Version{Version: release, FullVersion: fv})
+		}
+	}
+	for minor := range releaseCalendar {
+		addCandidate(minor)
+	}
+	for minor := range latest {
+		if _, ok := releaseCalendar[minor]; !ok {
+			addCandidate(minor)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Sort(candidates)
+	newest := candidates[len(candidates)-1].minorVersion()
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if ok, _, _ := matchRequirement(candidates[i], requirement); ok {
+			minor := candidates[i].minorVersion()
+			return installableVersions(minor, latest[minor], newest)
+		}
+	}
+	return nil
+}
+
+// installableVersions returns the ways to install the given minor version
+// (see Installable); newest is the most recent known minor version
+func installableVersions(minor, release, newest string) []*Installable {
+	var installables []*Installable
+	if _, err := exec.LookPath("brew"); err == nil && runtime.GOOS != "windows" {
+		v := &Version{Version: minor + ".0"}
+		v.annotateSupport(time.Now())
+		name := "php@" + minor
+		if minor == newest {
+			name = "php"
+		} else if v.EOL {
+			// versions not supported anymore are only provided by a tap
+			name = "shivammathur/php/php@" + minor
+		}
+		installables = append(installables, &Installable{Version: minor, Release: release, Channel: ChannelHomebrew, Name: name, Command: "brew install " + name})
+	}
+	if fv, err := parsePHPVersion(minor + ".0"); err == nil && !fv.LessThan(staticPHPCLIMinimum) {
+		installables = append(installables, &Installable{Version: minor, Release: release, Channel: ChannelStaticPHPCLI, Name: "php-" + minor, URL: "https://dl.static-php.dev/static-php-cli/common/"})
+	}
+	url := "https://www.php.net/downloads.php"
+	if runtime.GOOS == "windows" {
+		url = "https://windows.php.net/download/"
+	}
+	return append(installables, &Installable{Version: minor, Release: release, Channel: ChannelPHPNet, Name: "php-" + minor, URL: url})
+}
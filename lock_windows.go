@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"os"
+	"time"
+)
+
+// tryLockFile tries to acquire a lock on path without blocking; as there are
+// no advisory locks on Windows, the lock is the exclusive creation of the file
+// (shared locks are exclusive as well); errLockBusy is returned when the lock
+// is held by another process
+func tryLockFile(path string, exclusive bool) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		// the lock of a crashed process
+		if fi, statErr := os.Stat(path); statErr == nil && time.Since(fi.ModTime()) > 30*time.Second {
+			os.Remove(path)
+		}
+		return nil, errLockBusy
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return func() {
+		os.Remove(path)
+	}, nil
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+// needsMetadata returns true when the metadata only known by running PHP
+// (like loaded extensions) is missing, like for versions discovered via
+// php-config or whose version was read from the binary
+func (v *Version) needsMetadata() bool {
+	return v.Extensions == nil && v.PHPPath != "" && !v.Remote && !v.FrankenPHP
+}
+
+// probeMetadata runs the PHP binaries of the versions missing metadata (see
+// needsMetadata) and records it in the cache, so that binaries are only run
+// when their metadata is needed, and only once; it must be called before
+// locking the store
+func (s *PHPStore) probeMetadata() {
+	pending := s.pendingMetadata()
+	if len(pending) == 0 {
+		return
+	}
+
+	probed := make(map[string]*phpProbe)
+	var failed []string
+	for _, php := range pending {
+		info, err := runProbe(php)
+		if err != nil {
+			s.logWith([]interface{}{"path", php, "error", err}, "Unable to get metadata from %s: %s", php, err)
+			failed = append(failed, php)
+			continue
+		}
+		probed[php] = info
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unprobed == nil {
+		s.unprobed = make(map[string]bool)
+	}
+	for _, php := range failed {
+		s.unprobed[php] = true
+	}
+	if len(probed) == 0 {
+		return
+	}
+	// versions are replaced rather than updated, as callers might be reading
+	// them without holding the lock
+	vs := make(versions, len(s.versions))
+	for i, v := range s.versions {
+		if info := probed[v.PHPPath]; info != nil && v.needsMetadata() {
+			updated := *v
+			// the architecture read from the binary is more accurate (like
+			// for universal binaries)
+			arch := updated.Arch
+			info.apply(&updated)
+			if arch != "" {
+				updated.Arch = arch
+			}
+			if updated.Extensions == nil {
+				updated.Extensions = []string{}
+			}
+			updated.warnings = info.Warnings
+			v = &updated
+		}
+		vs[i] = v
+	}
+	s.setVersions(vs)
+	s.writeCache()
+}
+
+// probeMetadataForDir probes the metadata of versions when the projects of dir
+// require PHP extensions (see requiredExtensionsForDir)
+func (s *PHPStore) probeMetadataForDir(dir string) {
+	if len(s.pendingMetadata()) > 0 && len(s.requiredExtensionsForDir(dir)) > 0 {
+		s.probeMetadata()
+	}
+}
+
+// pendingMetadata returns the PHP binaries of the versions missing metadata
+func (s *PHPStore) pendingMetadata() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var pending []string
+	for _, v := range s.versions {
+		if v.needsMetadata() && !s.unprobed[v.PHPPath] {
+			pending = append(pending, v.PHPPath)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"strings"
+	"syscall"
+)
+
+// networkFilesystemTypes are the names of the filesystems that are slow to
+// scan (like macFUSE mounts)
+var networkFilesystemTypes = []string{"nfs", "smbfs", "afpfs", "webdav", "osxfuse", "macfuse", "fuse"}
+
+// networkFilesystem returns the type of the filesystem of path when it is a
+// network one, or an empty string
+func networkFilesystem(path string) string {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return ""
+	}
+	var name strings.Builder
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		name.WriteByte(byte(c))
+	}
+	for _, fs := range networkFilesystemTypes {
+		if strings.HasPrefix(name.String(), fs) {
+			return name.String()
+		}
+	}
+	return ""
+}
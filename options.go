@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import "time"
+
+// Option configures a PHPStore (see New)
+type Option func(*PHPStore)
+
+// WithSources sets the sources used by BestVersionForDir, by order of
+// precedence (see DefaultSources); sources not listed are disabled.
+// It takes precedence over the configuration file.
+func WithSources(sources ...string) Option {
+	return func(s *PHPStore) {
+		s.sources = s.validateSources(sources)
+	}
+}
+
+// WithStrict makes BestVersionForDir return an UnsatisfiedVersionError when
+// the required version is not available instead of falling back to the
+// default one
+func WithStrict() Option {
+	return func(s *PHPStore) {
+		s.strict = true
+	}
+}
+
+// WithNativePreference makes BestVersionForDir and BestVersionsForDir prefer
+// versions running natively over emulated ones (like x86_64 builds running
+// under Rosetta on Apple Silicon)
+func WithNativePreference() Option {
+	return func(s *PHPStore) {
+		s.preferNative = true
+	}
+}
+
+// WithDiscoveryConcurrency sets the maximum number of PHP binaries probed
+// concurrently during discovery (defaults to the number of CPUs)
+func WithDiscoveryConcurrency(n int) Option {
+	return func(s *PHPStore) {
+		if n > 0 {
+			s.discoveryConcurrency = n
+		}
+	}
+}
+
+// WithDiscoveryEvents registers callbacks notified of the discovery progress
+func WithDiscoveryEvents(events DiscoveryEvents) Option {
+	return func(s *PHPStore) {
+		s.discoveryEvents = events
+	}
+}
+
+// WithStructuredLogger sends discovery logs to a structured logger (like a
+// *slog.Logger), in addition to the logger passed to New
+func WithStructuredLogger(logger StructuredLogger) Option {
+	return func(s *PHPStore) {
+		s.structuredLogger = logger
+	}
+}
+
+// WithLazyDiscovery defers loading or discovering versions until they are
+// first needed, so that commands not using PHP do not pay the cost
+func WithLazyDiscovery() Option {
+	return func(s *PHPStore) {
+		s.lazy = true
+	}
+}
+
+// WithCacheTTL sets the maximum age of the versions cache; when it is older,
+// cached versions are still used but the cache is refreshed in the background
+// for the next invocations (see WaitForRefresh)
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(s *PHPStore) {
+		s.cacheTTL = ttl
+	}
+}
+
+// WithExcludedPaths skips the directories matching the given glob patterns
+// (like /mnt/* or ~/shares/*, see filepath.Match) and their subdirectories
+// during discovery, in addition to the ones of the configuration file
+func WithExcludedPaths(patterns ...string) Option {
+	return func(s *PHPStore) {
+		s.excludedPaths = append(s.excludedPaths, expandPaths(patterns)...)
+	}
+}
+
+// WithNetworkFilesystems scans directories on network filesystems (like NFS,
+// SMB, or FUSE mounts) during discovery; they are skipped by default as they
+// can make discovery take tens of seconds
+func WithNetworkFilesystems() Option {
+	return func(s *PHPStore) {
+		s.scanNetworkFilesystems = true
+	}
+}
+
+// LookupOption configures a lookup of BestVersionForDir
+type LookupOption func(*lookup)
+
+type lookup struct {
+	flavor string
+}
+
+func newLookup(opts []LookupOption) *lookup {
+	l := &lookup{}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// WithFlavor requires a version supporting the given flavor (see Flavor*
+// constants), like FPM for a web server; it takes precedence over the flavor
+// suffix of the project requirement (like 8.3-cgi)
+func WithFlavor(flavor string) LookupOption {
+	return func(l *lookup) {
+		l.flavor = flavor
+	}
+}
+
+// WithNoCacheWrite never writes the versions cache (like on read-only
+// filesystems or in CI jobs); an existing cache is still used, unless
+// versions are reloaded
+func WithNoCacheWrite() Option {
+	return func(s *PHPStore) {
+		s.noCacheWrite = true
+	}
+}
+
+// WithMemoryCache keeps the versions cache in memory instead of on disk; it
+// is shared by the stores of the current process using the same
+// configuration directory (like in tests)
+func WithMemoryCache() Option {
+	return func(s *PHPStore) {
+		s.memoryCache = true
+	}
+}
+
+// WithCachePath sets the location of the versions cache (defaults to
+// php_versions.json in the configuration directory, or a file keyed by the
+// configuration directory in the XDG cache directory on Linux)
+func WithCachePath(path string) Option {
+	return func(s *PHPStore) {
+		if p, err := expandPath(path); err == nil {
+			s.cacheFile = p
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// windowsExecutableExtensions returns the extensions of executables on
+// Windows, by order of precedence (PATHEXT)
+func windowsExecutableExtensions() []string {
+	pathext := os.Getenv("PATHEXT")
+	if pathext == "" {
+		pathext = ".COM;.EXE;.BAT;.CMD"
+	}
+	var exts []string
+	for _, ext := range strings.Split(pathext, ";") {
+		if ext = strings.ToLower(strings.TrimSpace(ext)); ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
+
+// resolveWindowsBinary returns the executable of binName (like php or
+// php83) in dir, trying the extensions of PATHEXT; wrappers (like the .bat
+// files of Herd or the shims of Scoop) are followed to the real executable.
+// It returns an empty string when no executable is found.
+func resolveWindowsBinary(dir, binName string) string {
+	for _, ext := range windowsExecutableExtensions() {
+		path := filepath.Join(dir, binName+ext)
+		if fi, err := os.Stat(path); err != nil || fi.IsDir() {
+			continue
+		}
+		switch ext {
+		case ".bat", ".cmd":
+			if target := wrapperTarget(path); target != "" {
+				return target
+			}
+		case ".exe":
+			if target := shimTarget(filepath.Join(dir, binName+".shim")); target != "" {
+				return target
+			}
+		}
+		return path
+	}
+	return ""
+}
+
+var wrapperTargetRegexp = regexp.MustCompile(`(?i)"([^"]+\.exe)"|([^\s"]+\.exe)`)
+
+// wrapperTarget returns the executable run by a batch file, if it exists
+func wrapperTarget(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		lower := strings.ToLower(line)
+		if strings.HasPrefix(lower, "rem ") || strings.HasPrefix(lower, "::") {
+			continue
+		}
+		for _, m := range wrapperTargetRegexp.FindAllStringSubmatch(line, -1) {
+			target := m[1] + m[2]
+			// %~dp0 is the directory of the batch file
+			target = strings.ReplaceAll(target, "%~dp0", filepath.Dir(path)+string(filepath.Separator))
+			target = filepath.FromSlash(strings.ReplaceAll(target, `\`, "/"))
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			if _, err := os.Stat(target); err == nil {
+				return filepath.Clean(target)
+			}
+		}
+	}
+	return ""
+}
+
+// shimTarget returns the executable of a Scoop shim file (path = "...")
+func shimTarget(path string) string {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "path" {
+			continue
+		}
+		target := strings.Trim(strings.TrimSpace(parts[1]), `"`)
+		if _, err := os.Stat(target); err == nil {
+			return target
+		}
+	}
+	return ""
+}
+
+// pathEnv returns the value of the PATH environment variable; on Windows, the
+// name of the variable is case insensitive (like Path or PATH)
+func pathEnv() string {
+	if runtime.GOOS != "windows" {
+		return os.Getenv("PATH")
+	}
+	for _, kv := range os.Environ() {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 && strings.EqualFold(parts[0], "PATH") {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+var windowsVarRegexp = regexp.MustCompile(`%([^%]+)%`)
+
+// expandWindowsVars expands %VARIABLE% references (like %USERPROFILE% or
+// %LOCALAPPDATA% in registry-style PATH entries); undefined variables are
+// left as is
+func expandWindowsVars(s string) string {
+	return windowsVarRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		if value, ok := os.LookupEnv(ref[1 : len(ref)-1]); ok {
+			return value
+		}
+		return ref
+	})
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// like php-8.2.0 or php-8.2.0+fpm+debug (phpbrew install --name)
+var phpbrewBuildRegexp = regexp.MustCompile(`^php-\d+\.\d+\.\d+(?:(?:RC|alpha|beta)\d+)?((?:\+[^+]+)*)$`)
+
+// phpbrewRoot returns the root directory of phpbrew ($PHPBREW_ROOT or
+// ~/.phpbrew), or an empty string when the home directory is unknown
+func phpbrewRoot() string {
+	if root := os.Getenv("PHPBREW_ROOT"); root != "" {
+		return root
+	}
+	homeDir, err := homedir.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".phpbrew")
+}
+
+// phpbrewBuildsDir returns the directory of phpbrew builds
+func phpbrewBuildsDir() string {
+	root := phpbrewRoot()
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, "php")
+}
+
+// phpbrewVariants returns the variants of a phpbrew build (like fpm, debug,
+// or zts) read from the name of its directory
+func phpbrewVariants(path string) []string {
+	if dir := phpbrewBuildsDir(); dir == "" || filepath.Dir(path) != dir {
+		return nil
+	}
+	m := phpbrewBuildRegexp.FindStringSubmatch(filepath.Base(path))
+	if m == nil || m[1] == "" {
+		return nil
+	}
+	return strings.Split(m[1][1:], "+")
+}
+
+// applyPHPBrewVariants records the variants of phpbrew builds; the zts and
+// debug variants tell the build type when PHP cannot be probed
+func (v *Version) applyPHPBrewVariants() {
+	v.Variants = phpbrewVariants(v.Path)
+	for _, variant := range v.Variants {
+		switch variant {
+		case "zts":
+			v.ThreadSafe = true
+		case "debug":
+			v.DebugBuild = true
+		}
+	}
+}
+
+// phpbrewCurrent returns the directory of the build selected with phpbrew
+// (see readPHPBrewCurrent); it is read once per store
+func (s *PHPStore) phpbrewCurrent() string {
+	s.phpbrewOnce.Do(func() {
+		s.phpbrewCurrentDir = readPHPBrewCurrent()
+	})
+	return s.phpbrewCurrentDir
+}
+
+// readPHPBrewCurrent returns the directory of the build selected with phpbrew
+// use ($PHPBREW_PHP) or phpbrew switch (saved in ~/.phpbrew/init); the init
+// file is only read when phpbrew is set up in the shell ($PHPBREW_ROOT or
+// $PHPBREW_HOME is set), as it is left behind when phpbrew is not used anymore
+func readPHPBrewCurrent() string {
+	dir := phpbrewBuildsDir()
+	if dir == "" {
+		return ""
+	}
+	if name := os.Getenv("PHPBREW_PHP"); name != "" {
+		return filepath.Join(dir, name)
+	}
+	if os.Getenv("PHPBREW_ROOT") == "" && os.Getenv("PHPBREW_HOME") == "" {
+		return ""
+	}
+	contents, err := os.ReadFile(filepath.Join(phpbrewRoot(), "init"))
+	if err != nil {
+		return ""
+	}
+	// like export PHPBREW_PHP=php-8.2.0
+	sc := bufio.NewScanner(bytes.NewReader(contents))
+	for sc.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(sc.Text()), "export ")
+		if strings.HasPrefix(line, "PHPBREW_PHP=") {
+			if name := strings.Trim(strings.TrimPrefix(line, "PHPBREW_PHP="), `"'`); name != "" {
+				return filepath.Join(dir, name)
+			}
+		}
+	}
+	return ""
+}
+
+// phpbrewAlias returns the directory of the phpbrew build with the given
+// custom name (like phpbrew install 8.2 as legacy), if it was discovered;
+// builds named after their version are not aliases
+func (s *PHPStore) phpbrewAlias(name string) string {
+	if !aliasNameRegexp.MatchString(name) || phpbrewBuildRegexp.MatchString(name) {
+		return ""
+	}
+	dir := phpbrewBuildsDir()
+	if dir == "" {
+		return ""
+	}
+	path := filepath.Join(dir, name)
+	if v := s.versionAtPath(path); v == nil {
+		return ""
+	}
+	return path
+}
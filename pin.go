@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SetVersionForDir pins the PHP version of a project by writing the given
+// requirement (like 8.3, 8.3.8, or 8.3-fpm) to the .php-version file of the
+// directory, which is honored by BestVersionForDir. An empty requirement
+// removes the pin.
+func (s *PHPStore) SetVersionForDir(dir, requirement string) error {
+	s.load()
+	file := filepath.Join(dir, ".php-version")
+	requirement = strings.TrimSpace(requirement)
+	if requirement == "" {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+		return nil
+	}
+	s.mu.RLock()
+	v := s.matchInstalledVersion(requirement)
+	s.mu.RUnlock()
+	if v == nil {
+		return errors.Errorf("no installed PHP version matches %q", requirement)
+	}
+	return writeVersionFile(file, requirement)
+}
+
+// ProjectVersionOptions configures WriteProjectVersion
+type ProjectVersionOptions struct {
+	// Composer writes the config.platform.php value of composer.json instead
+	// of the .php-version file
+	Composer bool
+	// Patch pins the patch version (like 8.3.8) instead of the minor version
+	// (like 8.3, or 8.3.99 for composer.json)
+	Patch bool
+	// Flavor is appended to the version of the .php-version file (like
+	// 8.3-fpm, see Flavor* constants)
+	Flavor string
+}
+
+// WriteProjectVersion pins the given version for the project in dir, by
+// writing its .php-version file or the config.platform.php value of its
+// composer.json (see SetComposerPlatformVersion); it returns the path of the
+// updated file. The line ending of an existing .php-version file is kept.
+func (s *PHPStore) WriteProjectVersion(dir string, v *Version, opts ProjectVersionOptions) (string, error) {
+	if v.Remote {
+		return "", errors.Errorf("unable to pin PHP %s of %s as it is installed on a remote host", v.Version, v.Host)
+	}
+	minor := v.minorVersion()
+	if minor == "" {
+		return "", errors.Errorf("invalid PHP version %q", v.Version)
+	}
+	if opts.Composer {
+		platform := v.ComposerPlatformVersion()
+		if opts.Patch {
+			platform = v.Version
+		}
+		return s.setComposerPlatform(dir, platform)
+	}
+	requirement := minor
+	if opts.Patch {
+		requirement = v.Version
+	}
+	if opts.Flavor != "" {
+		if !v.SupportsFlavor(opts.Flavor) {
+			return "", errors.Errorf("PHP %s does not support the %q flavor", v.Version, opts.Flavor)
+		}
+		requirement = withFlavor(requirement, opts.Flavor)
+	}
+	file := filepath.Join(dir, ".php-version")
+	return file, writeVersionFile(file, requirement)
+}
+
+// writeVersionFile writes a requirement to a .php-version file, followed by a
+// single line ending: the one of the existing file, or \n
+func writeVersionFile(file, requirement string) error {
+	eol := "\n"
+	if contents, err := os.ReadFile(file); err == nil && strings.Contains(string(contents), "\r\n") {
+		eol = "\r\n"
+	}
+	return errors.WithStack(os.WriteFile(file, []byte(strings.TrimSpace(requirement)+eol), 0644))
+}
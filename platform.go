@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// ComposerPlatformVersion returns the config.platform.php value of
+// composer.json for the version (like 8.2.99 for PHP 8.2.1): Composer then
+// resolves dependencies for the minor version, whatever the installed patch
+// version, and BestVersionForDir selects its latest patch version instead of
+// requiring an exact match (see bestVersion).
+func (v *Version) ComposerPlatformVersion() string {
+	minor := v.minorVersion()
+	if minor == "" {
+		return ""
+	}
+	return minor + ".99"
+}
+
+// SetComposerPlatformVersion writes the config.platform.php value matching
+// the version (see ComposerPlatformVersion) to the composer.json of the given
+// directory and up; the order of the keys and the indentation of the file
+// are kept. Run "composer update --lock" afterwards as the platform
+// configuration is part of the hash of composer.lock.
+func (s *PHPStore) SetComposerPlatformVersion(dir string, v *Version) error {
+	platform := v.ComposerPlatformVersion()
+	if platform == "" {
+		return errors.Errorf("invalid PHP version %q", v.Version)
+	}
+	_, err := s.setComposerPlatform(dir, platform)
+	return err
+}
+
+// setComposerPlatform writes the config.platform.php value of the
+// composer.json of dir and up (see composerFile), and returns its path
+func (s *PHPStore) setComposerPlatform(dir, platform string) (string, error) {
+	contents, file := s.composerFileForDir(dir)
+	if contents == nil {
+		return "", errors.Errorf("no %s found in %s or its parents", composerFile(), dir)
+	}
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	updated, err := setJSONValue(contents, platform, "config", "platform", "php")
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to update %s", file)
+	}
+	return file, errors.WithStack(os.WriteFile(file, updated, 0644))
+}
+
+// like the first indented line of {\n    "name": ...
+var jsonIndentRegexp = regexp.MustCompile(`\{\s*?\n([ \t]+)"`)
+
+// setJSONValue sets the string value at the given path of keys of a JSON
+// object, creating intermediate objects when needed, and keeping the order of
+// keys and the indentation
+func setJSONValue(contents []byte, value string, keys ...string) ([]byte, error) {
+	indent := "    "
+	if m := jsonIndentRegexp.FindSubmatch(contents); m != nil {
+		indent = string(m[1])
+	}
+	root := &jsonObject{}
+	if err := json.Unmarshal(contents, root); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := root.set(keys, raw); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	if err := enc.Encode(root); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonObject is a JSON object keeping the order of its keys
+type jsonObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+// set sets the value at the given path of keys
+func (o *jsonObject) set(keys []string, value json.RawMessage) error {
+	if len(keys) == 1 {
+		o.put(keys[0], value)
+		return nil
+	}
+	child := &jsonObject{}
+	if raw, ok := o.values[keys[0]]; ok && !bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		if err := json.Unmarshal(raw, child); err != nil {
+			return errors.Errorf("%s is not an object", keys[0])
+		}
+	}
+	if err := child.set(keys[1:], value); err != nil {
+		return err
+	}
+	// not json.Marshal as it would escape HTML characters
+	raw, err := child.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	o.put(keys[0], raw)
+	return nil
+}
+
+func (o *jsonObject) put(key string, value json.RawMessage) {
+	if o.values == nil {
+		o.values = make(map[string]json.RawMessage)
+	}
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *jsonObject) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t != json.Delim('{') {
+		return errors.New("not a JSON object")
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		o.put(t.(string), value)
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(o.values[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// probeScript prints the metadata of the PHP binary running it as a single
+// JSON line (double quotes are avoided to ease escaping on Windows)
+const probeScript = `$extensions = get_loaded_extensions();
+$jit = ini_get('opcache.jit');
+echo PHP_EOL, json_encode(array(
+	'version' => PHP_VERSION,
+	'zts' => (bool) PHP_ZTS,
+	'debug' => (bool) PHP_DEBUG,
+	'ini' => (string) php_ini_loaded_file(),
+	'ini_scan_dir' => getenv('PHP_INI_SCAN_DIR') !== false ? getenv('PHP_INI_SCAN_DIR') : PHP_CONFIG_FILE_SCAN_DIR,
+	'extension_dir' => (string) ini_get('extension_dir'),
+	'extensions' => $extensions,
+	'extension_versions' => array_map(function ($name) { return (string) phpversion($name); }, $extensions),
+	'arch' => php_uname('m'),
+	'xdebug_mode' => (string) ini_get('xdebug.mode'),
+	'opcache_enable' => (string) ini_get('opcache.enable'),
+	'opcache_enable_cli' => (string) ini_get('opcache.enable_cli'),
+	'opcache_jit' => $jit === false ? null : $jit,
+	'opcache_jit_buffer_size' => (string) ini_get('opcache.jit_buffer_size'),
+)), PHP_EOL;`
+
+// probeTimeout is the maximum duration of the runs of binaries during
+// discovery; broken binaries (like a PHP with an extension waiting for a
+// network connection) are killed after that
+var probeTimeout = 10 * time.Second
+
+// probeCommand returns a command to run a binary during discovery, with a
+// timeout and an environment without the variables changing the behavior of
+// PHP (like PHPRC or XDEBUG_CONFIG); the returned function must be called
+// when the command is done
+func probeCommand(path string, args ...string) (*exec.Cmd, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = probeEnv()
+	return cmd, cancel
+}
+
+// probeEnv returns the current environment without the variables that
+// change the configuration of PHP
+func probeEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name := strings.ToUpper(strings.SplitN(kv, "=", 2)[0])
+		if name == "PHPRC" || name == "PHP_INI_SCAN_DIR" || strings.HasPrefix(name, "XDEBUG_") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// like "PHP 8.3.4 (cli) (built: Mar 12 2024 23:42:26) (NTS)"; anchored at the
+// start of a line as startup warnings can mention PHP versions as well
+var versionBannerRegexp = regexp.MustCompile(`^PHP (\d+\.\d+\.\d+[0-9A-Za-z.+~-]*)`)
+
+// like "PHP Warning:  Failed loading Zend extension 'xdebug.so'" or
+// "Warning: PHP Startup: Unable to load dynamic library 'redis'"
+var startupWarningRegexp = regexp.MustCompile(`^(?:PHP )?(?:Warning|Notice|Deprecated|Fatal error|Startup)\b|^Failed loading `)
+
+// parseVersionBanner returns the version and the banner line of the output
+// of php -v, and the startup warnings displayed before the banner
+func parseVersionBanner(out []byte) (string, string, []string) {
+	var warnings []string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if m := versionBannerRegexp.FindStringSubmatch(line); m != nil {
+			return m[1], line, warnings
+		}
+		if startupWarningRegexp.MatchString(line) {
+			warnings = append(warnings, line)
+		}
+	}
+	return "", "", warnings
+}
+
+// startupWarnings returns the startup warnings of the output of a binary
+func startupWarnings(out []byte) []string {
+	_, _, warnings := parseVersionBanner(out)
+	return warnings
+}
+
+// phpProbe is the metadata reported by probeScript
+type phpProbe struct {
+	Version      string   `json:"version"`
+	ZTS          bool     `json:"zts"`
+	Debug        bool     `json:"debug"`
+	Ini          string   `json:"ini"`
+	IniScanDir   string   `json:"ini_scan_dir"`
+	ExtensionDir string   `json:"extension_dir"`
+	Extensions   []string `json:"extensions"`
+	// ExtensionVersions are the versions of Extensions (in the same order)
+	ExtensionVersions []string `json:"extension_versions"`
+	Arch              string   `json:"arch"`
+	XdebugMode        string   `json:"xdebug_mode"`
+	OPcacheEnable     string   `json:"opcache_enable"`
+	OPcacheEnableCLI  string   `json:"opcache_enable_cli"`
+	// OPcacheJIT is nil when OPcache is compiled without JIT support
+	OPcacheJIT           *string `json:"opcache_jit"`
+	OPcacheJITBufferSize string  `json:"opcache_jit_buffer_size"`
+	// Warnings are the startup warnings displayed on stderr
+	Warnings []string `json:"-"`
+}
+
+// runProbe gets the metadata of a PHP binary by running probeScript, so that
+// a single process is needed per binary; args are passed before the PHP
+// flags (like the php-cli command of FrankenPHP)
+func runProbe(php string, args ...string) (*phpProbe, error) {
+	var stdout, stderr bytes.Buffer
+	// the php.ini is loaded (no -n flag) to report the configured extensions;
+	// startup errors are sent to stderr so that they don't break the JSON
+	args = append(args, "-d", "display_errors=stderr", "-d", "display_startup_errors=0", "-r", probeScript)
+	cmd, cancel := probeCommand(php, args...)
+	defer cancel()
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "unable to run %s", php)
+	}
+	sc := bufio.NewScanner(&stdout)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if !bytes.HasPrefix(sc.Bytes(), []byte(`{"version":`)) {
+			continue
+		}
+		var p phpProbe
+		if err := json.Unmarshal(sc.Bytes(), &p); err != nil {
+			return nil, errors.Wrapf(err, "unable to decode the metadata of %s", php)
+		}
+		p.Warnings = startupWarnings(stderr.Bytes())
+		return &p, nil
+	}
+	return nil, errors.Errorf("unable to get the metadata of %s", php)
+}
+
+// apply copies the metadata to the version
+func (p *phpProbe) apply(v *Version) {
+	v.Extensions = nil
+	v.ExtensionVersions = nil
+	for i, ext := range p.Extensions {
+		ext = normalizeExtensionName(ext)
+		v.Extensions = append(v.Extensions, ext)
+		if i < len(p.ExtensionVersions) && p.ExtensionVersions[i] != "" {
+			if v.ExtensionVersions == nil {
+				v.ExtensionVersions = make(map[string]string)
+			}
+			v.ExtensionVersions[ext] = p.ExtensionVersions[i]
+		}
+	}
+	v.ThreadSafe = p.ZTS
+	v.DebugBuild = p.Debug
+	v.IniPath = p.Ini
+	v.IniScanDir = p.IniScanDir
+	v.ExtensionDir = p.ExtensionDir
+	v.Arch = p.Arch
+
+	v.Xdebug = nil
+	if v.HasExtension("xdebug") {
+		v.Xdebug = &Xdebug{Enabled: true, Version: v.ExtensionVersion("xdebug"), Mode: p.XdebugMode}
+	} else if p.ExtensionDir != "" {
+		for _, name := range []string{"xdebug.so", "php_xdebug.dll"} {
+			if _, err := os.Stat(filepath.Join(p.ExtensionDir, name)); err == nil {
+				v.Xdebug = &Xdebug{}
+				break
+			}
+		}
+	}
+
+	v.OPcache = nil
+	if v.HasExtension("zend-opcache") {
+		v.OPcache = &OPcache{
+			Loaded:     true,
+			Enabled:    iniBool(p.OPcacheEnable),
+			EnabledCLI: iniBool(p.OPcacheEnableCLI),
+			JIT:        p.OPcacheJIT != nil,
+		}
+		if p.OPcacheJIT != nil {
+			jit := strings.ToLower(*p.OPcacheJIT)
+			size := strings.TrimSpace(p.OPcacheJITBufferSize)
+			v.OPcache.JITEnabled = jit != "" && jit != "0" && jit != "off" && jit != "disable" && size != "" && size != "0"
+		}
+	} else if p.ExtensionDir != "" {
+		for _, name := range []string{"opcache.so", "php_opcache.dll"} {
+			if _, err := os.Stat(filepath.Join(p.ExtensionDir, name)); err == nil {
+				v.OPcache = &OPcache{}
+				break
+			}
+		}
+	}
+}
+
+// iniBool returns the boolean value of a php.ini setting
+func iniBool(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "on", "yes", "true":
+		return true
+	}
+	return false
+}
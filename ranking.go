@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// BestVersionsForDir returns all the versions matching the requirements of the
+// given directory, ranked by suitability: exact matches first, then versions
+// supporting the required flavor, versions matching the source with the
+// highest precedence, versions providing the required extensions, native
+// versions (see WithNativePreference), and most recent versions. When the
+// directory has no requirements, all versions are returned, the one
+// BestVersionForDir falls back to (like the default version) first.
+// FPM-only installations are only returned when the FPM flavor is required.
+func (s *PHPStore) BestVersionsForDir(dir string, opts ...LookupOption) []*Version {
+	l := newLookup(opts)
+	s.load()
+	s.probeEnvironmentPHP(dir)
+	s.probeMetadataForDir(dir)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	type requirement struct {
+		source string
+		value  string
+	}
+	var requirements []requirement
+	if forced := os.Getenv("FORCED_PHP_VERSION"); forced != "" {
+		requirements = append(requirements, requirement{SourceForced, overrideFlavor(strings.Join(strings.Split(forced, ".")[0:2], "."), l.flavor)})
+	}
+	for _, name := range s.sources {
+		if value, _ := sourceResolvers[name](s, dir); value != "" {
+			if name != SourceDirenv {
+				value = overrideFlavor(value, l.flavor)
+			}
+			requirements = append(requirements, requirement{name, value})
+		}
+	}
+	var fallback *Version
+	if len(requirements) == 0 {
+		fallback, _, _, _ = s.fallbackVersion(nil, l.flavor)
+	}
+	extensions := s.requiredExtensionsForDir(dir)
+
+	type candidate struct {
+		v                *Version
+		rank             int
+		exact            bool
+		flavor           bool
+		missingExtension bool
+	}
+	var candidates []candidate
+	for _, v := range s.versions {
+		c := candidate{v: v, rank: -1, missingExtension: len(v.missingExtensions(extensions)) > 0}
+		for i, r := range requirements {
+			if r.source == SourceDirenv {
+				// the requirement is the path of the binary
+				if v == s.environmentVersion(r.value) {
+					c.rank, c.exact, c.flavor = i, true, v.SupportsFlavor(l.flavor)
+					break
+				}
+				continue
+			}
+			if ok, exact, flavor := s.matchAliasedRequirement(v, r.value); ok {
+				c.rank, c.exact, c.flavor = i, exact, flavor
+				break
+			}
+		}
+		if len(requirements) == 0 {
+			c.rank, c.flavor = 0, v.SupportsFlavor(l.flavor)
+			c.exact = v == fallback
+		}
+		// FPM-only installations cannot be used from the command line
+		if v.PHPPath == "" && !c.flavor {
+			continue
+		}
+		if c.rank != -1 {
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.exact != b.exact {
+			return a.exact
+		}
+		if a.flavor != b.flavor {
+			return a.flavor
+		}
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if a.missingExtension != b.missingExtension {
+			return !a.missingExtension
+		}
+		if s.preferNative && a.v.Emulated != b.v.Emulated {
+			return !a.v.Emulated
+		}
+		return b.v.fullVersion() != nil && a.v.fullVersion() != nil && a.v.fullVersion().GreaterThan(b.v.fullVersion())
+	})
+
+	ranked := make([]*Version, len(candidates))
+	for i, c := range candidates {
+		ranked[i] = c.v
+	}
+	return ranked
+}
+
+// matchRequirement checks if the version matches the requirement (see
+// requirementMatcher), and returns whether this is an exact match and whether
+// the required flavor is supported
+func matchRequirement(v *Version, requirement string) (bool, bool, bool) {
+	m := newRequirementMatcher(requirement)
+	ok, exact := m.match(v)
+	return ok, exact, v.SupportsFlavor(m.flavor)
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Refresh discovers again the versions of a single source (like homebrew,
+// phpenv, or PATH; case insensitive) instead of running a full discovery.
+// Versions whose binaries do not exist anymore are removed from the store,
+// whatever their source. The cache is updated accordingly.
+func (s *PHPStore) Refresh(source string) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.EqualFold(source, "PATH") && s.pathVersion != nil {
+		// the first PHP binary in the PATH might have changed
+		s.pathVersion.IsSystem = false
+		s.pathVersion = nil
+	}
+	previous := copyVersions(s.versions)
+	s.onlySource = source
+	s.discover()
+	s.onlySource = ""
+	s.commitRefresh(previous)
+}
+
+// RefreshDir probes the PHP installation of the given directory (like
+// /opt/php/8.4 or /opt/php/8.4/bin), adding it to the store if needed.
+// Versions whose binaries do not exist anymore are removed from the store.
+// The cache is updated accordingly.
+func (s *PHPStore) RefreshDir(dir string) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := copyVersions(s.versions)
+	s.addFromDir(filepath.Clean(dir), nil, "manual")
+	s.runProbes()
+	s.commitRefresh(previous)
+}
+
+// commitRefresh removes stale versions and updates the cache after a
+// refresh; the changes are computed from the previous versions
+func (s *PHPStore) commitRefresh(previous versions) {
+	vs, _ := s.revalidateVersions(s.versions, false)
+	s.setVersions(vs)
+	s.changes = DiffVersions(previous, s.versions)
+	s.writeCache()
+}
+
+// setVersions replaces the versions of the store
+func (s *PHPStore) setVersions(vs versions) {
+	s.versions = nil
+	s.seen = make(map[string]int)
+	s.pathVersion = nil
+	for _, v := range vs {
+		idx := s.addVersion(v)
+		if v.IsSystem && s.pathVersion == nil {
+			s.pathVersion = s.versions[idx]
+		}
+	}
+	sort.Sort(s.versions)
+	s.reindex()
+}
+
+// reindex updates the index of known binaries (see addVersion) after versions
+// have been sorted
+func (s *PHPStore) reindex() {
+	s.resolutions.reset()
+	s.seen = make(map[string]int)
+	for i, v := range s.versions {
+		s.seen[v.binary()] = i
+		if sl, _ := s.fs.evalSymlinks(v.binary()); sl != "" {
+			s.seen[sl] = i
+		}
+	}
+}
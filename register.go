@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+)
+
+// RegisterPath probes the PHP installation at the given path and adds it to
+// the store, without running a full discovery. The path can be a PHP binary
+// (like /opt/php/8.4/bin/php), its bin/ directory, or the installation
+// directory (like /opt/php/8.4). The path is saved in the configuration so
+// that it is probed again by next discoveries and when loading the cache; the
+// cache is updated as well. This is the way to use PHP installations that are
+// not in a known location, like a static binary in ~/bin or a network share.
+func (s *PHPStore) RegisterPath(path string) (*Version, error) {
+	s.load()
+	path, err := expandPath(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	v, err := s.probePath(path, "registered")
+	if err != nil {
+		return nil, err
+	}
+	v.Source = "registered"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.updateConfig(func(c *config) {
+		c.Paths = appendPath(c.Paths, path)
+	}); err != nil {
+		return nil, err
+	}
+	s.registeredPaths = appendPath(s.registeredPaths, path)
+
+	idx := s.addVersion(v)
+	v = s.versions[idx]
+	s.setVersions(s.versions)
+	s.writeCache()
+	return v, nil
+}
+
+// NewVersionFromPath probes the PHP installation at the given path like
+// discovery does (version, server binaries, metadata, ...), and returns it
+// without adding it to any store. The path can be a PHP binary (like
+// /opt/php/8.4/bin/php), its bin/ directory, or the installation directory.
+func NewVersionFromPath(path string) (*Version, error) {
+	path, err := expandPath(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return (&PHPStore{}).probePath(path, "manual")
+}
+
+// RegisteredPaths returns the paths registered with RegisterPath
+func (s *PHPStore) RegisteredPaths() []string {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.registeredPaths...)
+}
+
+// restoreRegisteredVersions probes the registered paths that have no cached
+// version, like a network share that was not mounted when the cache was last
+// validated, and returns whether versions were added
+func (s *PHPStore) restoreRegisteredVersions() bool {
+	added := false
+	for _, path := range s.registeredPaths {
+		p, err := s.probeForPath(path, "registered")
+		if err != nil || s.ignoresPath(p.binary()) {
+			continue
+		}
+		if _, ok := s.seen[p.binary()]; ok {
+			continue
+		}
+		s.log("Probing the registered path %s as it is not in the cache", path)
+		v, err := s.probePath(path, "registered")
+		if err != nil {
+			s.log("  Skipping %s: %s", path, err)
+			continue
+		}
+		v.Source = "registered"
+		s.addVersion(v)
+		added = true
+	}
+	if added {
+		sort.Sort(s.versions)
+		s.reindex()
+	}
+	return added
+}
+
+// probePath probes the PHP installation at the given absolute path
+func (s *PHPStore) probePath(path, why string) (*Version, error) {
+	p, err := s.probeForPath(path, why)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.discoverPHP(p.dir, p.binName)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, errors.Errorf("no PHP binary found in %s", path)
+	}
+	v.stampBinary()
+	return v, nil
+}
+
+// probeForPath returns the probe for a PHP binary or installation directory
+func (s *PHPStore) probeForPath(path, why string) (*probe, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if fi.IsDir() {
+		return s.findFromDir(path, nil, why)[0], nil
+	}
+	binDir := filepath.Dir(path)
+	binName := filepath.Base(path)
+	if runtime.GOOS == "windows" {
+		return &probe{dir: binDir, binName: strings.TrimSuffix(binName, ".exe"), why: why}, nil
+	}
+	if filepath.Base(binDir) != "bin" {
+		return nil, errors.Errorf("%s must be in a bin/ directory", path)
+	}
+	return &probe{dir: filepath.Dir(binDir), binName: binName, why: why}, nil
+}
+
+// expandPath returns the absolute path of the given path, expanding ~ to the
+// home directory
+func expandPath(path string) (string, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	path, err = filepath.Abs(path)
+	return path, errors.WithStack(err)
+}
+
+func appendPath(paths []string, path string) []string {
+	for _, p := range paths {
+		if p == path {
+			return paths
+		}
+	}
+	return append(paths, path)
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultReleasesURL lists the latest release of each supported branch of
+// PHP (see WithOutdatedPatchCheck)
+const DefaultReleasesURL = "https://www.php.net/releases/active.php"
+
+var (
+	// releasesTTL is the maximum age of the cached releases
+	releasesTTL = 24 * time.Hour
+	// releasesTimeout is the maximum duration of the releases download
+	releasesTimeout = 5 * time.Second
+)
+
+// releasesCache is the content of the releases cache, stored next to the
+// versions cache
+type releasesCache struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	// Latest is the latest release, by minor version (like 8.3 => 8.3.12)
+	Latest map[string]string `json:"latest"`
+}
+
+// WithOutdatedPatchCheck makes BestVersionForDirWithWarnings warn when the
+// selected version is behind the latest patch release of its branch
+// (WarningOutdatedPatch). Releases are downloaded from the given URL
+// (DefaultReleasesURL when empty) and cached for a day; no warnings are
+// returned when they cannot be downloaded.
+func WithOutdatedPatchCheck(url string) Option {
+	return func(s *PHPStore) {
+		if url == "" {
+			url = DefaultReleasesURL
+		}
+		s.releasesURL = url
+	}
+}
+
+// latestReleases returns the latest release by minor version, or nil when the
+// check is disabled (see WithOutdatedPatchCheck) or when releases are not
+// available
+func (s *PHPStore) latestReleases() map[string]string {
+	if s.releasesURL == "" {
+		return nil
+	}
+	return s.loadReleases()
+}
+
+// releasesCachePath returns the location of the releases cache, next to the
+// versions cache (and keyed like it, see defaultCachePath)
+func (s *PHPStore) releasesCachePath() string {
+	cache := s.cachePath()
+	name := filepath.Base(cache)
+	if !strings.HasPrefix(name, "php_versions") {
+		name = "php_versions.json"
+	}
+	return filepath.Join(filepath.Dir(cache), "php_releases"+strings.TrimPrefix(name, "php_versions"))
+}
+
+// loadReleases returns the latest release by minor version, or nil when
+// releases are not available; they are downloaded at most once per store
+func (s *PHPStore) loadReleases() map[string]string {
+	s.releasesOnce.Do(func() {
+		cache := s.releasesCachePath()
+		var cached releasesCache
+		if contents, err := os.ReadFile(cache); err == nil {
+			if err := json.Unmarshal(contents, &cached); err != nil {
+				s.log("Unable to use the releases cache: %s", err)
+			}
+		}
+		if cached.Latest != nil && time.Since(cached.FetchedAt) < releasesTTL {
+			s.releases = cached.Latest
+			return
+		}
+		url := s.releasesURL
+		if url == "" {
+			url = DefaultReleasesURL
+		}
+		latest, err := fetchLatestReleases(url)
+		if err != nil {
+			// stale releases are better than none
+			s.log("Unable to download the PHP releases: %s", err)
+			s.releases = cached.Latest
+			return
+		}
+		s.releases = latest
+		if s.noCacheWrite || s.memoryCache {
+			return
+		}
+		contents, err := json.MarshalIndent(releasesCache{FetchedAt: time.Now(), Latest: latest}, "", "    ")
+		if err != nil {
+			return
+		}
+		if err := os.MkdirAll(filepath.Dir(cache), 0755); err == nil {
+			_ = os.WriteFile(cache, contents, 0644)
+		}
+	})
+	return s.releases
+}
+
+// fetchLatestReleases downloads the latest release of each supported branch,
+// like {"8": {"8.3": {"version": "8.3.12", ...}, ...}}
+func fetchLatestReleases(url string) (map[string]string, error) {
+	client := &http.Client{Timeout: releasesTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %s for %s", resp.Status, url)
+	}
+	var branches map[string]map[string]struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&branches); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode %s", url)
+	}
+	latest := make(map[string]string)
+	for _, minors := range branches {
+		for minor, release := range minors {
+			if release.Version != "" {
+				latest[minor] = release.Version
+			}
+		}
+	}
+	return latest, nil
+}
+
+// outdatedPatchWarning returns a WarningOutdatedPatch warning when the version
+// is behind the latest release of its branch, or nil
+func outdatedPatchWarning(v *Version, source string, latest map[string]string) *Warning {
+	release, ok := latest[v.minorVersion()]
+	if !ok || v.Remote {
+		return nil
+	}
+	fv := v.fullVersion()
+	lv, err := parsePHPVersion(release)
+	if fv == nil || err != nil || !fv.LessThan(lv) {
+		return nil
+	}
+	return &Warning{Kind: WarningOutdatedPatch, Matched: v.Version, Latest: release, Source: source}
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// remoteDiscoveryScript is a POSIX shell script run on remote hosts; it runs
+// probeScript for each PHP CLI binary found in the PATH and in the usual
+// installation directories, after a line with the path of the binary
+var remoteDiscoveryScript = fmt.Sprintf(`for php in $(command -v php) /usr/bin/php[0-9]* /usr/local/bin/php /usr/local/bin/php[0-9]* /opt/remi/php*/root/usr/bin/php /opt/homebrew/opt/php*/bin/php /usr/local/opt/php*/bin/php; do
+	[ -x "$php" ] || continue
+	echo "@@ $php"
+	"$php" -d display_errors=stderr -d display_startup_errors=0 -r "%s" 2>/dev/null
+done
+`, strings.ReplaceAll(probeScript, "$", `\$`))
+
+// DiscoverRemote discovers the PHP versions installed on a remote host (like
+// user@example.com or an alias of ~/.ssh/config) by running the probes over
+// SSH; the ssh command must be able to connect without prompting. Only the
+// CLI binaries are discovered. The versions are marked as Remote and are
+// never used to resolve local versions; they are kept in memory by the store
+// (see RemoteVersions), not in the cache.
+func (s *PHPStore) DiscoverRemote(ctx context.Context, host string) ([]*Version, error) {
+	// like -oProxyCommand=..., which ssh would read as an option
+	if host == "" || strings.HasPrefix(host, "-") {
+		return nil, errors.Errorf("invalid host %q", host)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes", "--", host, "sh -s")
+	cmd.Stdin = strings.NewReader(remoteDiscoveryScript)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "unable to discover PHP versions on %s: %s", host, strings.TrimSpace(stderr.String()))
+	}
+
+	vs, err := parseRemoteDiscovery(host, stdout.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	s.log("Discovered %d PHP versions on %s", len(vs), host)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.remoteVersions == nil {
+		s.remoteVersions = make(map[string][]*Version)
+	}
+	s.remoteVersions[host] = vs
+	return vs, nil
+}
+
+// RemoteVersions returns the versions discovered on the given host with
+// DiscoverRemote
+func (s *PHPStore) RemoteVersions(host string) []*Version {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.remoteVersions[host]
+}
+
+// parseRemoteDiscovery returns the versions of the output of
+// remoteDiscoveryScript
+func parseRemoteDiscovery(host string, out []byte) ([]*Version, error) {
+	var vs versions
+	seen := map[string]bool{}
+	php := ""
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if bytes.HasPrefix(line, []byte("@@ ")) {
+			php = string(line[3:])
+			continue
+		}
+		if php == "" || seen[php] || !bytes.HasPrefix(line, []byte(`{"version":`)) {
+			continue
+		}
+		var p phpProbe
+		if err := json.Unmarshal(line, &p); err != nil {
+			return nil, errors.Wrapf(err, "unable to decode the metadata of %s on %s", php, host)
+		}
+		release, suffix := splitVersionSuffix(p.Version)
+		fv, err := parsePHPVersion(release)
+		if err != nil {
+			continue
+		}
+		seen[php] = true
+		v := &Version{
+			FullVersion:   fv,
+			Version:       release,
+			VersionSuffix: suffix,
+			Path:          path.Dir(path.Dir(php)),
+			PHPPath:       php,
+			Source:        "ssh",
+			Remote:        true,
+			Host:          host,
+		}
+		p.apply(v)
+		v.annotateSupport(time.Now())
+		// extensions installed but not loaded are detected on the local
+		// filesystem, which is irrelevant here
+		if v.Xdebug != nil && !v.Xdebug.Enabled {
+			v.Xdebug = nil
+		}
+		if v.OPcache != nil && !v.OPcache.Loaded {
+			v.OPcache = nil
+		}
+		vs = append(vs, v)
+	}
+	sort.Sort(vs)
+	return vs, nil
+}
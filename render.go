@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// Formats supported by WriteVersions
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// sourceLabels are the display names of discovery sources that are not
+// already capitalized
+var sourceLabels = map[string]string{
+	"homebrew":   "Homebrew",
+	"registered": "Registered",
+	"manual":     "Manual",
+}
+
+// String returns the version and the path of its PHP binary (like
+// "8.3.8 /usr/bin/php8.3")
+func (v *Version) String() string {
+	return fmt.Sprintf("%s %s", v.Version, v.binary())
+}
+
+// Label returns a short description of the version for humans, like
+// "8.3.8 (Homebrew, FPM, arm64)"
+func (v *Version) Label() string {
+	var details []string
+	if source := v.sourceLabel(); source != "" {
+		details = append(details, source)
+	}
+	details = append(details, v.serverLabel())
+	if v.Arch != "" {
+		details = append(details, v.Arch)
+	}
+	if v.Emulated {
+		details = append(details, "emulated")
+	}
+	return fmt.Sprintf("%s%s (%s)", v.Version, v.VersionSuffix, strings.Join(details, ", "))
+}
+
+func (v *Version) sourceLabel() string {
+	if v.Container != "" {
+		return v.ContainerRuntime + ": " + v.Container
+	}
+	if label, ok := sourceLabels[v.Source]; ok {
+		return label
+	}
+	return v.Source
+}
+
+// serverLabel returns the short name of the server type (like FPM or CLI)
+func (v *Version) serverLabel() string {
+	if v.IsFrankenPHPServer() {
+		return "FrankenPHP"
+	}
+	return strings.TrimPrefix(v.ServerTypeName(), "PHP ")
+}
+
+// WriteVersions renders the versions as a table (FormatTable) or as JSON
+// (FormatJSON). Versions are sorted by version, then by path, so that the
+// output is stable across discoveries.
+func WriteVersions(w io.Writer, vs []*Version, format string) error {
+	sorted := make([]*Version, len(vs))
+	copy(sorted, vs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		vi, vj := sorted[i].fullVersion(), sorted[j].fullVersion()
+		if vi != nil && vj != nil && !vi.Equal(vj) {
+			return vi.LessThan(vj)
+		}
+		return sorted[i].binary() < sorted[j].binary()
+	})
+
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return errors.WithStack(enc.Encode(sorted))
+
+	case FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tSERVER\tSOURCE\tARCH\tPATH")
+		for _, v := range sorted {
+			system := ""
+			if v.IsSystem {
+				system = " (system)"
+			}
+			fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\t%s%s\n", v.Version, v.VersionSuffix, v.serverLabel(), dash(v.sourceLabel()), dash(v.Arch), v.binary(), system)
+		}
+		return errors.WithStack(tw.Flush())
+	}
+	return errors.Errorf("unsupported format %q", format)
+}
+
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DiscoveryReport describes how the PHP versions of the store were discovered
+type DiscoveryReport struct {
+	// FromCache is true when versions were loaded from the cache (no discovery happened)
+	FromCache bool
+	// Duration is the total duration of the discovery
+	Duration time.Duration
+	// Sources lists the scanned sources, in the order they were scanned
+	Sources []*SourceReport
+}
+
+// SourceReport describes the discovery of the PHP versions of a source (like homebrew or PATH)
+type SourceReport struct {
+	Name string
+	// Duration is the time spent probing the binaries of the source
+	Duration time.Duration
+	// Probed is the number of binaries probed
+	Probed int
+	// Found is the number of PHP versions found
+	Found int
+	// Errors lists the binaries that were found but could not be used
+	Errors []error
+
+	start time.Time
+}
+
+func (r *DiscoveryReport) String() string {
+	if r.FromCache {
+		return "PHP versions loaded from cache\n"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "PHP versions discovered in %s\n", r.Duration.Round(time.Millisecond))
+	for _, source := range r.Sources {
+		fmt.Fprintf(&b, "  %s: %d found, %d probed in %s\n", source.Name, source.Found, source.Probed, source.Duration.Round(time.Millisecond))
+		for _, err := range source.Errors {
+			fmt.Fprintf(&b, "    error: %s\n", err)
+		}
+	}
+	return b.String()
+}
+
+func (r *DiscoveryReport) source(name string) *SourceReport {
+	for _, source := range r.Sources {
+		if source.Name == name {
+			return source
+		}
+	}
+	return nil
+}
+
+// DiscoveryReport returns the report of the last discovery
+func (s *PHPStore) DiscoveryReport() *DiscoveryReport {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.report
+}
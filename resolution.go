@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxResolutions is the maximum number of directories whose resolution is
+// cached; the cache is cleared when it is full
+const maxResolutions = 1000
+
+// resolution is the cached result of BestVersionForDirWithWarnings for a
+// directory; it is valid as long as the files it depends on are unchanged
+type resolution struct {
+	version  *Version
+	source   string
+	warnings Warnings
+	err      error
+	stamps   map[string]fileStamp
+}
+
+// fileStamp identifies the state of a file; the zero value is a missing file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// resolutions caches the resolutions by directory
+type resolutions struct {
+	mu      sync.Mutex
+	entries map[resolutionKey]*resolution
+}
+
+// resolutionKey identifies a resolution: the working directory, the PATH
+// (see SourceDirenv), FORCED_PHP_VERSION, COMPOSER, and the required flavor
+// are involved as well
+type resolutionKey struct {
+	dir      string
+	wd       string
+	path     string
+	forced   string
+	composer string
+	flavor   string
+}
+
+func newResolutionKey(dir, flavor string) resolutionKey {
+	wd, _ := os.Getwd()
+	return resolutionKey{dir: dir, wd: wd, path: os.Getenv("PATH"), forced: os.Getenv("FORCED_PHP_VERSION"), composer: composerFile(), flavor: flavor}
+}
+
+// get returns the cached resolution of a directory, if still valid
+func (r *resolutions) get(key resolutionKey) *resolution {
+	r.mu.Lock()
+	entry := r.entries[key]
+	r.mu.Unlock()
+	if entry == nil {
+		return nil
+	}
+	for path, stamp := range entry.stamps {
+		if statFile(path) != stamp {
+			r.mu.Lock()
+			delete(r.entries, key)
+			r.mu.Unlock()
+			return nil
+		}
+	}
+	return entry
+}
+
+func (r *resolutions) set(key resolutionKey, entry *resolution) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entries == nil || len(r.entries) >= maxResolutions {
+		r.entries = make(map[resolutionKey]*resolution)
+	}
+	r.entries[key] = entry
+}
+
+// reset clears the cache, when versions or the configuration change
+func (r *resolutions) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = nil
+}
+
+// resolutionStamps returns the state of all the files the resolution of a
+// directory depends on, the missing ones included as creating them changes
+// the resolution: the files of the enabled sources (see sourceFileNames) and
+// composer.json (for the required extensions), in the directory and up
+func (s *PHPStore) resolutionStamps(key resolutionKey) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	add := func(dir string, names []string) {
+		for {
+			for _, name := range names {
+				path := filepath.Join(dir, name)
+				if filepath.IsAbs(name) {
+					path = name
+				}
+				stamps[path] = statFile(path)
+				// adding a workflow changes the modification time of the
+				// directory, but editing one does not
+				if stamps[path] != (fileStamp{}) && name == sourceFiles[SourceGitHubActions][0] {
+					files, _ := filepath.Glob(filepath.Join(path, "*"))
+					for _, file := range files {
+						stamps[file] = statFile(file)
+					}
+				}
+			}
+			upDir := filepath.Dir(dir)
+			if upDir == dir || upDir == "." {
+				return
+			}
+			dir = upDir
+		}
+	}
+	names := append([]string{composerFile()}, s.workspaceMarkers...)
+	for _, name := range s.sources {
+		if name == SourceWorkingDirPHPVersion {
+			if key.wd != "" {
+				add(key.wd, sourceFileNames(name))
+			}
+			continue
+		}
+		names = append(names, sourceFileNames(name)...)
+	}
+	add(key.dir, names)
+	return stamps
+}
+
+// statFile returns the state of a file
+func statFile(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+)
+
+// shimRegexp matches the shim scripts of version managers (like "exec
+// phpenv exec php" or "exec mise x -- php"), capturing the manager
+var shimRegexp = regexp.MustCompile(`\b(phpenv|asdf|mise|rtx)"?\s+(?:exec|x)\b`)
+
+// shimManager returns the version manager of a shim script (like phpenv,
+// asdf, or mise), or an empty string when the binary is not a shim
+func shimManager(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	head := make([]byte, 4096)
+	n, _ := io.ReadFull(file, head)
+	head = head[:n]
+	if !bytes.HasPrefix(head, []byte("#!")) {
+		return ""
+	}
+	if m := shimRegexp.FindSubmatch(head); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// resolveShim returns the probe of the binary run by a version manager shim
+// (its global version), or nil when the binary is not a shim or when it
+// cannot be resolved
+func (s *PHPStore) resolveShim(shim, why string) *probe {
+	manager := shimManager(shim)
+	if manager == "" {
+		return nil
+	}
+	command, err := exec.LookPath(manager)
+	if err != nil {
+		// like ~/.phpenv/bin/phpenv for ~/.phpenv/shims/php
+		command = filepath.Join(filepath.Dir(filepath.Dir(shim)), "bin", manager)
+	}
+	cmd, cancel := probeCommand(command, "which", filepath.Base(shim))
+	defer cancel()
+	// shims resolve the version of the current directory, use the global one
+	if home, err := homedir.Dir(); err == nil {
+		cmd.Dir = home
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		s.logWith([]interface{}{"source", why, "path", shim, "verdict", "error", "error", err}, "  Unable to resolve the %s shim %s: %s", manager, shim, err)
+		s.problems = append(s.problems, &Problem{Kind: ProblemShimUnresolved, Path: shim, Source: why, Err: err})
+		return nil
+	}
+	target := strings.TrimSpace(string(out))
+	if filepath.Base(filepath.Dir(target)) != "bin" {
+		s.logWith([]interface{}{"source", why, "path", shim, "verdict", "error"}, "  Unable to use %s resolved from the %s shim %s", target, manager, shim)
+		s.problems = append(s.problems, &Problem{Kind: ProblemShimUnresolved, Path: shim, Source: why, Err: errors.Errorf("%s is not in a bin/ directory", target)})
+		return nil
+	}
+	s.logWith([]interface{}{"source", why, "path", shim, "verdict", "shim"}, "  %s is a %s shim for %s", shim, manager, target)
+	return &probe{dir: filepath.Dir(filepath.Dir(target)), binName: filepath.Base(target), why: why}
+}
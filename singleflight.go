@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"fmt"
+	"sync"
+)
+
+// loads deduplicates the loading of the stores sharing the same cache and
+// configuration in the current process (like the stores of a server and of its
+// workers created concurrently): only the first one loads or discovers
+// versions, the other ones wait for its result instead of running PHP binaries
+// as well
+var loads = struct {
+	sync.Mutex
+	calls map[string]*loadCall
+}{calls: make(map[string]*loadCall)}
+
+// loadCall is a load in progress; done is closed when its result is
+// available
+type loadCall struct {
+	done     chan struct{}
+	versions versions
+	problems Problems
+	roots    []string
+	report   DiscoveryReport
+	changes  *VersionsDiff
+}
+
+// loadShared runs load, or waits for the result of the one of another store
+// sharing the same cache and configuration (see loadKey)
+func (s *PHPStore) loadShared(load func()) {
+	key := s.loadKey()
+	loads.Lock()
+	if call, ok := loads.calls[key]; ok {
+		loads.Unlock()
+		s.log("Waiting for another store to load versions")
+		<-call.done
+		s.useLoad(call)
+		return
+	}
+	call := &loadCall{done: make(chan struct{})}
+	loads.calls[key] = call
+	loads.Unlock()
+
+	defer func() {
+		loads.Lock()
+		delete(loads.calls, key)
+		loads.Unlock()
+		close(call.done)
+	}()
+	load()
+
+	// the result is copied as the versions of this store might change while
+	// the other stores use theirs
+	call.versions = copyVersions(s.versions)
+	call.problems = s.problems
+	call.changes = s.changes.copy()
+	call.roots = s.roots
+	call.report = *s.report
+	call.report.Sources = make([]*SourceReport, len(s.report.Sources))
+	for i, source := range s.report.Sources {
+		sr := *source
+		call.report.Sources[i] = &sr
+	}
+}
+
+// loadKey identifies the loads whose result can be shared: the cache, and the
+// options changing the discovered versions (like ignored or excluded paths)
+func (s *PHPStore) loadKey() string {
+	key := s.cachePath()
+	if s.memoryCache {
+		key = "memory:" + key
+	}
+	return fmt.Sprintf("%s\x00%q\x00%q\x00%q\x00%q\x00%q\x00%t\x00%t", key, s.sources, s.registeredPaths, s.ignoredPaths, s.ignoredVersions, s.excludedPaths, s.scanNetworkFilesystems, s.ignoreCache)
+}
+
+// useLoad uses the versions loaded by another store
+func (s *PHPStore) useLoad(call *loadCall) {
+	s.setVersions(copyVersions(call.versions))
+	s.problems = call.problems
+	s.changes = call.changes.copy()
+	s.roots = call.roots
+	report := call.report
+	s.report = &report
+}
+
+// copyVersions returns copies of the given versions
+func copyVersions(vs versions) versions {
+	copies := make(versions, len(vs))
+	for i, v := range vs {
+		c := *v
+		copies[i] = &c
+	}
+	return copies
+}
@@ -0,0 +1,429 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Sources used by BestVersionForDir to find the PHP version required by a project
+const (
+	// SourceDirenv is the PHP binary injected in the PATH of the current
+	// process for the project by direnv (.envrc) or by a Nix shell
+	SourceDirenv = "direnv"
+	// SourcePHPVersion is the .php-version file of the script directory and up
+	SourcePHPVersion = "php-version"
+	// SourceSymfonyLocal is the php.version (and php.flavor) entry of the
+	// .symfony.local.yaml file
+	SourceSymfonyLocal = "symfony-local"
+	// SourceComposerPlatform is the config.platform.php entry of composer.json
+	SourceComposerPlatform = "composer-platform"
+	// SourceWorkingDirPHPVersion is the .php-version file of the working directory and up
+	SourceWorkingDirPHPVersion = "working-dir-php-version"
+	// SourceSymfonyCloud is the type of the .symfony.cloud.yaml file
+	SourceSymfonyCloud = "symfony-cloud"
+	// SourcePlatformSH is the type of the .platform.app.yaml file
+	SourcePlatformSH = "platformsh"
+	// SourceWPEnv is the phpVersion of the .wp-env.json file of WordPress
+	// projects (or of its .wp-env.override.json file)
+	SourceWPEnv = "wp-env"
+	// SourceAppEngine is the runtime of the app.yaml file of Google App Engine
+	SourceAppEngine = "app-engine"
+	// SourceBref is the Bref runtime (or layer) of the serverless.yml file
+	SourceBref = "bref"
+	// SourceComposerRequire is the require.php constraint of composer.json
+	SourceComposerRequire = "composer-require"
+	// SourceGitLabCI is the php Docker image used by .gitlab-ci.yml
+	SourceGitLabCI = "gitlab-ci"
+	// SourceGitHubActions is the php-version input of the setup-php action
+	// in the GitHub Actions workflows
+	SourceGitHubActions = "github-actions"
+)
+
+// DefaultSources is the default precedence of the sources used by BestVersionForDir.
+// CI hints (SourceGitLabCI and SourceGitHubActions) are not enabled by
+// default; add them at the end to use them when no other source is found.
+var DefaultSources = []string{
+	SourceDirenv,
+	SourcePHPVersion,
+	SourceSymfonyLocal,
+	SourceComposerPlatform,
+	SourceWorkingDirPHPVersion,
+	SourceSymfonyCloud,
+	SourcePlatformSH,
+	SourceWPEnv,
+	SourceAppEngine,
+	SourceBref,
+	SourceComposerRequire,
+}
+
+var appEngineRuntimeRegexp = regexp.MustCompile(`^php(\d)(\d+)$`)
+
+// like php-83, php-83-fpm, ${bref:layer.php-83-fpm} or the ARN of a layer
+var brefRuntimeRegexp = regexp.MustCompile(`\bphp-(\d)(\d+)(?:-(fpm|console))?\b`)
+
+// brefRequirement returns the version requirement of the Bref runtimes and
+// layers of a serverless.yml file; the FPM flavor is required when one of the
+// functions is a web one
+func brefRequirement(contents []byte) string {
+	type function struct {
+		Runtime string   `yaml:"runtime"`
+		Layers  []string `yaml:"layers"`
+	}
+	var serverless struct {
+		Provider  function            `yaml:"provider"`
+		Functions map[string]function `yaml:"functions"`
+	}
+	if err := yaml.Unmarshal(contents, &serverless); err != nil {
+		return ""
+	}
+	names := make([]string, 0, len(serverless.Functions))
+	for name := range serverless.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	runtimes := append([]string{serverless.Provider.Runtime}, serverless.Provider.Layers...)
+	for _, name := range names {
+		runtimes = append(runtimes, serverless.Functions[name].Runtime)
+		runtimes = append(runtimes, serverless.Functions[name].Layers...)
+	}
+	requirement := ""
+	for _, runtime := range runtimes {
+		m := brefRuntimeRegexp.FindStringSubmatch(runtime)
+		if m == nil {
+			continue
+		}
+		if m[3] == FlavorFPM {
+			return withFlavor(m[1]+"."+m[2], FlavorFPM)
+		}
+		if requirement == "" {
+			requirement = m[1] + "." + m[2]
+		}
+	}
+	return requirement
+}
+
+// like php:8.2, php:8.3-cli-alpine, or docker.io/library/php:8.1.27-fpm
+var phpImageRegexp = regexp.MustCompile(`(?:^|/)php:(\d+\.\d+(?:\.\d+)?)(?:-|$)`)
+
+// like 8.2 or 8.3.4, but not latest or ${{ matrix.php }}
+var phpVersionInputRegexp = regexp.MustCompile(`^\d+\.\d+(?:\.\d+)?$`)
+
+// gitLabCIRequirement returns the version of the php Docker image of a
+// .gitlab-ci.yml file: the default one first, then the one of the jobs
+func gitLabCIRequirement(contents []byte) string {
+	var gitlab map[string]interface{}
+	if err := yaml.Unmarshal(contents, &gitlab); err != nil {
+		return ""
+	}
+	// the image is either a string or a map with a name
+	image := func(image interface{}) string {
+		name, ok := image.(string)
+		if !ok {
+			if i, ok := image.(map[interface{}]interface{}); ok {
+				name, _ = i["name"].(string)
+			}
+		}
+		if m := phpImageRegexp.FindStringSubmatch(name); m != nil {
+			return m[1]
+		}
+		return ""
+	}
+	jobImage := func(job interface{}) string {
+		if j, ok := job.(map[interface{}]interface{}); ok {
+			return image(j["image"])
+		}
+		return ""
+	}
+	if v := image(gitlab["image"]); v != "" {
+		return v
+	}
+	if v := jobImage(gitlab["default"]); v != "" {
+		return v
+	}
+	names := make([]string, 0, len(gitlab))
+	for name := range gitlab {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if v := jobImage(gitlab[name]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// setupPHPRequirement returns the php-version input of the first
+// shivammathur/setup-php step of a GitHub Actions workflow
+func setupPHPRequirement(contents []byte) string {
+	var workflow struct {
+		Jobs map[string]struct {
+			Steps []struct {
+				Uses string            `yaml:"uses"`
+				With map[string]string `yaml:"with"`
+			} `yaml:"steps"`
+		} `yaml:"jobs"`
+	}
+	if contents == nil || yaml.Unmarshal(contents, &workflow) != nil {
+		return ""
+	}
+	names := make([]string, 0, len(workflow.Jobs))
+	for name := range workflow.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, step := range workflow.Jobs[name].Steps {
+			if !strings.HasPrefix(step.Uses, "shivammathur/setup-php@") {
+				continue
+			}
+			if version := step.With["php-version"]; phpVersionInputRegexp.MatchString(version) {
+				return version
+			}
+		}
+	}
+	return ""
+}
+
+// sourceResolver returns the version requirement found in dir (or an empty
+// string), and a description of where it was found
+type sourceResolver func(s *PHPStore, dir string) (string, string)
+
+var sourceResolvers = map[string]sourceResolver{
+	// the requirement is the path of the binary, not a version (see
+	// bestVersionForEnvironment)
+	SourceDirenv: func(s *PHPStore, dir string) (string, string) {
+		return environmentPHP(dir)
+	},
+	SourcePHPVersion: func(s *PHPStore, dir string) (string, string) {
+		if version, foundDir := s.versionForDir(dir, ".php-version"); version != nil {
+			return string(version), fmt.Sprintf(".php-version from current dir: %s", filepath.Join(foundDir, ".php-version"))
+		}
+		return "", ""
+	},
+	SourceSymfonyLocal: func(s *PHPStore, dir string) (string, string) {
+		if contents, foundDir := s.versionForDir(dir, ".symfony.local.yaml"); contents != nil {
+			var local struct {
+				PHP struct {
+					Version string `yaml:"version"`
+					Flavor  string `yaml:"flavor"`
+				} `yaml:"php"`
+			}
+			if err := yaml.Unmarshal(contents, &local); err == nil && local.PHP.Version != "" {
+				return withFlavor(local.PHP.Version, local.PHP.Flavor), fmt.Sprintf(".symfony.local.yaml from current dir: %s", filepath.Join(foundDir, ".symfony.local.yaml"))
+			}
+		}
+		return "", ""
+	},
+	SourceComposerPlatform: func(s *PHPStore, dir string) (string, string) {
+		// the nearest platform wins
+		for _, manifest := range s.composerManifestsForDir(dir) {
+			if manifest.json.Config.Platform.PHP != "" {
+				return manifest.json.Config.Platform.PHP, fmt.Sprintf("composer.json from current dir: %s", manifest.file)
+			}
+		}
+		return "", ""
+	},
+	SourceWorkingDirPHPVersion: func(s *PHPStore, dir string) (string, string) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", ""
+		}
+		if version, foundDir := s.versionForDir(wd, ".php-version"); version != nil {
+			return string(version), fmt.Sprintf(".php-version from working dir: %s", filepath.Join(foundDir, ".php-version"))
+		}
+		return "", ""
+	},
+	SourceSymfonyCloud: func(s *PHPStore, dir string) (string, string) {
+		if version, foundDir := s.versionForDir(dir, ".symfony.cloud.yaml"); version != nil {
+			var symfonycloud struct {
+				Type string `yaml:"type"`
+			}
+			if err := yaml.Unmarshal(version, &symfonycloud); err == nil {
+				if strings.HasPrefix(symfonycloud.Type, "php:") {
+					return symfonycloud.Type[4:], fmt.Sprintf("SymfonyCloud: %s", filepath.Join(foundDir, ".symfony.cloud.yaml"))
+				}
+			}
+		}
+		return "", ""
+	},
+	SourcePlatformSH: func(s *PHPStore, dir string) (string, string) {
+		if version, foundDir := s.versionForDir(dir, ".platform.app.yaml"); version != nil {
+			var platform struct {
+				Type string `yaml:"type"`
+			}
+			if err := yaml.Unmarshal(version, &platform); err == nil {
+				if strings.HasPrefix(platform.Type, "php:") {
+					return platform.Type[4:], fmt.Sprintf("Platform.sh: %s", filepath.Join(foundDir, ".platform.app.yaml"))
+				}
+			}
+		}
+		return "", ""
+	},
+	SourceWPEnv: func(s *PHPStore, dir string) (string, string) {
+		_, foundDir := s.versionForDir(dir, ".wp-env.json")
+		if foundDir == "" {
+			return "", ""
+		}
+		// the override file takes precedence over the shared one
+		for _, name := range []string{".wp-env.override.json", ".wp-env.json"} {
+			// a null phpVersion means the default version of the Docker image
+			var wpenv struct {
+				PHPVersion *string `json:"phpVersion"`
+			}
+			file := filepath.Join(foundDir, name)
+			if err := json.Unmarshal(s.readVersion(file), &wpenv); err == nil && wpenv.PHPVersion != nil && *wpenv.PHPVersion != "" {
+				return *wpenv.PHPVersion, fmt.Sprintf("wp-env: %s", file)
+			}
+		}
+		return "", ""
+	},
+	SourceAppEngine: func(s *PHPStore, dir string) (string, string) {
+		if contents, foundDir := s.versionForDir(dir, "app.yaml"); contents != nil {
+			var appengine struct {
+				Runtime string `yaml:"runtime"`
+			}
+			// like php82 (the flexible environment uses a generic php runtime)
+			if err := yaml.Unmarshal(contents, &appengine); err == nil {
+				if m := appEngineRuntimeRegexp.FindStringSubmatch(appengine.Runtime); m != nil {
+					return m[1] + "." + m[2], fmt.Sprintf("App Engine: %s", filepath.Join(foundDir, "app.yaml"))
+				}
+			}
+		}
+		return "", ""
+	},
+	SourceBref: func(s *PHPStore, dir string) (string, string) {
+		if contents, foundDir := s.versionForDir(dir, "serverless.yml"); contents != nil {
+			if requirement := brefRequirement(contents); requirement != "" {
+				return requirement, fmt.Sprintf("Bref: %s", filepath.Join(foundDir, "serverless.yml"))
+			}
+		}
+		return "", ""
+	},
+	// most projects do not define config.platform.php but all define require.php
+	SourceComposerRequire: func(s *PHPStore, dir string) (string, string) {
+		// all constraints of the workspace apply
+		requirement := ""
+		var files []string
+		for _, manifest := range s.composerManifestsForDir(dir) {
+			if php := manifest.json.Require["php"]; php != "" {
+				requirement = mergeConstraints(requirement, php)
+				files = append(files, manifest.file)
+			}
+		}
+		if requirement != "" {
+			return requirement, fmt.Sprintf("composer.json require from current dir: %s", strings.Join(files, ", "))
+		}
+		return "", ""
+	},
+	SourceGitLabCI: func(s *PHPStore, dir string) (string, string) {
+		if contents, foundDir := s.versionForDir(dir, ".gitlab-ci.yml"); contents != nil {
+			if requirement := gitLabCIRequirement(contents); requirement != "" {
+				return requirement, fmt.Sprintf("GitLab CI: %s", filepath.Join(foundDir, ".gitlab-ci.yml"))
+			}
+		}
+		return "", ""
+	},
+	SourceGitHubActions: func(s *PHPStore, dir string) (string, string) {
+		for {
+			workflows, _ := filepath.Glob(filepath.Join(dir, ".github", "workflows", "*.y*ml"))
+			// sorted by Glob
+			for _, workflow := range workflows {
+				if requirement := setupPHPRequirement(s.readVersion(workflow)); requirement != "" {
+					return requirement, fmt.Sprintf("GitHub Actions: %s", workflow)
+				}
+			}
+			upDir := filepath.Dir(dir)
+			if upDir == dir || upDir == "." {
+				return "", ""
+			}
+			dir = upDir
+		}
+	},
+}
+
+type composerJSON struct {
+	Config struct {
+		Platform struct {
+			PHP string `json:"php"`
+		} `json:"platform"`
+	} `json:"config"`
+	Require map[string]string `json:"require"`
+}
+
+// composerFile returns the name of the Composer manifest: composer.json,
+// unless the COMPOSER environment variable sets another one (like
+// composer-dev.json or an absolute path)
+func composerFile() string {
+	if name := os.Getenv("COMPOSER"); name != "" {
+		return name
+	}
+	return "composer.json"
+}
+
+// composerFileForDir returns the contents and the path of the Composer
+// manifest of the given directory and up (see composerFile)
+func (s *PHPStore) composerFileForDir(dir string) ([]byte, string) {
+	name := composerFile()
+	contents, foundDir := s.versionForDir(dir, name)
+	if contents == nil {
+		return nil, ""
+	}
+	if filepath.IsAbs(name) {
+		return contents, name
+	}
+	return contents, filepath.Join(foundDir, name)
+}
+
+// composerJSONForDir returns the parsed Composer manifest of the given
+// directory and up, and its path
+func (s *PHPStore) composerJSONForDir(dir string) (*composerJSON, string) {
+	contents, file := s.composerFileForDir(dir)
+	if contents == nil {
+		return nil, ""
+	}
+	var composerJson composerJSON
+	if err := json.Unmarshal(contents, &composerJson); err != nil {
+		return nil, ""
+	}
+	return &composerJson, file
+}
+
+// validateSources returns the known sources, logging the unknown ones
+func (s *PHPStore) validateSources(sources []string) []string {
+	valid := []string{}
+	for _, source := range sources {
+		if _, ok := sourceResolvers[source]; !ok {
+			s.log("Ignoring unknown PHP version source %q", source)
+			continue
+		}
+		valid = append(valid, source)
+	}
+	return valid
+}
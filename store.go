@@ -21,51 +21,156 @@ package phpstore
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-version"
 	"github.com/pkg/errors"
-	yaml "gopkg.in/yaml.v2"
 )
 
 // PHPStore stores information about all locally installed PHP versions
 type PHPStore struct {
-	configDir        string
-	versions         versions
-	pathVersion      *Version
-	seen             map[string]int
-	discoveryLogFunc func(msg string, a ...interface{})
+	configDir            string
+	versions             versions
+	pathVersion          *Version
+	seen                 map[string]int
+	discoveryLogFunc     func(msg string, a ...interface{})
+	structuredLogger     StructuredLogger
+	logMu                sync.Mutex
+	sources              []string
+	strict               bool
+	preferNative         bool
+	probes               []*probe
+	probeSources         []string
+	discoveryConcurrency int
+	discoveryEvents      DiscoveryEvents
+	eventsMu             sync.Mutex
+	report               *DiscoveryReport
+	lazy                 bool
+	loadOnce             sync.Once
+	cacheTTL             time.Duration
+	refreshWg            sync.WaitGroup
+	onlySource           string
+	// registeredPaths are the PHP installations registered with RegisterPath
+	registeredPaths []string
+	// ignoredPaths and ignoredVersions are hidden from discovery (see
+	// IgnorePath and IgnoreVersion)
+	ignoredPaths    []string
+	ignoredVersions []string
+	// excludedPaths are glob patterns of directories skipped by discovery
+	// (see WithExcludedPaths), and scanNetworkFilesystems disables the
+	// skipping of network filesystems (see WithNetworkFilesystems)
+	excludedPaths          []string
+	scanNetworkFilesystems bool
+	// workspaceMarkers enables workspace resolution (see
+	// WithWorkspaceResolution)
+	workspaceMarkers []string
+	// defaultVersion is the requirement set with SetDefaultVersion
+	defaultVersion string
+	// phpbrewCurrentDir is the phpbrew build selected in the shell, read
+	// once (see phpbrewCurrent)
+	phpbrewCurrentDir string
+	phpbrewOnce       sync.Once
+	// aliases are the aliases defined with SetAlias, by name
+	aliases map[string]string
+	// releasesURL enables the outdated patch check (see
+	// WithOutdatedPatchCheck), and releases are the latest releases by minor
+	// version, loaded once
+	releasesURL  string
+	releasesOnce sync.Once
+	releases     map[string]string
+	// problems are the non-fatal problems of the last discovery
+	problems Problems
+	// remoteVersions are the versions discovered with DiscoverRemote, by host
+	remoteVersions map[string][]*Version
+	// roots are the directories scanned by the last discovery (see Watch)
+	roots []string
+	// dryRun is true when discovery only lists the directories to scan,
+	// without running version managers (like brew or asdf)
+	dryRun bool
+	// previousVersions are the versions of the cache before a discovery, and
+	// changes the differences with the discovered ones (see Changes)
+	previousVersions versions
+	changes          *VersionsDiff
+	// cacheFile is the location of the cache (see WithCachePath)
+	cacheFile string
+	// noCacheWrite and memoryCache are the cache modes (see WithNoCacheWrite
+	// and WithMemoryCache); ignoreCache is true when the cache must not be
+	// used but cannot be removed
+	noCacheWrite bool
+	memoryCache  bool
+	ignoreCache  bool
+	// fs memoizes filesystem lookups during discovery
+	fs *fsCache
+	// resolutions caches the versions found for directories (see
+	// BestVersionForDirWithWarnings)
+	resolutions resolutions
+	// environment caches the versions of the binaries found by SourceDirenv
+	environment environmentVersions
+	// unprobed are the binaries whose metadata could not be probed (see
+	// probeMetadata), so that they are not run again
+	unprobed map[string]bool
+	// mu protects versions from concurrent updates (see Watch)
+	mu sync.RWMutex
 }
 
 // New creates a new PHP store
-func New(configDir string, reload bool, logger func(msg string, a ...interface{})) *PHPStore {
+func New(configDir string, reload bool, logger func(msg string, a ...interface{}), opts ...Option) *PHPStore {
 	s := &PHPStore{
-		configDir:        configDir,
-		seen:             make(map[string]int),
-		discoveryLogFunc: logger,
+		configDir:            configDir,
+		seen:                 make(map[string]int),
+		discoveryLogFunc:     logger,
+		sources:              DefaultSources,
+		discoveryConcurrency: runtime.NumCPU(),
+		report:               &DiscoveryReport{},
 	}
+	s.cacheFile = s.defaultCachePath()
+	s.loadConfig()
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.moveLegacyCache()
 	if reload {
-		os.Remove(filepath.Join(configDir, "php_versions.json"))
+		s.previousVersions = s.cachedVersions()
+		s.removeCache()
+	}
+	if !s.lazy {
+		s.load()
 	}
-	s.loadVersions()
 	return s
 }
 
+// load loads or discovers the versions the first time it is called
+func (s *PHPStore) load() {
+	s.loadOnce.Do(s.loadVersions)
+}
+
 // Versions returns all available PHP versions
 func (s *PHPStore) Versions() []*Version {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.versions
 }
 
 func (s *PHPStore) IsVersionAvailable(version string) bool {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	version, aliasPath := s.resolveAlias(version)
+	if aliasPath != "" {
+		return s.versionAtPath(aliasPath) != nil
+	}
 	// start from the end as versions are always sorted
 	for i := len(s.versions) - 1; i >= 0; i-- {
 		v := s.versions[i]
-		if v.Version == version || strings.HasPrefix(v.Version, version) {
+		if hasVersionPrefix(v.Version, version) {
 			return true
 		}
 	}
@@ -73,67 +178,93 @@ func (s *PHPStore) IsVersionAvailable(version string) bool {
 }
 
 // BestVersionForDir returns the configured PHP version for the given PHP script
-func (s *PHPStore) BestVersionForDir(dir string) (*Version, string, string, error) {
-	// forced version?
-	if os.Getenv("FORCED_PHP_VERSION") != "" {
-		minorPHPVersion := strings.Join(strings.Split(os.Getenv("FORCED_PHP_VERSION"), ".")[0:2], ".")
-		if _, err := version.NewVersion(minorPHPVersion); err == nil {
-			return s.bestVersion(minorPHPVersion, "internal forced version")
-		}
-	}
+// Versions providing the extensions required by composer.json (ext-*) are preferred
+// A flavor can be required with WithFlavor.
+func (s *PHPStore) BestVersionForDir(dir string, opts ...LookupOption) (*Version, string, string, error) {
+	v, source, warnings, err := s.BestVersionForDirWithWarnings(dir, opts...)
+	return v, source, warnings.String(), err
+}
 
-	// .php-version for the currently executed PHP script and up
-	if version, foundDir := s.versionForDir(dir, ".php-version"); version != nil {
-		return s.bestVersion(string(version), fmt.Sprintf(".php-version from current dir: %s", filepath.Join(foundDir, ".php-version")))
+// BestVersionForDirWithWarnings is like BestVersionForDir, but returns
+// structured warnings instead of a formatted message.
+// Results are cached by directory until one of the files involved in the
+// resolution (like .php-version or composer.json) is created, modified, or
+// removed, or until the versions or the configuration change.
+func (s *PHPStore) BestVersionForDirWithWarnings(dir string, opts ...LookupOption) (*Version, string, Warnings, error) {
+	l := newLookup(opts)
+	s.load()
+	// downloaded and probed before locking, as it can take a while
+	releases := s.latestReleases()
+	s.probeEnvironmentPHP(dir)
+	s.probeMetadataForDir(dir)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key := newResolutionKey(dir, l.flavor)
+	if r := s.resolutions.get(key); r != nil {
+		return r.version, r.source, r.warnings, r.err
 	}
+	// stamped before resolving, so that concurrent changes invalidate the entry
+	stamps := s.resolutionStamps(key)
+	v, source, warnings, err := s.bestVersionForDirWithWarnings(dir, l.flavor, s.requiredExtensionsForDir(dir), releases)
+	s.resolutions.set(key, &resolution{version: v, source: source, warnings: warnings, err: err, stamps: stamps})
+	return v, source, warnings, err
+}
 
-	// composer.json for the currently executed PHP script and up
-	if version, foundDir := s.versionForDir(dir, "composer.json"); version != nil {
-		var composerJson struct {
-			Config struct {
-				Platform struct {
-					PHP string `json:"php"`
-				} `json:"platform"`
-			} `json:"config"`
+func (s *PHPStore) bestVersionForDirWithWarnings(dir, flavor string, extensions []string, releases map[string]string) (*Version, string, Warnings, error) {
+	v, source, warning, err := s.bestVersionForDir(dir, flavor, extensions)
+	var warnings Warnings
+	if warning != nil {
+		warnings = append(warnings, warning)
+	}
+	if v != nil {
+		if missing := v.missingExtensions(extensions); len(missing) > 0 {
+			warnings = append(warnings, &Warning{
+				Kind:       WarningMissingExtensions,
+				Matched:    v.Version,
+				Source:     source,
+				Extensions: missing,
+			})
 		}
-		if err := json.Unmarshal(version, &composerJson); err == nil && composerJson.Config.Platform.PHP != "" {
-			return s.bestVersion(composerJson.Config.Platform.PHP, fmt.Sprintf("composer.json from current dir: %s", filepath.Join(foundDir, "composer.json")))
+		if warning := outdatedPatchWarning(v, source, releases); warning != nil {
+			warnings = append(warnings, warning)
 		}
 	}
+	return v, source, warnings, err
+}
 
-	// .php-version for the current working directory and up
-	wd, err := os.Getwd()
-	if err == nil {
-		if version, foundDir := s.versionForDir(wd, ".php-version"); version != nil {
-			return s.bestVersion(string(version), fmt.Sprintf(".php-version from working dir: %s", filepath.Join(foundDir, ".php-version")))
+func (s *PHPStore) bestVersionForDir(dir, flavor string, extensions []string) (*Version, string, *Warning, error) {
+	// forced version?
+	if os.Getenv("FORCED_PHP_VERSION") != "" {
+		minorPHPVersion := strings.Join(strings.Split(os.Getenv("FORCED_PHP_VERSION"), ".")[0:2], ".")
+		if _, err := version.NewVersion(minorPHPVersion); err == nil {
+			return s.bestVersion(overrideFlavor(minorPHPVersion, flavor), "internal forced version", extensions...)
 		}
 	}
 
-	// .symfony.cloud.yaml for the directory of the script and up
-	if version, foundDir := s.versionForDir(dir, ".symfony.cloud.yaml"); version != nil {
-		var symfonycloud struct {
-			Type string `yaml:"type"`
-		}
-		if err := yaml.Unmarshal(version, &symfonycloud); err == nil {
-			if strings.HasPrefix(symfonycloud.Type, "php:") {
-				return s.bestVersion(symfonycloud.Type[4:], fmt.Sprintf("SymfonyCloud: %s", filepath.Join(foundDir, ".symfony.cloud.yaml")))
+	// sources by order of precedence (see DefaultSources)
+	for _, name := range s.sources {
+		if name == SourceDirenv {
+			if php, source := environmentPHP(dir); php != "" {
+				return s.bestVersionForEnvironment(php, flavor, source)
 			}
+			continue
 		}
-	}
-
-	// .platform.app.yaml for the directory of the script and up
-	if version, foundDir := s.versionForDir(dir, ".platform.app.yaml"); version != nil {
-		var platform struct {
-			Type string `yaml:"type"`
-		}
-		if err := yaml.Unmarshal(version, &platform); err == nil {
-			if strings.HasPrefix(platform.Type, "php:") {
-				return s.bestVersion(platform.Type[4:], fmt.Sprintf("Platform.sh: %s", filepath.Join(foundDir, ".platform.app.yaml")))
-			}
+		if requirement, source := sourceResolvers[name](s, dir); requirement != "" {
+			return s.bestVersion(overrideFlavor(requirement, flavor), source, extensions...)
 		}
 	}
 
-	return s.fallbackVersion("")
+	return s.fallbackVersion(nil, flavor)
+}
+
+// overrideFlavor replaces the flavor of the requirement with the one
+// required by the caller (see WithFlavor), if any
+func overrideFlavor(requirement, flavor string) string {
+	if flavor == "" {
+		return requirement
+	}
+	requirement, _ = splitFlavor(requirement)
+	return withFlavor(requirement, flavor)
 }
 
 // bestVersion returns the latest patch version for the given major (X), minor (X.Y), or patch (X.Y.Z)
@@ -143,67 +274,187 @@ func (s *PHPStore) BestVersionForDir(dir string) (*Version, string, string, erro
 // will fallback to the last path version for the minor version (X.Y).
 // There's no fallback to the major version because PHP is known to occasionally
 // break BC in minor versions, so we can't safely fall back.
-func (s *PHPStore) bestVersion(versionPrefix, source string) (*Version, string, string, error) {
-	warning := ""
-
-	isPatchVersion := false
-	pos := strings.LastIndexByte(versionPrefix, '.')
-	if pos != strings.IndexByte(versionPrefix, '.') {
-		if "99" == versionPrefix[pos+1:] {
-			versionPrefix = versionPrefix[:pos]
-			pos = strings.LastIndexByte(versionPrefix, '.')
-		} else {
-			isPatchVersion = true
-		}
+// Constraint expressions (like ^8.2 or >=8.1 <8.4) and aliases (see SetAlias)
+// are also supported.
+// A flavor suffix (like 8.3-fpm or ^8.2-cgi) restricts candidates to versions
+// supporting that flavor (see Flavor* constants).
+// Versions providing all the given extensions are preferred.
+func (s *PHPStore) bestVersion(versionPrefix, source string, extensions ...string) (*Version, string, *Warning, error) {
+	versionPrefix, aliasPath := s.resolveAlias(versionPrefix)
+	if aliasPath != "" {
+		return s.bestVersionForAliasPath(versionPrefix, aliasPath, source)
 	}
+	m := newRequirementMatcher(versionPrefix)
+	if m.isConstraint() {
+		return s.bestVersionForConstraint(m, source, extensions...)
+	}
+	candidates := s.candidates(extensions)
+
+	var warning *Warning
 
-	// Check if versionPrefix is actually a patch version, if so first do an
+	// Check if the requirement is actually a patch version, if so first do an
 	// exact match lookup and fallback to a minor version check
-	if isPatchVersion {
+	if m.patch != "" {
 		// look for an exact match, the order does not matter here
-		for _, v := range s.versions {
-			if v.Version == versionPrefix {
-				return v, source, "", nil
+		for _, v := range candidates {
+			if _, exact := m.match(v); exact && v.SupportsFlavor(m.flavor) {
+				return v, source, nil, nil
 			}
 		}
 
 		// exact match not found, fallback to minor version check
-		newVersionPrefix := versionPrefix[:pos]
-		warning = fmt.Sprintf(`the current dir requires PHP %s (%s), but this version is not available: fallback to %s`, versionPrefix, source, newVersionPrefix)
-		versionPrefix = newVersionPrefix
+		if s.strict {
+			return s.unsatisfiedVersion(&Warning{Kind: WarningVersionNotAvailable, Requested: withFlavor(m.patch, m.flavor), Source: source})
+		}
+		warning = &Warning{Kind: WarningPatchFallback, Requested: withFlavor(m.patch, m.flavor), Source: source}
 	}
 
 	// start from the end as versions are always sorted
-	for i := len(s.versions) - 1; i >= 0; i-- {
-		v := s.versions[i]
-		if strings.HasPrefix(v.Version, versionPrefix) {
-			return v, source, warning, nil
+	withoutFlavor := false
+	for i := len(candidates) - 1; i >= 0; i-- {
+		v := candidates[i]
+		if ok, _ := m.match(v); ok {
+			if v.SupportsFlavor(m.flavor) {
+				if warning != nil {
+					warning.Matched = v.Version
+				}
+				return v, source, warning, nil
+			}
+			withoutFlavor = true
 		}
 	}
 
-	return s.fallbackVersion(fmt.Sprintf(`the current dir requires PHP %s (%s), but this version is not available`, versionPrefix, source))
+	if withoutFlavor {
+		return s.unsatisfiedVersion(&Warning{Kind: WarningFlavorNotAvailable, Requested: withFlavor(m.prefix, m.flavor), Source: source})
+	}
+	return s.unsatisfiedVersion(&Warning{Kind: WarningVersionNotAvailable, Requested: withFlavor(m.prefix, m.flavor), Source: source})
 }
 
-func (s *PHPStore) fallbackVersion(warning string) (*Version, string, string, error) {
-	if s.pathVersion != nil {
-		return s.pathVersion, "default version in $PATH", warning, nil
+// candidates returns the versions to consider when looking for the best one,
+// versions providing all the given extensions (and then native versions, see
+// WithNativePreference) being moved to the end (as the lookup starts from the
+// end)
+func (s *PHPStore) candidates(extensions []string) versions {
+	if len(extensions) == 0 && !s.preferNative {
+		return s.versions
 	}
-	if len(s.versions) == 0 {
-		return nil, "", warning, errors.New("no PHP binaries detected")
+	candidates := make(versions, len(s.versions))
+	copy(candidates, s.versions)
+	rank := func(v *Version) int {
+		r := 0
+		if len(v.missingExtensions(extensions)) == 0 {
+			r += 2
+		}
+		if s.preferNative && !v.Emulated {
+			r++
+		}
+		return r
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return rank(candidates[i]) < rank(candidates[j])
+	})
+	return candidates
+}
+
+// UnsatisfiedVersionError is returned in strict mode (see WithStrict) when
+// the version required by a project is not available
+type UnsatisfiedVersionError struct {
+	Requirement string
+	Source      string
+	Reason      string
+	// Suggestion is how to install a matching version, if any
+	Suggestion *Installable
+}
+
+func (e *UnsatisfiedVersionError) Error() string {
+	return e.Reason
+}
+
+// unsatisfiedVersion falls back to the default version, or returns an error in strict mode
+func (s *PHPStore) unsatisfiedVersion(warning *Warning) (*Version, string, *Warning, error) {
+	if warning.Kind == WarningVersionNotAvailable || warning.Kind == WarningConstraintNotSatisfied {
+		// releases are already loaded by BestVersionForDirWithWarnings
+		if installables := s.installable(warning.Requested, s.releases); len(installables) > 0 {
+			warning.Suggestion = installables[0]
+		}
 	}
-	return s.versions[len(s.versions)-1], "most recent PHP version", warning, nil
+	if s.strict {
+		return nil, warning.Source, nil, &UnsatisfiedVersionError{
+			Requirement: warning.Requested,
+			Source:      warning.Source,
+			Reason:      warning.String(),
+			Suggestion:  warning.Suggestion,
+		}
+	}
+	// the fallback version must support the required flavor as well
+	_, flavor := splitFlavor(warning.Requested)
+	return s.fallbackVersion(warning, flavor)
+}
+
+func (s *PHPStore) fallbackVersion(warning *Warning, flavor string) (*Version, string, *Warning, error) {
+	var v *Version
+	source := ""
+	if s.defaultVersion != "" {
+		if v = s.matchInstalledVersion(s.defaultVersion); v != nil && v.SupportsFlavor(flavor) {
+			source = "global default version"
+		} else if v != nil {
+			v = nil
+		} else {
+			s.log("Ignoring the default version %q as it is not installed", s.defaultVersion)
+		}
+	}
+	if v == nil {
+		if current := s.phpbrewCurrent(); current != "" {
+			if v = s.versionAtPath(current); v != nil && v.SupportsFlavor(flavor) {
+				source = "phpbrew current version"
+			} else {
+				v = nil
+			}
+		}
+	}
+	if v == nil {
+		if s.pathVersion != nil && s.pathVersion.SupportsFlavor(flavor) {
+			v, source = s.pathVersion, "default version in $PATH"
+		} else {
+			// FPM-only installations cannot be used from the command line
+			for i := len(s.versions) - 1; i >= 0; i-- {
+				if s.versions[i].SupportsFlavor(flavor) {
+					v, source = s.versions[i], "most recent PHP version"
+					break
+				}
+			}
+			if v == nil && flavor != "" {
+				return nil, "", warning, errors.Errorf(`no PHP versions supporting the "%s" flavor detected`, flavor)
+			}
+			if v == nil {
+				return nil, "", warning, errors.New("no PHP binaries detected")
+			}
+		}
+	}
+	if warning != nil {
+		warning.Matched = v.Version
+	}
+	return v, source, warning, nil
 }
 
 // loadVersions returns all available PHP versions on this machine
 func (s *PHPStore) loadVersions() {
+	s.loadShared(s.loadOrDiscoverVersions)
+}
+
+// loadOrDiscoverVersions loads the versions from the cache, or discovers them
+func (s *PHPStore) loadOrDiscoverVersions() {
 	// disk cache?
-	cache := filepath.Join(s.configDir, "php_versions.json")
-	if _, err := os.Stat(cache); err == nil {
-		if contents, err := os.ReadFile(cache); err == nil {
-			var vs versions
-			if err := json.Unmarshal(contents, &vs); err == nil {
+	if modTime, ok := s.cacheModTime(); ok && !s.ignoreCache {
+		if contents, err := s.readCache(); err == nil {
+			if vs, migrated, err := s.decodeCache(contents); err == nil {
+				s.previousVersions = copyVersions(vs)
+				vs, changed := s.revalidateVersions(vs, false)
+				// the configuration might have changed since the cache was written
+				vs, ignored := s.withoutIgnoredVersions(vs)
+				changed = changed || ignored
 				for _, v := range vs {
-					v.FullVersion, err = version.NewVersion(v.Version)
+					v.FullVersion, err = parsePHPVersion(v.Version)
 					if err != nil {
 						// someone messed up with the cache
 						continue
@@ -214,21 +465,37 @@ func (s *PHPStore) loadVersions() {
 					s.versions = append(s.versions, v)
 				}
 				sort.Sort(s.versions)
+				s.reindex()
+				s.report.FromCache = true
+				if s.restoreRegisteredVersions() {
+					changed = true
+				}
+				s.changes = DiffVersions(s.previousVersions, s.versions)
+				if changed || migrated {
+					s.writeCache()
+				}
+				if s.cacheTTL > 0 && time.Since(modTime) > s.cacheTTL {
+					s.refreshInBackground()
+				}
 				return
+			} else {
+				s.log("Unable to use the cache: %s", err)
 			}
 		}
 	}
 	s.discover()
 	sort.Sort(s.versions)
-	if contents, err := json.MarshalIndent(s.versions, "", "    "); err == nil {
-		_ = os.WriteFile(cache, contents, 0644)
-	}
+	s.reindex()
+	s.changes = DiffVersions(s.previousVersions, s.versions)
+	s.writeCache()
 }
 
 // addVersion ensures that all versions are unique in the store
 func (s *PHPStore) addVersion(version *Version) int {
-	idx, ok := s.seen[version.PHPPath]
-	sl, _ := filepath.EvalSymlinks(version.PHPPath)
+	s.resolutions.reset()
+	version.annotateSupport(time.Now())
+	idx, ok := s.seen[version.binary()]
+	sl, _ := s.fs.evalSymlinks(version.binary())
 	// double-check to see if that's not just a symlink to another existing version
 	if !ok && sl != "" {
 		idx, ok = s.seen[sl]
@@ -236,33 +503,77 @@ func (s *PHPStore) addVersion(version *Version) int {
 
 	if !ok {
 		s.versions = append(s.versions, version)
-		s.seen[version.PHPPath] = len(s.versions) - 1
+		s.seen[version.binary()] = len(s.versions) - 1
 		if sl != "" {
 			s.seen[sl] = len(s.versions) - 1
 		}
 		return len(s.versions) - 1
 	}
-	currentScore := 0
-	if s.versions[idx].FPMPath != "" {
-		currentScore = 2
-	} else if s.versions[idx].CGIPath != "" {
-		currentScore = 1
-	}
-	newScore := 0
-	if version.FPMPath != "" {
-		newScore = 2
-	} else if version.CGIPath != "" {
-		newScore = 1
-	}
-	if newScore > currentScore {
+	if current := s.versions[idx]; version.betterDuplicate(current) {
+		if !current.DiscoveredAt.IsZero() && current.DiscoveredAt.Before(version.DiscoveredAt) {
+			version.DiscoveredAt = current.DiscoveredAt
+		}
 		s.versions[idx] = version
 	}
 	return idx
 }
 
+// betterDuplicate returns true if v has more capabilities than other, to keep
+// the best version when the same binary is found several times; the criteria
+// of dedupCriteria are compared in order, a criterion only breaking the ties
+// of the previous ones
+func (v *Version) betterDuplicate(other *Version) bool {
+	a, b := v.dedupCriteria(), other.dedupCriteria()
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] > b[i]
+		}
+	}
+	return false
+}
+
+// dedupCriteria returns the capabilities of a version compared by
+// betterDuplicate, by order of importance
+func (v *Version) dedupCriteria() []int {
+	metadata := 0
+	for _, known := range []bool{len(v.Extensions) > 0, v.IniPath != "", v.ExtensionDir != "", v.Arch != ""} {
+		if known {
+			metadata++
+		}
+	}
+	return []int{
+		// FPM is what most web servers use
+		boolToInt(v.FPMPath != ""),
+		// CGI is the fallback of web servers without FPM
+		boolToInt(v.CGIPath != ""),
+		// FrankenPHP is a web server on its own
+		boolToInt(v.FrankenPHP),
+		// LSAPI is only used by LiteSpeed
+		boolToInt(v.LSAPIPath != ""),
+		// development tools are needed to build extensions
+		boolToInt(v.PHPConfigPath != "") + boolToInt(v.PHPizePath != ""),
+		// richer metadata gives better resolutions (like for extensions)
+		metadata,
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 // versionForDir returns the PHP version to use for a given directory
 // it tries to go up all directories until it finds a version file
 func (s *PHPStore) versionForDir(dir, filename string) ([]byte, string) {
+	// like COMPOSER=/path/to/composer.json
+	if filepath.IsAbs(filename) {
+		if version := s.readVersion(filename); version != nil {
+			return version, filepath.Dir(filename)
+		}
+		return nil, ""
+	}
 	for {
 		if version := s.readVersion(filepath.Join(dir, filename)); version != nil {
 			return version, dir
@@ -276,6 +587,24 @@ func (s *PHPStore) versionForDir(dir, filename string) ([]byte, string) {
 	return nil, ""
 }
 
+// requiredExtensionsForDir returns the PHP extensions required by the
+// composer.json file of the given directory and up (ext-* requirements), or
+// by all the composer.json files of the workspace (see WithWorkspaceResolution)
+func (s *PHPStore) requiredExtensionsForDir(dir string) []string {
+	var extensions []string
+	seen := make(map[string]bool)
+	for _, manifest := range s.composerManifestsForDir(dir) {
+		for name := range manifest.json.Require {
+			if strings.HasPrefix(name, "ext-") && !seen[name] {
+				seen[name] = true
+				extensions = append(extensions, name[len("ext-"):])
+			}
+		}
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
 // readVersion reads the content of a version file (see versionForDir)
 func (s *PHPStore) readVersion(file string) []byte {
 	if _, err := os.Stat(file); err != nil {
@@ -288,8 +617,26 @@ func (s *PHPStore) readVersion(file string) []byte {
 	return bytes.TrimSpace(contents)
 }
 
+// StructuredLogger receives discovery logs along with structured fields
+// (like source, path or verdict); it is implemented by *slog.Logger
+type StructuredLogger interface {
+	Debug(msg string, args ...interface{})
+}
+
 func (s *PHPStore) log(msg string, a ...interface{}) {
+	s.logWith(nil, msg, a...)
+}
+
+// logWith logs a message along with structured fields (key/value pairs), which
+// are only used by the structured logger
+func (s *PHPStore) logWith(fields []interface{}, msg string, a ...interface{}) {
+	// PHP binaries are probed concurrently
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
 	if s.discoveryLogFunc != nil {
 		s.discoveryLogFunc(msg, a...)
 	}
+	if s.structuredLogger != nil {
+		s.structuredLogger.Debug(strings.TrimSpace(fmt.Sprintf(msg, a...)), fields...)
+	}
 }
@@ -1,8 +1,25 @@
 package phpstore
 
 import (
+	"bytes"
+	"context"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
 )
 
 func TestBestVersion(t *testing.T) {
@@ -42,8 +59,12 @@ func TestBestVersion(t *testing.T) {
 			t.Error("8.0.10 requirement should find a best version")
 		} else if bestVersion.Version != "8.0.27" {
 			t.Error("8.0.10 requirement should find 8.0.27 as best version")
-		} else if warning == "" {
+		} else if warning == nil {
 			t.Error("8.0.10 requirement should trigger a warning")
+		} else if warning.String() != "the current dir requires PHP 8.0.10 (testing), but this version is not available: fallback to 8.0" {
+			t.Errorf("8.0.10 requirement warning should be formatted, got %q", warning)
+		} else if warning.Kind != WarningPatchFallback || warning.Matched != "8.0.27" {
+			t.Errorf("8.0.10 requirement warning should be a patch fallback to 8.0.27, got %+v", warning)
 		}
 	}
 
@@ -53,8 +74,1868 @@ func TestBestVersion(t *testing.T) {
 			t.Error("8.0.99 requirement should find a best version")
 		} else if bestVersion.Version != "8.0.27" {
 			t.Error("8.0.99 requirement should find 8.0.27 as best version")
-		} else if warning != "" {
+		} else if warning != nil {
 			t.Error("8.0.99 requirement should not trigger a warning")
 		}
 	}
 }
+
+func TestBestVersionWithConstraint(t *testing.T) {
+	store := New("/dev/null", false, nil)
+	for _, v := range []string{"7.4.33", "8.0.27", "8.1.2", "8.1.14", "8.2.1", "8.3.4"} {
+		store.addVersion(&Version{
+			Version: v,
+			PHPPath: filepath.Join("/foo", v, "bin", "php"),
+		})
+	}
+
+	for constraint, expected := range map[string]string{
+		"^8.1":             "8.3.4",
+		"^7.4":             "7.4.33",
+		"~8.1.0":           "8.1.14",
+		">=8.1 <8.3":       "8.2.1",
+		">= 8.0, < 8.2":    "8.1.14",
+		"<=8.1":            "8.1.14",
+		"8.0.*":            "8.0.27",
+		"^7.4 || ~8.0.0":   "8.0.27",
+		"8.0 - 8.1":        "8.1.14",
+		">=8.1 !=8.3.4":    "8.2.1",
+		"^8.1.3 <8.2":      "8.1.14",
+		">8.2":             "8.3.4",
+		"^7.3|^8.0 <8.1.0": "8.0.27",
+	} {
+		bestVersion, _, warning, err := store.bestVersion(constraint, "testing")
+		if err != nil {
+			t.Errorf("%s requirement should not fail: %s", constraint, err)
+		} else if bestVersion == nil {
+			t.Errorf("%s requirement should find a best version", constraint)
+		} else if bestVersion.Version != expected {
+			t.Errorf("%s requirement should find %s as best version, got %s", constraint, expected, bestVersion.Version)
+		} else if warning != nil {
+			t.Errorf("%s requirement should not trigger a warning", constraint)
+		}
+	}
+
+	for _, constraint := range []string{"^9.0", ">=8.1 <8.1.1", "^foo"} {
+		if _, _, warning, _ := store.bestVersion(constraint, "testing"); warning == nil {
+			t.Errorf("%s requirement should trigger a warning", constraint)
+		}
+	}
+}
+
+func TestBestVersionWithFlavor(t *testing.T) {
+	store := New("/dev/null", false, nil)
+	store.addVersion(&Version{Version: "8.2.10", PHPPath: "/foo/8.2.10/bin/php", FPMPath: "/foo/8.2.10/sbin/php-fpm"})
+	store.addVersion(&Version{Version: "8.2.12", PHPPath: "/foo/8.2.12/bin/php", CGIPath: "/foo/8.2.12/bin/php-cgi"})
+	store.addVersion(&Version{Version: "8.3.1", PHPPath: "/foo/8.3.1/bin/php"})
+	store.addVersion(&Version{Version: "8.3.2", PHPPath: "/foo/8.3.2/bin/php", LSAPIPath: "/foo/8.3.2/bin/lsphp"})
+
+	for requirement, expected := range map[string]string{
+		"8.3-lsapi": "8.3.2",
+		"8.2-fpm":   "8.2.10",
+		"8.2-cgi":   "8.2.12",
+		"8.2-cli":   "8.2.12",
+		"8.2":       "8.2.12",
+		"^8.2-fpm":  "8.2.10",
+		"^8.2-cli":  "8.3.2",
+	} {
+		bestVersion, _, warning, _ := store.bestVersion(requirement, "testing")
+		if bestVersion == nil {
+			t.Errorf("%s requirement should find a best version", requirement)
+		} else if bestVersion.Version != expected {
+			t.Errorf("%s requirement should find %s as best version, got %s", requirement, expected, bestVersion.Version)
+		} else if warning != nil {
+			t.Errorf("%s requirement should not trigger a warning", requirement)
+		}
+	}
+
+	if v := store.versions[3]; !v.IsLSAPIServer() || v.ServerPath() != "/foo/8.3.2/bin/lsphp" || v.ServerTypeName() != "PHP LSAPI" {
+		t.Errorf("versions with lsphp should be LSAPI servers, got %s", v.ServerTypeName())
+	}
+
+	for _, requirement := range []string{"8.3-fpm", "8.2-frankenphp", "^8.3-cgi", "8.2-lsapi"} {
+		if _, _, warning, _ := store.bestVersion(requirement, "testing"); warning == nil || warning.Kind != WarningFlavorNotAvailable {
+			t.Errorf("%s requirement should trigger a flavor warning, got %v", requirement, warning)
+		}
+	}
+}
+
+func TestBestVersionForDirRequireFlavor(t *testing.T) {
+	store := New(t.TempDir(), false, nil, WithSources(SourcePHPVersion))
+	store.addVersion(&Version{Version: "8.2.1", PHPPath: "/foo/8.2.1/bin/php", FPMPath: "/foo/8.2.1/sbin/php-fpm"})
+	store.addVersion(&Version{Version: "8.3.4", PHPPath: "/foo/8.3.4/bin/php", IsSystem: true})
+	store.pathVersion = store.versions[1]
+
+	dir := t.TempDir()
+	if v, _, _, _ := store.BestVersionForDir(dir); v == nil || v.Version != "8.3.4" {
+		t.Errorf("the version of the PATH should be used without requirements, got %v", v)
+	}
+	if v, _, _, _ := store.BestVersionForDir(dir, WithFlavor(FlavorFPM)); v == nil || v.Version != "8.2.1" {
+		t.Errorf("the fallback version should support the required flavor, got %v", v)
+	}
+	if _, _, _, err := store.BestVersionForDir(dir, WithFlavor(FlavorLSAPI)); err == nil {
+		t.Error("an error should be returned when no versions support the required flavor")
+	}
+
+	for requirement, expected := range map[string]string{
+		"8.2-cgi": "8.2.1",
+		"^8.2":    "8.2.1",
+		"8.3":     "8.2.1",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, ".php-version"), []byte(requirement), 0644); err != nil {
+			t.Fatal(err)
+		}
+		later := time.Now().Add(time.Duration(len(requirement)) * time.Minute)
+		if err := os.Chtimes(filepath.Join(dir, ".php-version"), later, later); err != nil {
+			t.Fatal(err)
+		}
+		v, _, warnings, _ := store.BestVersionForDirWithWarnings(dir, WithFlavor(FlavorFPM))
+		if v == nil || v.Version != expected {
+			t.Errorf("%s requirement should find %s as best FPM version, got %v", requirement, expected, v)
+		}
+		if requirement == "8.3" && (len(warnings) != 1 || warnings[0].Kind != WarningFlavorNotAvailable || warnings[0].Requested != "8.3-fpm") {
+			t.Errorf("a flavor warning should be returned for %s, got %v", requirement, warnings)
+		}
+	}
+}
+
+func TestFPMOnlyVersion(t *testing.T) {
+	store := New("/dev/null", false, nil)
+	store.addVersion(&Version{Version: "8.3.1", PHPPath: "/foo/8.3.1/bin/php"})
+	store.addVersion(&Version{Version: "8.4.2", FPMPath: "/foo/8.4.2/sbin/php-fpm8.4"})
+
+	for requirement, expected := range map[string]string{
+		"8.4-fpm":  "8.4.2",
+		"^8.2-fpm": "8.4.2",
+		"^8.2":     "8.3.1",
+		"8.3":      "8.3.1",
+	} {
+		if v, _, warning, _ := store.bestVersion(requirement, "testing"); v == nil || v.Version != expected || warning != nil {
+			t.Errorf("%s requirement should find %s as best version, got %v (%v)", requirement, expected, v, warning)
+		}
+	}
+	v, _, warning, _ := store.bestVersion("8.4", "testing")
+	if warning == nil || warning.Kind != WarningFlavorNotAvailable || v == nil || v.Version != "8.3.1" {
+		t.Errorf("FPM-only versions should not be used without the fpm flavor, got %v (%v)", v, warning)
+	}
+	if v, _, _, _ := store.fallbackVersion(nil, ""); v == nil || v.Version != "8.3.1" {
+		t.Errorf("FPM-only versions should not be used as the fallback version, got %v", v)
+	}
+	if v := store.versions[1]; v.String() != "8.4.2 /foo/8.4.2/sbin/php-fpm8.4" || !v.IsFPMServer() {
+		t.Errorf("FPM-only versions should be identified by their FPM binary, got %s", v)
+	}
+}
+
+func TestBestVersionForDirWithComposerRequire(t *testing.T) {
+	store := New("/dev/null", false, nil)
+	for _, v := range []string{"7.4.33", "8.1.14", "8.2.1", "8.3.4"} {
+		store.addVersion(&Version{
+			Version: v,
+			PHPPath: filepath.Join("/foo", v, "bin", "php"),
+		})
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "composer.json"), []byte(`{"require": {"php": ">=8.1 <8.3"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v, source, _, err := store.BestVersionForDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != "8.2.1" {
+		t.Errorf("composer.json require should find 8.2.1 as best version, got %s", v.Version)
+	}
+	if !strings.Contains(source, "composer.json require") {
+		t.Errorf("source should mention composer.json require, got %s", source)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "composer.json"), []byte(`{"require": {"php": "^8.1"}, "config": {"platform": {"php": "7.4.33"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _, _ := store.BestVersionForDir(dir); v == nil || v.Version != "7.4.33" {
+		t.Error("composer.json config.platform.php should have priority over require")
+	}
+}
+
+func TestBestVersionForDirWithExtensions(t *testing.T) {
+	store := New("/dev/null", false, nil)
+	store.addVersion(&Version{Version: "8.2.1", PHPPath: "/foo/8.2.1/bin/php", Extensions: []string{"Core", "intl", "redis", "Zend OPcache"}})
+	store.addVersion(&Version{Version: "8.2.5", PHPPath: "/foo/8.2.5/bin/php", Extensions: []string{"Core", "intl"}})
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "composer.json"), []byte(`{"require": {"php": "^8.2", "ext-intl": "*", "ext-redis": "*", "ext-zend-opcache": "*"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v, _, warning, err := store.BestVersionForDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != "8.2.1" {
+		t.Errorf("the version providing all required extensions should be preferred, got %s", v.Version)
+	}
+	if warning != "" {
+		t.Errorf("no warning should be triggered, got %q", warning)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "composer.json"), []byte(`{"require": {"php": "^8.2", "ext-mongodb": "*"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v, _, warning, _ = store.BestVersionForDir(dir)
+	if v.Version != "8.2.5" {
+		t.Errorf("the most recent version should be used when no version provides the required extensions, got %s", v.Version)
+	}
+	if warning != "PHP 8.2.5 does not provide the following extensions required by composer.json: mongodb" {
+		t.Errorf("a warning listing missing extensions should be triggered, got %q", warning)
+	}
+
+	_, _, warnings, _ := store.BestVersionForDirWithWarnings(dir)
+	if len(warnings) != 1 || warnings[0].Kind != WarningMissingExtensions || strings.Join(warnings[0].Extensions, ",") != "mongodb" {
+		t.Errorf("a structured warning listing missing extensions should be returned, got %v", warnings)
+	}
+}
+
+func TestBestVersionForDirWithSources(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".php-version"), []byte("8.1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "composer.json"), []byte(`{"config": {"platform": {"php": "8.2.1"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		opts     []Option
+		config   string
+		expected string
+	}{
+		{nil, "", "8.1.14"},
+		{[]Option{WithSources(SourceComposerPlatform, SourcePHPVersion)}, "", "8.2.1"},
+		{[]Option{WithSources(SourceComposerRequire)}, "", "8.3.4"},
+		{nil, `{"sources": ["composer-platform"]}`, "8.2.1"},
+		{[]Option{WithSources(SourcePHPVersion)}, `{"sources": ["composer-platform"]}`, "8.1.14"},
+	} {
+		configDir := t.TempDir()
+		if test.config != "" {
+			if err := os.WriteFile(filepath.Join(configDir, "php_store.json"), []byte(test.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		store := New(configDir, false, nil, test.opts...)
+		for _, v := range []string{"8.1.14", "8.2.1", "8.3.4"} {
+			store.addVersion(&Version{
+				Version: v,
+				PHPPath: filepath.Join("/foo", v, "bin", "php"),
+			})
+		}
+		if v, _, _, _ := store.BestVersionForDir(dir); v == nil || v.Version != test.expected {
+			t.Errorf("sources %v (config %q) should find %s as best version, got %v", store.sources, test.config, test.expected, v)
+		}
+	}
+}
+
+func TestSourceResolvers(t *testing.T) {
+	for _, test := range []struct {
+		source   string
+		files    map[string]string
+		expected string
+	}{
+		{SourceSymfonyLocal, map[string]string{".symfony.local.yaml": "http:\n  port: 8001\nphp:\n  version: 8.3\n  flavor: fpm\n"}, "8.3-fpm"},
+		{SourceSymfonyLocal, map[string]string{".symfony.local.yaml": "php:\n  version: '8.2'\n"}, "8.2"},
+		{SourceSymfonyLocal, map[string]string{".symfony.local.yaml": "http:\n  port: 8001\n"}, ""},
+		{SourceWPEnv, map[string]string{".wp-env.json": `{"phpVersion": "8.2"}`}, "8.2"},
+		{SourceWPEnv, map[string]string{".wp-env.json": `{"phpVersion": null}`}, ""},
+		{SourceWPEnv, map[string]string{".wp-env.json": `{"phpVersion": "8.1"}`, ".wp-env.override.json": `{"phpVersion": "8.3"}`}, "8.3"},
+		{SourceWPEnv, map[string]string{".wp-env.json": `{"phpVersion": "8.1"}`, ".wp-env.override.json": `{"port": 8889}`}, "8.1"},
+		{SourceAppEngine, map[string]string{"app.yaml": "runtime: php83\nhandlers:\n- url: /.*\n  script: auto\n"}, "8.3"},
+		{SourceAppEngine, map[string]string{"app.yaml": "runtime: php\nenv: flex\n"}, ""},
+		{SourceAppEngine, map[string]string{"app.yaml": "runtime: python312\n"}, ""},
+		{SourceBref, map[string]string{"serverless.yml": "provider:\n  name: aws\n  runtime: php-82\n"}, "8.2"},
+		{SourceBref, map[string]string{"serverless.yml": "functions:\n  console:\n    handler: bin/console\n    runtime: php-83-console\n  web:\n    handler: public/index.php\n    runtime: php-83-fpm\n"}, "8.3-fpm"},
+		{SourceBref, map[string]string{"serverless.yml": "provider:\n  runtime: provided.al2\nfunctions:\n  api:\n    layers:\n      - ${bref:layer.php-81-fpm}\n"}, "8.1-fpm"},
+		{SourceBref, map[string]string{"serverless.yml": "provider:\n  runtime: nodejs20.x\n"}, ""},
+		{SourceGitLabCI, map[string]string{".gitlab-ci.yml": "image: php:8.2-cli-alpine\ntest:\n  script: vendor/bin/phpunit\n"}, "8.2"},
+		{SourceGitLabCI, map[string]string{".gitlab-ci.yml": "default:\n  image:\n    name: docker.io/library/php:8.1.27\n"}, "8.1.27"},
+		{SourceGitLabCI, map[string]string{".gitlab-ci.yml": "lint:\n  image: node:20\ntest:\n  image: php:8.3\n"}, "8.3"},
+		{SourceGitLabCI, map[string]string{".gitlab-ci.yml": "image: phpstan/phpstan:1.10\n"}, ""},
+		{SourceGitHubActions, map[string]string{".github/workflows/ci.yml": "on: push\njobs:\n  tests:\n    runs-on: ubuntu-latest\n    steps:\n      - uses: actions/checkout@v4\n      - uses: shivammathur/setup-php@v2\n        with:\n          php-version: '8.3'\n"}, "8.3"},
+		{SourceGitHubActions, map[string]string{".github/workflows/ci.yml": "jobs:\n  tests:\n    steps:\n      - uses: shivammathur/setup-php@v2\n        with:\n          php-version: ${{ matrix.php }}\n"}, ""},
+	} {
+		root := t.TempDir()
+		for name, contents := range test.files {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(root, name), []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dir := filepath.Join(root, "src")
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		store := New(t.TempDir(), false, nil)
+		if requirement, _ := sourceResolvers[test.source](store, dir); requirement != test.expected {
+			t.Errorf("%s should resolve %v to %q, got %q", test.source, test.files, test.expected, requirement)
+		}
+	}
+}
+
+func TestComposerEnv(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"composer.json":     `{"require": {"php": "^8.1"}}`,
+		"composer-dev.json": `{"require": {"php": "~8.3.0", "ext-intl": "*"}}`,
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := filepath.Join(root, "src")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	store := New(t.TempDir(), false, nil)
+
+	for env, expected := range map[string]string{
+		"":                                       "^8.1",
+		"composer-dev.json":                      "~8.3.0",
+		filepath.Join(root, "composer-dev.json"): "~8.3.0",
+		"missing.json":                           "",
+	} {
+		t.Setenv("COMPOSER", env)
+		if requirement, source := sourceResolvers[SourceComposerRequire](store, dir); requirement != expected {
+			t.Errorf("COMPOSER=%s should resolve to %q, got %q (%s)", env, expected, requirement, source)
+		}
+	}
+	t.Setenv("COMPOSER", "composer-dev.json")
+	if extensions := store.requiredExtensionsForDir(dir); len(extensions) != 1 || extensions[0] != "intl" {
+		t.Errorf("the extensions of the manifest set by COMPOSER should be required, got %v", extensions)
+	}
+}
+
+func TestWorkspaceResolution(t *testing.T) {
+	outer := t.TempDir()
+	root := filepath.Join(outer, "repo")
+	dir := filepath.Join(root, "packages", "api")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, contents := range map[string]string{
+		filepath.Join(outer, "composer.json"): `{"require": {"php": "^7.0"}}`,
+		filepath.Join(root, "composer.json"):  `{"require": {"php": "~8.2.0", "ext-intl": "*"}}`,
+		filepath.Join(dir, "composer.json"):   `{"require": {"php": "^8.1 || ^7.4", "ext-mbstring": "*"}}`,
+	} {
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		opts        []Option
+		requirement string
+		expected    string
+		extensions  string
+	}{
+		{nil, "^8.1 || ^7.4", "8.3.4", "mbstring"},
+		{[]Option{WithWorkspaceResolution()}, "^8.1 ~8.2.0 || ^7.4 ~8.2.0", "8.2.1", "intl mbstring"},
+	} {
+		store := New(t.TempDir(), false, nil, append(test.opts, WithSources(SourceComposerPlatform, SourceComposerRequire))...)
+		for _, v := range []string{"7.4.33", "8.1.14", "8.2.1", "8.3.4"} {
+			store.addVersion(&Version{Version: v, PHPPath: filepath.Join("/foo", v, "bin", "php")})
+		}
+		if requirement, _ := sourceResolvers[SourceComposerRequire](store, dir); requirement != test.requirement {
+			t.Errorf("the require.php constraint should be %q, got %q", test.requirement, requirement)
+		}
+		if v, _, _, _ := store.BestVersionForDir(dir); v == nil || v.Version != test.expected {
+			t.Errorf("%s should be the best version, got %v", test.expected, v)
+		}
+		if extensions := strings.Join(store.requiredExtensionsForDir(dir), " "); extensions != test.extensions {
+			t.Errorf("the required extensions should be %q, got %q", test.extensions, extensions)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "composer.json"), []byte(`{"config": {"platform": {"php": "8.1.14"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := New(t.TempDir(), false, nil, WithWorkspaceResolution(".git"))
+	if requirement, _ := sourceResolvers[SourceComposerPlatform](store, dir); requirement != "8.1.14" {
+		t.Errorf("the platform of the workspace root should be used, got %q", requirement)
+	}
+}
+
+func TestExplainVersionForDir(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, ".php-version"), []byte("8.2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "composer.json"), []byte(`{"require": {"php": "^8.1", "ext-intl": "*"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "src")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	store := New(t.TempDir(), false, nil, WithSources(SourcePHPVersion, SourceComposerRequire))
+	store.addVersion(&Version{Version: "8.1.14", PHPPath: "/foo/8.1.14/bin/php", Extensions: []string{"intl"}})
+	store.addVersion(&Version{Version: "8.2.1", PHPPath: "/foo/8.2.1/bin/php", Extensions: []string{"intl"}})
+	store.addVersion(&Version{Version: "8.2.5", PHPPath: "/foo/8.2.5/bin/php", Extensions: []string{"ctype"}})
+
+	e := store.ExplainVersionForDir(dir)
+	if len(e.Steps) != 2 || !e.Steps[0].Used || e.Steps[1].Used || e.Steps[1].Requirement != "^8.1" {
+		t.Errorf("all sources should be reported, the first one being used, got %+v", e.Steps)
+	}
+	if len(e.Steps[0].Files) != 1 || e.Steps[0].Files[0] != filepath.Join(root, ".php-version") {
+		t.Errorf("the files of the sources should be reported, got %v", e.Steps[0].Files)
+	}
+	if e.Requirement != "8.2" || len(e.Candidates) != 2 || e.Candidates[0].Version.Version != "8.2.5" || len(e.Candidates[0].MissingExtensions) != 1 {
+		t.Errorf("the versions matching the requirement should be reported, got %+v", e.Candidates)
+	}
+	if e.Version == nil || e.Version.Version != "8.2.1" || e.Reason != "it is the most recent version matching 8.2, and it provides the extensions required by composer.json" {
+		t.Errorf("the selected version should be explained, got %v: %s", e.Version, e.Reason)
+	}
+	if out := e.String(); !strings.Contains(out, "^8.1 (composer.json require from current dir") || !strings.Contains(out, "missing intl") {
+		t.Errorf("the explanation should be displayed, got %s", out)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, ".php-version"), []byte("7.4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if e := store.ExplainVersionForDir(dir); len(e.Candidates) != 0 || !strings.HasPrefix(e.Reason, "no installed version satisfies 7.4: falling back to the ") {
+		t.Errorf("the fallback should be explained, got %s", e.Reason)
+	}
+}
+
+func TestBestVersionForDirCache(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "src")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".php-version"), []byte("8.1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := New(t.TempDir(), false, nil, WithSources(SourcePHPVersion))
+	for _, v := range []string{"8.1.14", "8.2.1", "8.3.4"} {
+		store.addVersion(&Version{Version: v, PHPPath: filepath.Join("/foo", v, "bin", "php")})
+	}
+	expect := func(expected string) {
+		t.Helper()
+		if v, _, _, _ := store.BestVersionForDir(dir); v == nil || v.Version != expected {
+			t.Errorf("%s should be the best version, got %v", expected, v)
+		}
+	}
+
+	expect("8.1.14")
+	if store.resolutions.get(newResolutionKey(dir, "")) == nil {
+		t.Error("the resolution should be cached")
+	}
+
+	// modified file
+	if err := os.WriteFile(filepath.Join(root, ".php-version"), []byte("8.3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(root, ".php-version"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	expect("8.3.4")
+
+	// created file
+	if err := os.WriteFile(filepath.Join(dir, ".php-version"), []byte("8.2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect("8.2.1")
+
+	// new version
+	store.addVersion(&Version{Version: "8.2.9", PHPPath: "/foo/8.2.9/bin/php"})
+	expect("8.2.9")
+}
+
+func TestBestVersionStrict(t *testing.T) {
+	store := New("/dev/null", false, nil, WithStrict())
+	for _, v := range []string{"8.0.27", "8.1.14", "8.2.1"} {
+		store.addVersion(&Version{
+			Version: v,
+			PHPPath: filepath.Join("/foo", v, "bin", "php"),
+		})
+	}
+
+	if v, _, _, err := store.bestVersion("8.1", "testing"); err != nil || v.Version != "8.1.14" {
+		t.Errorf("8.1 requirement should find 8.1.14 as best version in strict mode")
+	}
+
+	for _, requirement := range []string{"7.4", "8.0.10", "^9.0", "8.2-fpm"} {
+		v, _, _, err := store.bestVersion(requirement, "testing")
+		var unsatisfied *UnsatisfiedVersionError
+		if !errors.As(err, &unsatisfied) {
+			t.Errorf("%s requirement should return an UnsatisfiedVersionError in strict mode, got %v", requirement, err)
+		} else if unsatisfied.Requirement != requirement {
+			t.Errorf("%s requirement should be reported in the error, got %s", requirement, unsatisfied.Requirement)
+		}
+		if v != nil {
+			t.Errorf("%s requirement should not fall back to another version in strict mode", requirement)
+		}
+	}
+}
+
+func TestBestVersionsForDir(t *testing.T) {
+	store := New("/dev/null", false, nil)
+	store.addVersion(&Version{Version: "8.1.14", PHPPath: "/foo/8.1.14/bin/php", FPMPath: "/foo/8.1.14/sbin/php-fpm"})
+	store.addVersion(&Version{Version: "8.1.20", PHPPath: "/foo/8.1.20/bin/php"})
+	store.addVersion(&Version{Version: "8.1.27", PHPPath: "/foo/8.1.27/bin/php", FPMPath: "/foo/8.1.27/sbin/php-fpm"})
+	store.addVersion(&Version{Version: "8.2.1", PHPPath: "/foo/8.2.1/bin/php", FPMPath: "/foo/8.2.1/sbin/php-fpm"})
+	store.addVersion(&Version{Version: "8.3.4", PHPPath: "/foo/8.3.4/bin/php"})
+	store.addVersion(&Version{Version: "8.4.1", Path: "/foo/8.4.1", FPMPath: "/foo/8.4.1/sbin/php-fpm"})
+	rank := func(dir string, opts ...LookupOption) string {
+		var ranked []string
+		for _, v := range store.BestVersionsForDir(dir, opts...) {
+			ranked = append(ranked, v.Version)
+		}
+		return strings.Join(ranked, " ")
+	}
+
+	// without requirements, the fallback version comes first
+	store.defaultVersion = "8.2"
+	if ranked, expected := rank(t.TempDir()), "8.2.1 8.3.4 8.1.27 8.1.20 8.1.14"; ranked != expected {
+		t.Errorf("versions should be ranked as %s, got %s", expected, ranked)
+	}
+	store.defaultVersion = ""
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".php-version"), []byte("8.1.20-fpm"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "composer.json"), []byte(`{"require": {"php": "^8.2"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if ranked, expected := rank(dir), "8.1.20 8.1.27 8.1.14 8.3.4 8.2.1"; ranked != expected {
+		t.Errorf("versions should be ranked as %s, got %s", expected, ranked)
+	}
+	// FPM-only installations are only returned when FPM is required
+	if ranked, expected := rank(dir, WithFlavor(FlavorFPM)), "8.1.20 8.1.27 8.1.14 8.4.1 8.2.1 8.3.4"; ranked != expected {
+		t.Errorf("versions should be ranked as %s when FPM is required, got %s", expected, ranked)
+	}
+}
+
+func TestBestVersionForConstraint(t *testing.T) {
+	store := New("/dev/null", false, nil)
+	store.addVersion(&Version{Version: "8.1.14", PHPPath: "/foo/8.1.14/bin/php", FPMPath: "/foo/8.1.14/sbin/php-fpm"})
+	store.addVersion(&Version{Version: "8.2.1", PHPPath: "/foo/8.2.1/bin/php"})
+
+	for _, test := range []struct {
+		constraint, flavor, expected string
+	}{
+		{"^8.1", "", "8.2.1"},
+		{"^8.1", FlavorFPM, "8.1.14"},
+		{"^8.1-fpm", "", "8.1.14"},
+		{"8.1", "", "8.1.14"},
+		{"8.2.1", "", "8.2.1"},
+	} {
+		v, err := store.BestVersionForConstraint(test.constraint, test.flavor)
+		if err != nil {
+			t.Errorf("%s (%s) should not fail: %s", test.constraint, test.flavor, err)
+		} else if v.Version != test.expected {
+			t.Errorf("%s (%s) should find %s as best version, got %s", test.constraint, test.flavor, test.expected, v.Version)
+		}
+	}
+
+	for _, constraint := range []string{"^9.0", "8.2.2", "^8.2-cgi"} {
+		if _, err := store.BestVersionForConstraint(constraint, ""); err == nil {
+			t.Errorf("%s should not find any version", constraint)
+		}
+	}
+
+	store.aliases = map[string]string{"lts": "^8.1", "old": "8.1", "work": "/foo/8.2.1/bin/php"}
+	for _, test := range []struct {
+		alias, flavor, expected string
+	}{
+		{"lts", "", "8.2.1"},
+		{"lts", FlavorFPM, "8.1.14"},
+		{"old-fpm", "", "8.1.14"},
+		{"work", "", "8.2.1"},
+		{"work-fpm", "", ""},
+		{"work", FlavorFPM, ""},
+	} {
+		v, err := store.BestVersionForConstraint(test.alias, test.flavor)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("%s (%s) should not find any version, got %s", test.alias, test.flavor, v.Version)
+			}
+		} else if err != nil || v.Version != test.expected {
+			t.Errorf("%s (%s) should resolve to %s, got %v (%v)", test.alias, test.flavor, test.expected, v, err)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	store := New("/dev/null", false, nil)
+	store.addVersion(&Version{Version: "7.4.33", PHPPath: "/foo/7.4.33/bin/php", FPMPath: "/foo/7.4.33/sbin/php-fpm"})
+	store.addVersion(&Version{Version: "8.1.14", PHPPath: "/foo/8.1.14/bin/php", FPMPath: "/foo/8.1.14/sbin/php-fpm", Extensions: []string{"intl"}, Arch: "arm64", Source: "homebrew"})
+	store.addVersion(&Version{Version: "8.2.1", PHPPath: "/foo/8.2.1/bin/php", DebugBuild: true, Source: "Homebrew"})
+	store.addVersion(&Version{Version: "8.3.4", PHPPath: "/foo/8.3.4/bin/php", FPMPath: "/foo/8.3.4/sbin/php-fpm", ThreadSafe: true, Arch: ArchUniversal, OPcache: &OPcache{Loaded: true, JIT: true}})
+
+	for _, test := range []struct {
+		filters  []Filter
+		expected string
+	}{
+		{nil, "7.4.33 8.1.14 8.2.1 8.3.4"},
+		{[]Filter{FilterFlavor(FlavorFPM)}, "7.4.33 8.1.14 8.3.4"},
+		{[]Filter{FilterFlavor(FlavorFPM), FilterMinVersion("8.1")}, "8.1.14 8.3.4"},
+		{[]Filter{FilterMaxVersion("8.2")}, "7.4.33 8.1.14 8.2.1"},
+		{[]Filter{FilterConstraint("^8.2")}, "8.2.1 8.3.4"},
+		{[]Filter{FilterExtension("ext-intl")}, "8.1.14"},
+		{[]Filter{FilterConstraint("^foo")}, ""},
+		{[]Filter{FilterThreadSafe(true)}, "8.3.4"},
+		{[]Filter{FilterThreadSafe(false), FilterMinVersion("8.2")}, "8.2.1"},
+		{[]Filter{FilterDebugBuild(true)}, "8.2.1"},
+		{[]Filter{FilterArch("aarch64")}, "8.1.14 8.3.4"},
+		{[]Filter{FilterJIT()}, "8.3.4"},
+		{[]Filter{FilterSource("homebrew")}, "8.1.14 8.2.1"},
+	} {
+		var found []string
+		for _, v := range store.Find(test.filters...) {
+			found = append(found, v.Version)
+		}
+		if strings.Join(found, " ") != test.expected {
+			t.Errorf("Find should return %q, got %q", test.expected, strings.Join(found, " "))
+		}
+	}
+}
+
+func TestLazyDiscovery(t *testing.T) {
+	configDir := t.TempDir()
+	writeCache(t, configDir, "8.2.1")
+
+	store := New(configDir, false, nil, WithLazyDiscovery())
+	if store.versions != nil {
+		t.Error("versions should not be loaded before being needed")
+	}
+	if versions := store.Versions(); len(versions) != 1 || versions[0].Version != "8.2.1" {
+		t.Errorf("versions should be loaded on first use, got %v", versions)
+	}
+	if !store.IsVersionAvailable("8.2") {
+		t.Error("8.2 should be available")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	configDir := t.TempDir()
+	writeCache(t, configDir, "8.2.1")
+
+	store := New(configDir, false, nil, WithCacheTTL(time.Hour))
+	store.WaitForRefresh()
+	cache := store.cachePath()
+	if contents, _ := os.ReadFile(cache); !strings.Contains(string(contents), "8.2.1") {
+		t.Error("a fresh cache should not be refreshed")
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(cache, old, old); err != nil {
+		t.Fatal(err)
+	}
+	store = New(configDir, false, nil, WithCacheTTL(time.Hour))
+	if versions := store.Versions(); len(versions) != 1 || versions[0].Version != "8.2.1" {
+		t.Errorf("cached versions should be used while the cache is refreshed, got %v", versions)
+	}
+	store.WaitForRefresh()
+	if contents, _ := os.ReadFile(cache); strings.Contains(string(contents), "8.2.1") {
+		t.Error("an expired cache should be refreshed in the background")
+	}
+}
+
+func TestPruneStaleCacheEntries(t *testing.T) {
+	configDir := t.TempDir()
+	paths := writeCache(t, configDir, "8.1.14", "8.2.1")
+	if err := os.Remove(paths[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	store := New(configDir, false, nil)
+	if versions := store.Versions(); len(versions) != 1 || versions[0].Version != "8.2.1" {
+		t.Errorf("versions whose binary does not exist anymore should be removed, got %v", versions)
+	}
+	if contents, _ := os.ReadFile(filepath.Join(configDir, "php_versions.json")); strings.Contains(string(contents), "8.1.14") {
+		t.Error("the cache should be updated when stale versions are removed")
+	}
+}
+
+// writeCache creates fake PHP binaries for the given versions and stores them
+// in the cache of configDir; it returns the paths of the binaries
+func writeCache(t *testing.T, configDir string, versions ...string) []string {
+	t.Helper()
+	var paths []string
+	var cached []map[string]string
+	for _, v := range versions {
+		path := filepath.Join(t.TempDir(), "php")
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+		cached = append(cached, map[string]string{"version": v, "php_path": path})
+	}
+	contents, err := json.Marshal(map[string]interface{}{"schema_version": SchemaVersion, "versions": cached})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "php_versions.json"), contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestCacheLocking(t *testing.T) {
+	configDir := t.TempDir()
+	store := New(configDir, false, nil)
+	store.versions = versions{{Version: "8.2.1", PHPPath: "/foo/8.2.1/bin/php"}}
+
+	unlock, err := tryLockFile(store.cachePath()+".lock", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan bool)
+	go func() {
+		store.writeCache()
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("the cache should not be written while locked by another process")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	<-written
+	if contents, _ := os.ReadFile(store.cachePath()); !strings.Contains(string(contents), "8.2.1") {
+		t.Error("the cache should be written once unlocked")
+	}
+}
+
+func TestVersionFromBinary(t *testing.T) {
+	dir := t.TempDir()
+	utf16 := func(s string) string {
+		var b strings.Builder
+		for _, r := range s {
+			b.WriteString(string(r) + "\x00")
+		}
+		return b.String()
+	}
+	for name, contents := range map[string]string{
+		"header":      "\x7fELF\x00\x00junk\x00X-Powered-By: PHP/8.3.4\x00more junk",
+		"boundary":    strings.Repeat("\x00", 1<<20-10) + "X-Powered-By: PHP/8.1.27\x00",
+		"versioninfo": "MZ\x00\x00" + utf16("FileVersion") + "\x00\x00" + utf16("8.2.15") + "\x00\x00",
+		"none":        "#!/bin/sh\necho hello\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{"header": "8.3.4", "boundary": "8.1.27", "versioninfo": "8.2.15"}[name]
+		if v := versionFromBinary(path); v != expected {
+			t.Errorf("versionFromBinary(%s) = %q, expected %q", name, v, expected)
+		}
+	}
+	if v := versionFromBinary(filepath.Join(dir, "missing")); v != "" {
+		t.Errorf("versionFromBinary should return an empty string for missing files, got %q", v)
+	}
+}
+
+func TestBinaryArch(t *testing.T) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arch := binaryArch(self); arch != normalizeArch(runtime.GOARCH) {
+		t.Errorf("binaryArch should return %q for the test binary, got %q", normalizeArch(runtime.GOARCH), arch)
+	}
+	if arch := binaryArch(filepath.Join(t.TempDir(), "missing")); arch != "" {
+		t.Errorf("binaryArch should return an empty string for missing files, got %q", arch)
+	}
+}
+
+// universalBinary writes a macOS universal binary with empty slices of the
+// given CPU types
+func universalBinary(t *testing.T, path string, cpus ...macho.Cpu) {
+	t.Helper()
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, []uint32{macho.MagicFat, uint32(len(cpus))})
+	for i, cpu := range cpus {
+		binary.Write(&buf, binary.BigEndian, []uint32{uint32(cpu), 0, uint32(4096 * (i + 1)), 32, 12})
+	}
+	for i, cpu := range cpus {
+		buf.Write(make([]byte, 4096*(i+1)-buf.Len()))
+		binary.Write(&buf, binary.LittleEndian, []uint32{macho.Magic64, uint32(cpu), 0, uint32(macho.TypeExec), 0, 0, 0, 0})
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUniversalBinary(t *testing.T) {
+	dir := t.TempDir()
+	universalBinary(t, filepath.Join(dir, "php"), macho.CpuArm64, macho.CpuAmd64)
+	v := &Version{Version: "8.3.6", PHPPath: filepath.Join(dir, "php")}
+	v.detectArch()
+	if v.Arch != ArchUniversal || strings.Join(v.Archs, ",") != "arm64,x86_64" {
+		t.Errorf("both architectures should be recorded, got %s %v", v.Arch, v.Archs)
+	}
+	if v.Emulated || !v.SupportsArch("aarch64") || v.SupportsArch("i386") {
+		t.Errorf("universal binaries should only run natively on the architectures of their slices")
+	}
+
+	universalBinary(t, filepath.Join(dir, "php"), macho.CpuAmd64, macho.Cpu386)
+	v.detectArch()
+	if strings.Join(v.Archs, ",") != "i386,x86_64" || v.Emulated != (hostArch() != "x86_64" && hostArch() != "i386") {
+		t.Errorf("unexpected architectures %v (emulated %v)", v.Archs, v.Emulated)
+	}
+}
+
+func TestBinaryLibc(t *testing.T) {
+	if runtime.GOOS == "linux" {
+		self, err := os.Executable()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if libc := binaryLibc(self); libc != LibcGlibc && libc != LibcMusl && libc != LibcStatic {
+			t.Errorf("binaryLibc should detect the libc of the test binary, got %q", libc)
+		}
+	}
+	script := filepath.Join(t.TempDir(), "php")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if libc := binaryLibc(script); libc != "" {
+		t.Errorf("binaryLibc should return an empty string for scripts, got %q", libc)
+	}
+}
+
+func TestNativePreference(t *testing.T) {
+	for _, preferNative := range []bool{false, true} {
+		var opts []Option
+		expected := "8.2.9"
+		if preferNative {
+			opts = append(opts, WithNativePreference())
+			expected = "8.2.1"
+		}
+		store := New("/dev/null", false, nil, opts...)
+		store.addVersion(&Version{Version: "8.2.1", PHPPath: "/foo/8.2.1/bin/php", Arch: hostArch()})
+		store.addVersion(&Version{Version: "8.2.9", PHPPath: "/foo/8.2.9/bin/php", Arch: "sparc", Emulated: true})
+
+		v, _, _, _ := store.bestVersion("8.2", "testing")
+		if v.Version != expected {
+			t.Errorf("bestVersion should return %s (native preference: %v), got %s", expected, preferNative, v.Version)
+		}
+		if v, _ := store.BestVersionForConstraint("^8.2", ""); v.Version != "8.2.9" {
+			t.Errorf("BestVersionForConstraint is independent of preferences, got %s", v.Version)
+		}
+	}
+}
+
+func TestProbeXdebug(t *testing.T) {
+	v := &Version{}
+	(&phpProbe{Extensions: []string{"Core", "xdebug"}, ExtensionVersions: []string{"8.3.9", "3.3.1"}, XdebugMode: "debug,develop"}).apply(v)
+	if v.Xdebug == nil || !v.Xdebug.Enabled || v.Xdebug.Version != "3.3.1" || v.Xdebug.Mode != "debug,develop" {
+		t.Errorf("a loaded Xdebug should be reported, got %+v", v.Xdebug)
+	}
+
+	extensionDir := t.TempDir()
+	(&phpProbe{Extensions: []string{"Core"}, ExtensionDir: extensionDir}).apply(v)
+	if v.Xdebug != nil {
+		t.Errorf("Xdebug should not be reported when not installed, got %+v", v.Xdebug)
+	}
+	if err := os.WriteFile(filepath.Join(extensionDir, "xdebug.so"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	(&phpProbe{Extensions: []string{"Core"}, ExtensionDir: extensionDir}).apply(v)
+	if v.Xdebug == nil || v.Xdebug.Enabled {
+		t.Errorf("an installed but disabled Xdebug should be reported, got %+v", v.Xdebug)
+	}
+}
+
+func TestProbeOPcache(t *testing.T) {
+	jit := "tracing"
+	v := &Version{}
+	(&phpProbe{Extensions: []string{"Zend OPcache"}, OPcacheEnable: "1", OPcacheEnableCLI: "0", OPcacheJIT: &jit, OPcacheJITBufferSize: "64M"}).apply(v)
+	if v.OPcache == nil || !v.OPcache.Loaded || !v.OPcache.Enabled || v.OPcache.EnabledCLI || !v.OPcache.JIT || !v.OPcache.JITEnabled {
+		t.Errorf("a loaded OPcache with JIT should be reported, got %+v", v.OPcache)
+	}
+
+	jit = "disable"
+	(&phpProbe{Extensions: []string{"Zend OPcache"}, OPcacheEnable: "On", OPcacheJIT: &jit, OPcacheJITBufferSize: "64M"}).apply(v)
+	if !v.OPcache.Enabled || !v.OPcache.JIT || v.OPcache.JITEnabled {
+		t.Errorf("a disabled JIT should be reported, got %+v", v.OPcache)
+	}
+
+	(&phpProbe{Extensions: []string{"Zend OPcache"}, OPcacheEnable: "1"}).apply(v)
+	if v.OPcache.JIT || v.OPcache.JITEnabled {
+		t.Errorf("OPcache without JIT support should be reported, got %+v", v.OPcache)
+	}
+
+	(&phpProbe{Extensions: []string{"Core"}}).apply(v)
+	if v.OPcache != nil {
+		t.Errorf("OPcache should not be reported when not installed, got %+v", v.OPcache)
+	}
+}
+
+func TestVersionLabel(t *testing.T) {
+	v := &Version{Version: "8.3.8", PHPPath: "/opt/homebrew/bin/php", FPMPath: "/opt/homebrew/sbin/php-fpm", Source: "homebrew", Arch: "arm64"}
+	if v.Label() != "8.3.8 (Homebrew, FPM, arm64)" {
+		t.Errorf("unexpected label %q", v.Label())
+	}
+	if v.String() != "8.3.8 /opt/homebrew/bin/php" {
+		t.Errorf("unexpected string %q", v.String())
+	}
+	if label := (&Version{Version: "8.1.2", PHPPath: "/usr/bin/php"}).Label(); label != "8.1.2 (CLI)" {
+		t.Errorf("unexpected label %q", label)
+	}
+}
+
+func TestWriteVersions(t *testing.T) {
+	vs := []*Version{
+		{Version: "8.2.1", PHPPath: "/foo/8.2.1/bin/php", Source: "PATH", IsSystem: true},
+		{Version: "8.1.14", PHPPath: "/foo/8.1.14/bin/php", CGIPath: "/foo/8.1.14/bin/php-cgi", Arch: "x86_64"},
+	}
+	var table strings.Builder
+	if err := WriteVersions(&table, vs, FormatTable); err != nil {
+		t.Fatal(err)
+	}
+	expected := `VERSION  SERVER  SOURCE  ARCH    PATH
+8.1.14   CGI     -       x86_64  /foo/8.1.14/bin/php
+8.2.1    CLI     PATH    -       /foo/8.2.1/bin/php (system)
+`
+	if table.String() != expected {
+		t.Errorf("unexpected table:\n%s", table.String())
+	}
+
+	var out strings.Builder
+	if err := WriteVersions(&out, vs, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []*Version
+	if err := json.Unmarshal([]byte(out.String()), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[0].Version != "8.1.14" || decoded[1].Version != "8.2.1" {
+		t.Errorf("versions should be sorted, got %s", out.String())
+	}
+
+	if err := WriteVersions(&out, vs, "xml"); err == nil {
+		t.Error("unsupported formats should be rejected")
+	}
+}
+
+func TestDefaultVersion(t *testing.T) {
+	dir := t.TempDir()
+	store := New(dir, false, nil)
+	store.setVersions(nil)
+	for _, v := range []string{"8.1.14", "8.2.1", "8.3.2"} {
+		store.addVersion(&Version{Version: v, PHPPath: filepath.Join("/foo", v, "bin", "php")})
+	}
+	store.pathVersion = store.versions[len(store.versions)-1]
+
+	if err := store.SetDefaultVersion("7.4"); err == nil {
+		t.Error("setting a default version that is not installed should fail")
+	}
+	if err := store.SetDefaultVersion("8.2"); err != nil {
+		t.Fatal(err)
+	}
+	v, source, _, _ := store.fallbackVersion(nil, "")
+	if v.Version != "8.2.1" || source != "global default version" {
+		t.Errorf("the default version should be preferred over the PATH, got %s (%s)", v.Version, source)
+	}
+
+	// persisted in the configuration
+	reloaded := New(dir, false, nil)
+	if reloaded.DefaultVersion() != "8.2" {
+		t.Errorf("the default version should be persisted, got %q", reloaded.DefaultVersion())
+	}
+
+	if err := store.SetDefaultVersion(""); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _, _ := store.fallbackVersion(nil, ""); v.Version != "8.3.2" {
+		t.Errorf("the PATH version should be used without a default version, got %s", v.Version)
+	}
+}
+
+func TestSetVersionForDir(t *testing.T) {
+	dir := t.TempDir()
+	store := New(t.TempDir(), false, nil)
+	store.setVersions(nil)
+	for _, v := range []string{"8.1.14", "8.2.1", "8.3.2"} {
+		store.addVersion(&Version{Version: v, PHPPath: filepath.Join("/foo", v, "bin", "php")})
+	}
+
+	if err := store.SetVersionForDir(dir, "7.4"); err == nil {
+		t.Error("pinning a version that is not installed should fail")
+	}
+	if err := store.SetVersionForDir(dir, "8.2"); err != nil {
+		t.Fatal(err)
+	}
+	v, source, _, err := store.BestVersionForDir(dir)
+	if err != nil || v.Version != "8.2.1" || !strings.Contains(source, ".php-version") {
+		t.Errorf("the pinned version should be used, got %s (%s)", v.Version, source)
+	}
+
+	if err := store.SetVersionForDir(dir, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".php-version")); !os.IsNotExist(err) {
+		t.Error("removing the pin should remove the .php-version file")
+	}
+}
+
+func TestAliases(t *testing.T) {
+	configDir := t.TempDir()
+	root := t.TempDir()
+	store := New(configDir, false, nil)
+	store.setVersions(nil)
+	for _, v := range []string{"8.1.14", "8.1.27", "8.3.2"} {
+		store.addVersion(&Version{Version: v, PHPPath: filepath.Join(root, v, "bin", "php")})
+	}
+	store.addVersion(&Version{Version: "8.3.6", Path: filepath.Join(root, "custom"), PHPPath: filepath.Join(root, "custom", "bin", "php"), FPMPath: filepath.Join(root, "custom", "sbin", "php-fpm")})
+
+	for _, name := range []string{"8.1", "lts-fpm", "my alias", ""} {
+		if err := store.SetAlias(name, "8.1"); err == nil {
+			t.Errorf("alias %q should be invalid", name)
+		}
+	}
+	if err := store.SetAlias("old", "7.4"); err == nil {
+		t.Error("an alias to a version that is not installed should fail")
+	}
+	if err := store.SetAlias("lts", "8.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetAlias("other", "lts"); err == nil {
+		t.Error("an alias to another alias should fail")
+	}
+	if err := store.SetAlias("work", filepath.Join(root, "custom")); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for requirement, expected := range map[string]string{
+		"lts":      "8.1.27",
+		"work":     "8.3.6",
+		"work-fpm": "8.3.6",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, ".php-version"), []byte(requirement+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		v, _, warning, err := store.BestVersionForDir(dir)
+		if err != nil || v.Version != expected || warning != "" {
+			t.Errorf("%s should resolve to %s, got %v (%q, %v)", requirement, expected, v, warning, err)
+		}
+		if ranked := store.BestVersionsForDir(dir); len(ranked) == 0 || ranked[0].Version != expected {
+			t.Errorf("%s should rank %s first", requirement, expected)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".php-version"), []byte("lts-fpm\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, warning, _ := store.BestVersionForDir(dir); !strings.Contains(warning, `"fpm" flavor`) {
+		t.Errorf("the flavor of an alias should be honored, got %q", warning)
+	}
+
+	if !store.IsVersionAvailable("lts") || !store.IsVersionAvailable("work") {
+		t.Error("aliases should be available")
+	}
+	if err := store.SetDefaultVersion("work"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _, _ := store.fallbackVersion(nil, ""); v.Version != "8.3.6" {
+		t.Errorf("the default version should resolve aliases, got %s", v.Version)
+	}
+
+	// persisted in the configuration
+	reloaded := New(configDir, false, nil)
+	if aliases := reloaded.Aliases(); len(aliases) != 2 || aliases["lts"] != "8.1" || aliases["work"] != filepath.Join(root, "custom") {
+		t.Errorf("aliases should be persisted, got %v", aliases)
+	}
+	if err := store.SetAlias("lts", ""); err != nil {
+		t.Fatal(err)
+	}
+	if store.IsVersionAvailable("lts") || len(store.Aliases()) != 1 {
+		t.Errorf("the alias should be removed, got %v", store.Aliases())
+	}
+}
+
+func TestVersionEnv(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("PHP_IDE_CONFIG", "")
+	v := &Version{
+		Version:    "8.3.2",
+		PHPPath:    filepath.Join("/opt", "php", "bin", "php"),
+		IniPath:    filepath.Join("/opt", "php", "etc", "php.ini"),
+		IniScanDir: filepath.Join("/opt", "php", "etc", "conf.d"),
+		Xdebug:     &Xdebug{Enabled: true},
+	}
+	env := strings.Join(v.Env(), "\n")
+	for _, expected := range []string{
+		"=" + filepath.Join("/opt", "php", "bin") + string(os.PathListSeparator) + "/usr/bin",
+		"PHPRC=" + filepath.Join("/opt", "php", "etc"),
+		"PHP_INI_SCAN_DIR=" + filepath.Join("/opt", "php", "etc", "conf.d"),
+		"PHP_IDE_CONFIG=serverName=localhost",
+	} {
+		if !strings.Contains(env, expected) {
+			t.Errorf("the environment should contain %q, got %s", expected, env)
+		}
+	}
+
+	t.Setenv("PHP_IDE_CONFIG", "serverName=app")
+	v.Xdebug = nil
+	if env := strings.Join(v.Env(), "\n"); strings.Contains(env, "PHP_IDE_CONFIG") {
+		t.Errorf("PHP_IDE_CONFIG should only be set when Xdebug is enabled and not configured, got %s", env)
+	}
+}
+
+func TestMarshalReport(t *testing.T) {
+	store := New(t.TempDir(), false, nil)
+	store.setVersions(nil)
+	store.addVersion(&Version{Version: "8.3.2", PHPPath: "/foo/8.3.2/bin/php", Source: "PATH", ThreadSafe: true})
+
+	contents, err := store.MarshalReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		SchemaVersion int                      `json:"schema_version"`
+		Versions      []map[string]interface{} `json:"versions"`
+	}
+	if err := json.Unmarshal(contents, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.SchemaVersion != SchemaVersion || len(report.Versions) != 1 {
+		t.Fatalf("unexpected report %s", contents)
+	}
+	for key, expected := range map[string]interface{}{"version": "8.3.2", "php_path": "/foo/8.3.2/bin/php", "source": "PATH", "thread_safe": true} {
+		if report.Versions[0][key] != expected {
+			t.Errorf("the %q key should be %v, got %v", key, expected, report.Versions[0][key])
+		}
+	}
+}
+
+func TestResolveWindowsBinary(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATHEXT", ".COM;.EXE;.BAT;.CMD")
+	if err := os.MkdirAll(filepath.Join(dir, "php83"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{
+		filepath.Join("php83", "php.exe"): "",
+		"php83.bat":                       "@echo off\r\nrem wrapper.exe\r\n\"%~dp0\\php83\\php.exe\" %*\r\n",
+		"php82.cmd":                       "@echo off\r\nmissing.exe %*\r\n",
+		"php81.exe":                       "",
+		"php81.shim":                      "path = \"" + filepath.Join(dir, "php83", "php.exe") + "\"\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for binName, expected := range map[string]string{
+		"php83":   filepath.Join(dir, "php83", "php.exe"),
+		"php82":   filepath.Join(dir, "php82.cmd"),
+		"php81":   filepath.Join(dir, "php83", "php.exe"),
+		"missing": "",
+	} {
+		if path := resolveWindowsBinary(dir, binName); path != expected {
+			t.Errorf("%s should resolve to %q, got %q", binName, expected, path)
+		}
+	}
+
+	t.Setenv("PATHEXT", ".EXE")
+	if path := resolveWindowsBinary(dir, "php83"); path != "" {
+		t.Errorf("extensions not in PATHEXT should be ignored, got %q", path)
+	}
+}
+
+func TestExpandWindowsVars(t *testing.T) {
+	t.Setenv("LOCALAPPDATA", `C:\Users\fabien\AppData\Local`)
+	for path, expected := range map[string]string{
+		`%LOCALAPPDATA%\Programs\php`:         `C:\Users\fabien\AppData\Local\Programs\php`,
+		`C:\php;%UNDEFINED_PHPSTORE_VAR%\bin`: `C:\php;%UNDEFINED_PHPSTORE_VAR%\bin`,
+		`C:\100%\php`:                         `C:\100%\php`,
+	} {
+		if expanded := expandWindowsVars(path); expanded != expected {
+			t.Errorf("%s should be expanded to %s, got %s", path, expected, expanded)
+		}
+	}
+}
+
+func TestPrereleaseVersions(t *testing.T) {
+	store := New("/dev/null", false, nil)
+	store.setVersions(nil)
+	for _, v := range []string{"8.4.0", "8.4.0RC10", "8.4.0alpha1", "8.4.0RC2", "8.4.0beta3", "8.3.12"} {
+		fv, err := parsePHPVersion(v)
+		if err != nil {
+			t.Fatalf("%s should be parsed: %s", v, err)
+		}
+		store.addVersion(&Version{Version: v, FullVersion: fv, PHPPath: filepath.Join("/foo", v, "bin", "php")})
+	}
+	store.setVersions(store.versions)
+	var sorted []string
+	for _, v := range store.versions {
+		sorted = append(sorted, v.Version)
+	}
+	if strings.Join(sorted, " ") != "8.3.12 8.4.0alpha1 8.4.0beta3 8.4.0RC2 8.4.0RC10 8.4.0" {
+		t.Errorf("pre-releases should be sorted before the release, got %v", sorted)
+	}
+	if v, _, _, _ := store.bestVersion("8.4.0RC2", "testing"); v.Version != "8.4.0RC2" {
+		t.Errorf("pre-releases should be selectable, got %s", v.Version)
+	}
+	if v, _, _, _ := store.bestVersion("8.4", "testing"); v.Version != "8.4.0" {
+		t.Errorf("releases should be preferred over pre-releases, got %s", v.Version)
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	store := New("/dev/null", false, nil)
+	for vernum, expected := range map[string]string{
+		"80312":  "8.3.12",
+		"70433":  "7.4.33",
+		"100001": "10.0.1",
+		"81000":  "8.10.0",
+	} {
+		v, err := store.validateVersion("/foo", vernum)
+		if err != nil {
+			t.Errorf("%s should be parsed: %s", vernum, err)
+			continue
+		}
+		if v.String() != expected {
+			t.Errorf("%s should be parsed as %s, got %s", vernum, expected, v)
+		}
+	}
+	for _, vernum := range []string{"", "8.3", "803", "8x312"} {
+		if _, err := store.validateVersion("/foo", vernum); err == nil {
+			t.Errorf("%q should not be parsed", vernum)
+		}
+	}
+	if normalizeVersion("8.3") != "8.3" {
+		t.Error("incomplete versions should not be normalized")
+	}
+}
+
+func TestSegmentAwarePrefix(t *testing.T) {
+	store := New("/dev/null", false, nil)
+	store.setVersions(nil)
+	// versions are sorted
+	for _, v := range []string{"8.1.2", "8.10.0", "81.0.0"} {
+		store.addVersion(&Version{Version: v, PHPPath: filepath.Join("/foo", v, "bin", "php")})
+	}
+
+	if v, _, _, _ := store.bestVersion("8.1", "testing"); v.Version != "8.1.2" {
+		t.Errorf("8.1 should not match 8.10.0, got %s", v.Version)
+	}
+	if v, _, _, _ := store.bestVersion("8", "testing"); v.Version != "8.10.0" {
+		t.Errorf("8 should not match 81.0.0, got %s", v.Version)
+	}
+	if store.IsVersionAvailable("8.1.0") || store.IsVersionAvailable("1") || !store.IsVersionAvailable("8.10") {
+		t.Error("IsVersionAvailable should match version segments")
+	}
+}
+
+func TestEqualVersionsOrdering(t *testing.T) {
+	vs := versions{
+		{Version: "8.3.2", PHPPath: "/usr/bin/php8.3", Source: "*nix"},
+		{Version: "8.3.2", PHPPath: "/opt/php/bin/php", Source: "registered"},
+		{Version: "8.3.2", PHPPath: "/home/bin/php", Source: "PATH"},
+		{Version: "8.3.2", PHPPath: "/opt/homebrew/bin/php", Source: "homebrew"},
+	}
+	for _, v := range vs {
+		v.FullVersion = v.fullVersion()
+	}
+	for i := 0; i < 3; i++ {
+		// whatever the discovery order
+		vs[0], vs[i+1] = vs[i+1], vs[0]
+		sort.Sort(vs)
+		var paths []string
+		for _, v := range vs {
+			paths = append(paths, v.PHPPath)
+		}
+		if strings.Join(paths, " ") != "/usr/bin/php8.3 /opt/homebrew/bin/php /home/bin/php /opt/php/bin/php" {
+			t.Errorf("equal versions should be sorted by source priority and path, got %v", paths)
+		}
+	}
+}
+
+func TestBetterDuplicate(t *testing.T) {
+	for _, tc := range []struct {
+		first, second *Version
+		secondWins    bool
+	}{
+		{&Version{CGIPath: "php-cgi"}, &Version{FPMPath: "php-fpm"}, true},
+		{&Version{FPMPath: "php-fpm"}, &Version{CGIPath: "php-cgi"}, false},
+		{&Version{FPMPath: "php-fpm"}, &Version{FPMPath: "php-fpm", PHPConfigPath: "php-config"}, true},
+		{&Version{PHPizePath: "phpize"}, &Version{PHPizePath: "phpize", Extensions: []string{"intl"}, IniPath: "php.ini"}, true},
+		{&Version{FPMPath: "php-fpm"}, &Version{FPMPath: "php-fpm"}, false},
+		// a criterion wins over all the next ones
+		{&Version{CGIPath: "php-cgi", LSAPIPath: "lsphp", PHPConfigPath: "php-config", PHPizePath: "phpize", Extensions: []string{"intl"}, Arch: "arm64"}, &Version{FPMPath: "php-fpm"}, true},
+		{&Version{Extensions: []string{"intl"}, IniPath: "php.ini", ExtensionDir: "ext", Arch: "arm64"}, &Version{PHPizePath: "phpize"}, true},
+	} {
+		store := New("/dev/null", false, nil)
+		store.setVersions(nil)
+		tc.first.PHPPath = "/foo/bin/php"
+		tc.second.PHPPath = "/foo/bin/php"
+		store.addVersion(tc.first)
+		store.addVersion(tc.second)
+		expected := tc.first
+		if tc.secondWins {
+			expected = tc.second
+		}
+		if len(store.versions) != 1 || store.versions[0] != expected {
+			t.Errorf("the best duplicate should be kept, expected %+v, got %+v", *expected, *store.versions[0])
+		}
+	}
+}
+
+func TestParseVersionBanner(t *testing.T) {
+	out := "PHP Warning:  Failed loading Zend extension 'xdebug.so' (tried: /usr/lib/php/20230831/xdebug.so (PHP 8.2.0 required)) in Unknown on line 0\n" +
+		"Warning: PHP Startup: Unable to load dynamic library 'redis' in Unknown on line 0\n" +
+		"PHP 8.3.6 (cli) (built: Apr 15 2024 19:21:47) (ZTS DEBUG)\n" +
+		"Copyright (c) The PHP Group\n"
+	version, banner, warnings := parseVersionBanner([]byte(out))
+	if version != "8.3.6" || !strings.Contains(banner, "(ZTS DEBUG)") {
+		t.Errorf("the version should be read from the banner, got %q (%q)", version, banner)
+	}
+	if len(warnings) != 2 || !strings.HasPrefix(warnings[0], "PHP Warning:") || !strings.HasPrefix(warnings[1], "Warning:") {
+		t.Errorf("the startup warnings should be reported, got %q", warnings)
+	}
+	if version, _, _ := parseVersionBanner([]byte("PHP Warning: something\n")); version != "" {
+		t.Errorf("no version should be read without a banner, got %q", version)
+	}
+}
+
+func TestVersionedNames(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"php8":        true,
+		"php83":       true,
+		"php8.3":      true,
+		"php-8.3":     true,
+		"php8.3.6":    true,
+		"php8.4RC1":   true,
+		"php":         false,
+		"php-config8": false,
+		"phpize8.3":   false,
+		"php-fpm8.3":  false,
+		"php8.3.6.1":  false,
+		"php8.3-cgi":  false,
+	} {
+		if phpVersionedName.MatchString(name) != expected {
+			t.Errorf("matching %s should return %v", name, expected)
+		}
+	}
+	if !lsphpVersionedName.MatchString("lsphp83") || lsphpVersionedName.MatchString("php83") {
+		t.Error("the prefix of versioned names should be configurable")
+	}
+}
+
+func TestIsPHPBinaryName(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"php":          true,
+		"php8.3":       true,
+		"php-fpm":      true,
+		"php-fpm8.3":   true,
+		"php-cgi":      true,
+		"PHP.EXE":      true,
+		"frankenphp":   true,
+		"lsphp83":      true,
+		"php-config":   false,
+		"phpize8.3":    false,
+		"python3":      false,
+		"composer":     false,
+		"php8.3.6.1":   false,
+		"php-fpm.conf": false,
+	} {
+		if isPHPBinaryName(name) != expected {
+			t.Errorf("%s being a PHP binary name should be %v", name, expected)
+		}
+	}
+}
+
+func TestFSCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "php")
+	if err := os.WriteFile(path, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cache := newFSCache()
+	if _, err := cache.stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.evalSymlinks(path); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+	if _, err := cache.stat(path); err != nil {
+		t.Errorf("stat results should be memoized, got %s", err)
+	}
+	if _, err := cache.evalSymlinks(path); err != nil {
+		t.Errorf("symlink resolutions should be memoized, got %s", err)
+	}
+	var none *fsCache
+	if _, err := none.stat(path); err == nil {
+		t.Error("a nil cache should not memoize stat results")
+	}
+}
+
+func TestSetServerUsesFSCache(t *testing.T) {
+	dir := t.TempDir()
+	pecl := filepath.Join(dir, "pecl")
+	cache := newFSCache()
+	// memoized as missing, like during a discovery
+	cache.stat(pecl)
+	if err := os.WriteFile(pecl, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	v := &Version{Version: "8.3.9"}
+	v.setServer(cache, "", "", "", pecl, "", "")
+	v.setInstallers(cache, pecl, "", "")
+	if v.PHPConfigPath != "" || v.PECLPath != "" {
+		t.Errorf("lookups should go through the filesystem cache, got %q and %q", v.PHPConfigPath, v.PECLPath)
+	}
+	v.setInstallers(nil, pecl, "", "")
+	if v.PECLPath == "" {
+		t.Error("lookups should hit the filesystem without a cache")
+	}
+}
+
+func TestCachePath(t *testing.T) {
+	configDir := t.TempDir()
+	cache := filepath.Join(t.TempDir(), "custom", "versions.json")
+	writeCache(t, configDir, "8.2.1")
+	store := New(configDir, false, nil, WithCachePath(cache))
+	if len(store.Versions()) != 1 || store.Versions()[0].Version != "8.2.1" {
+		t.Errorf("the cache of the configuration directory should be used, got %v", store.Versions())
+	}
+	if _, err := os.Stat(cache); err != nil {
+		t.Errorf("the cache should be moved to the custom location, got %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(configDir, "php_versions.json")); !os.IsNotExist(err) {
+		t.Error("the cache of the configuration directory should be removed")
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	xdg := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", xdg)
+	store = New(configDir, false, nil)
+	if filepath.Dir(store.cachePath()) != filepath.Join(xdg, "symfony") {
+		t.Errorf("the cache should be in the XDG cache directory, got %s", store.cachePath())
+	}
+	if other := New(t.TempDir(), false, nil); other.cachePath() == store.cachePath() {
+		t.Errorf("stores with different configuration directories should not share their cache, got %s", other.cachePath())
+	}
+	if again := New(configDir, false, nil); again.cachePath() != store.cachePath() {
+		t.Errorf("the cache of a configuration directory should not change, got %s", again.cachePath())
+	}
+	t.Setenv("XDG_CACHE_HOME", "relative")
+	if store := New(configDir, false, nil); store.cachePath() != filepath.Join(configDir, "php_versions.json") {
+		t.Errorf("relative XDG cache directories should be ignored, got %s", store.cachePath())
+	}
+}
+
+func TestDiffVersions(t *testing.T) {
+	stamp := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)
+	previous := []*Version{
+		{Version: "8.1.2", PHPPath: "/usr/bin/php8.1"},
+		{Version: "8.2.1", PHPPath: "/usr/bin/php8.2", BinaryModTime: stamp, BinarySize: 10},
+		{Version: "8.3.1", PHPPath: "/usr/bin/php8.3"},
+		{Version: "8.3.6", PHPPath: "/usr/local/bin/php"},
+	}
+	current := []*Version{
+		{Version: "8.2.1", PHPPath: "/usr/bin/php8.2", BinaryModTime: stamp.Add(time.Hour), BinarySize: 10},
+		{Version: "8.3.2", PHPPath: "/usr/bin/php8.3"},
+		{Version: "8.3.6", PHPPath: "/usr/local/bin/php", BinaryModTime: stamp},
+		{Version: "8.4.1", PHPPath: "/opt/homebrew/bin/php", Source: "homebrew"},
+	}
+	d := DiffVersions(previous, current)
+	expected := strings.Join([]string{
+		"New PHP 8.4.1 detected (Homebrew)",
+		"PHP 8.2.1 changed (/usr/bin/php8.2)",
+		"PHP 8.3.1 upgraded to 8.3.2 (/usr/bin/php8.3)",
+		"PHP 8.1.2 removed (/usr/bin/php8.1)",
+	}, "\n")
+	if d.String() != expected {
+		t.Errorf("unexpected changes:\n%s", d)
+	}
+	if !DiffVersions(current, current).Empty() {
+		t.Error("the same versions should not have changes")
+	}
+}
+
+func TestSupportStatus(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		version, warning string
+		securityOnly     bool
+		eol              bool
+	}{
+		{"5.3.29", "PHP 5.3 is not supported anymore, please upgrade", false, true},
+		{"7.4.33", "PHP 7.4 reached its end of life on 2022-11-28 and does not receive security fixes anymore, please upgrade", false, true},
+		{"8.2.1", "PHP 8.2 only receives security fixes until 2026-12-31", true, false},
+		{"8.4.0RC1", "", false, false},
+		{"9.0.0", "", false, false},
+	} {
+		v := &Version{Version: test.version}
+		v.annotateSupport(now)
+		if v.SecurityOnly != test.securityOnly || v.EOL != test.eol || v.SupportWarning() != test.warning {
+			t.Errorf("unexpected support of %s: security only %v, EOL %v, %q", test.version, v.SecurityOnly, v.EOL, v.SupportWarning())
+		}
+	}
+
+	// support ends at the end of the last day
+	v := &Version{Version: "8.1.2"}
+	v.annotateSupport(time.Date(2025, 12, 31, 23, 0, 0, 0, time.UTC))
+	if v.EOL || !v.SecurityOnly || v.SecuritySupportUntil.Format("2006-01-02") != "2025-12-31" {
+		t.Errorf("PHP 8.1 should be supported until the end of 2025")
+	}
+}
+
+func TestOutdatedPatchCheck(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"8": {"8.2": {"version": "8.2.24", "date": "26 Sep 2024"}, "8.3": {"version": "8.3.12"}}}`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".php-version"), []byte("8.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configDir := t.TempDir()
+	newStore := func(version string) *PHPStore {
+		store := New(configDir, false, nil, WithOutdatedPatchCheck(server.URL))
+		store.setVersions(nil)
+		store.addVersion(&Version{Version: version, PHPPath: filepath.Join("/foo", version, "bin", "php")})
+		return store
+	}
+
+	_, _, warnings, _ := newStore("8.2.1").BestVersionForDirWithWarnings(dir)
+	if len(warnings) != 1 || warnings[0].Kind != WarningOutdatedPatch || warnings[0].Latest != "8.2.24" {
+		t.Fatalf("an outdated patch warning should be returned, got %v", warnings)
+	}
+	if warnings.String() != "PHP 8.2.1 is outdated, the latest patch version is 8.2.24" {
+		t.Errorf("unexpected warning %q", warnings)
+	}
+	if _, _, warnings, _ := newStore("8.2.24").BestVersionForDirWithWarnings(dir); len(warnings) != 0 {
+		t.Errorf("the latest patch version should not have warnings, got %v", warnings)
+	}
+	if requests != 1 {
+		t.Errorf("releases should be cached, got %d requests", requests)
+	}
+
+	store := New(t.TempDir(), false, nil)
+	store.setVersions(nil)
+	store.addVersion(&Version{Version: "8.2.1", PHPPath: "/foo/8.2.1/bin/php"})
+	if _, _, warnings, _ := store.BestVersionForDirWithWarnings(dir); len(warnings) != 0 {
+		t.Errorf("the check should be disabled by default, got %v", warnings)
+	}
+}
+
+func TestInstallable(t *testing.T) {
+	t.Setenv("PATH", "")
+	store := New(t.TempDir(), false, nil, WithStrict())
+	store.setVersions(nil)
+	store.addVersion(&Version{Version: "8.3.2", PHPPath: "/foo/8.3.2/bin/php"})
+
+	installables := store.Installable("^8.1 <8.3")
+	if len(installables) != 2 || installables[0].Version != "8.2" || installables[0].Channel != ChannelStaticPHPCLI || installables[1].Channel != ChannelPHPNet {
+		t.Errorf("8.2 should be installable from static-php-cli and php.net, got %v", installables)
+	}
+	if installables := store.Installable("7.4.3"); len(installables) != 1 || installables[0].Version != "7.4" || installables[0].Channel != ChannelPHPNet {
+		t.Errorf("7.4 should only be installable from php.net, got %v", installables)
+	}
+	if installables := store.Installable("12.0"); installables != nil {
+		t.Errorf("unknown versions should not be installable, got %v", installables)
+	}
+
+	_, _, _, err := store.bestVersion("8.2", "testing")
+	var unsatisfied *UnsatisfiedVersionError
+	if !errors.As(err, &unsatisfied) || unsatisfied.Suggestion == nil || unsatisfied.Suggestion.Version != "8.2" {
+		t.Fatalf("the error should suggest how to install PHP 8.2, got %v", err)
+	}
+	if !strings.HasSuffix(err.Error(), "but this version is not available: PHP 8.2 is available as a static build (https://dl.static-php.dev/static-php-cli/common/)") {
+		t.Errorf("unexpected error %q", err)
+	}
+}
+
+func TestExtractFileOutsideDir(t *testing.T) {
+	if err := extractFile(strings.NewReader(""), t.TempDir(), "../php", 0755); err == nil {
+		t.Error("files outside of the directory should be rejected")
+	}
+}
+
+func TestSetComposerPlatformVersion(t *testing.T) {
+	dir := t.TempDir()
+	store := New(t.TempDir(), false, nil)
+	store.setVersions(nil)
+	for _, v := range []string{"8.2.1", "8.2.12", "8.3.2"} {
+		store.addVersion(&Version{Version: v, PHPPath: filepath.Join("/foo", v, "bin", "php")})
+	}
+	v := &Version{Version: "8.2.1"}
+	if v.ComposerPlatformVersion() != "8.2.99" {
+		t.Errorf("unexpected platform version %q", v.ComposerPlatformVersion())
+	}
+
+	if err := store.SetComposerPlatformVersion(dir, v); err == nil {
+		t.Error("a missing composer.json should be reported")
+	}
+	composer := "{\n  \"require\": {\n    \"php\": \">=8.1\",\n    \"symfony/console\": \"^7.1\"\n  },\n  \"config\": {\n    \"sort-packages\": true\n  },\n  \"scripts\": {\n    \"test\": \"phpunit && phpstan\"\n  }\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "composer.json"), []byte(composer), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(dir, "src")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetComposerPlatformVersion(sub, v); err != nil {
+		t.Fatal(err)
+	}
+	contents, _ := os.ReadFile(filepath.Join(dir, "composer.json"))
+	expected := "{\n  \"require\": {\n    \"php\": \">=8.1\",\n    \"symfony/console\": \"^7.1\"\n  },\n  \"config\": {\n    \"sort-packages\": true,\n    \"platform\": {\n      \"php\": \"8.2.99\"\n    }\n  },\n  \"scripts\": {\n    \"test\": \"phpunit && phpstan\"\n  }\n}\n"
+	if string(contents) != expected {
+		t.Errorf("unexpected composer.json:\n%s", contents)
+	}
+	if v, _, warning, err := store.BestVersionForDir(dir); err != nil || v.Version != "8.2.12" || warning != "" {
+		t.Errorf("the platform version should select the latest patch version, got %v (%q)", v, warning)
+	}
+}
+
+func TestWriteProjectVersion(t *testing.T) {
+	dir := t.TempDir()
+	store := New(t.TempDir(), false, nil)
+	v := &Version{Version: "8.3.8", PHPPath: "/foo/8.3.8/bin/php", FPMPath: "/foo/8.3.8/sbin/php-fpm"}
+
+	for _, test := range []struct {
+		opts     ProjectVersionOptions
+		existing string
+		expected string
+	}{
+		{ProjectVersionOptions{}, "", "8.3\n"},
+		{ProjectVersionOptions{Patch: true}, "8.2\n\n", "8.3.8\n"},
+		{ProjectVersionOptions{Flavor: FlavorFPM}, "8.2\r\n", "8.3-fpm\r\n"},
+	} {
+		file := filepath.Join(dir, ".php-version")
+		os.Remove(file)
+		if test.existing != "" {
+			if err := os.WriteFile(file, []byte(test.existing), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		written, err := store.WriteProjectVersion(dir, v, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contents, _ := os.ReadFile(written); written != file || string(contents) != test.expected {
+			t.Errorf("expected %q in %s, got %q in %s", test.expected, file, contents, written)
+		}
+	}
+	if _, err := store.WriteProjectVersion(dir, v, ProjectVersionOptions{Flavor: FlavorCGI}); err == nil {
+		t.Error("unsupported flavors should be rejected")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "composer.json"), []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	written, err := store.WriteProjectVersion(dir, v, ProjectVersionOptions{Composer: true, Patch: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents, _ := os.ReadFile(written); string(contents) != "{\n    \"config\": {\n        \"platform\": {\n            \"php\": \"8.3.8\"\n        }\n    }\n}\n" {
+		t.Errorf("unexpected composer.json:\n%s", contents)
+	}
+}
+
+func TestParseWindowsBuildName(t *testing.T) {
+	for name, expected := range map[string]*windowsBuild{
+		"php-8.3.8-nts-Win32-vs16-x64":    {version: "8.3.8", compiler: "vs16", arch: "x86_64"},
+		"php-8.4.0RC1-Win32-vs17-x86":     {version: "8.4.0RC1", threadSafe: true, compiler: "vs17", arch: "i386"},
+		"PHP-7.4.33-NTS-WIN32-VC15-X64":   {version: "7.4.33", compiler: "vc15", arch: "x86_64"},
+		"php":                             nil,
+		"php-8.3.8-nts-Win32-vs16-x64-v2": nil,
+	} {
+		if build := parseWindowsBuildName(name); (build == nil) != (expected == nil) || build != nil && *build != *expected {
+			t.Errorf("unexpected metadata for %s: %+v", name, build)
+		}
+	}
+	if compiler := bannerCompiler("PHP 8.3.4 (cli) (built: Mar 12 2024 23:42:26) (ZTS Visual C++ 2019 x64)"); compiler != "vs16" {
+		t.Errorf("the compiler should be read from the banner, got %q", compiler)
+	}
+}
+
+func TestBinaryCompiler(t *testing.T) {
+	dir := t.TempDir()
+	for minor, expected := range map[uint8]string{0: "vc14", 16: "vc15", 29: "vs16", 38: "vs17"} {
+		var buf bytes.Buffer
+		buf.WriteString("MZ")
+		buf.Write(make([]byte, 0x3a))
+		binary.Write(&buf, binary.LittleEndian, uint32(0x40))
+		buf.WriteString("PE\x00\x00")
+		binary.Write(&buf, binary.LittleEndian, pe.FileHeader{Machine: pe.IMAGE_FILE_MACHINE_AMD64, SizeOfOptionalHeader: 240})
+		binary.Write(&buf, binary.LittleEndian, pe.OptionalHeader64{Magic: 0x20b, MajorLinkerVersion: 14, MinorLinkerVersion: minor, NumberOfRvaAndSizes: 16})
+		path := filepath.Join(dir, "php.exe")
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if compiler := binaryCompiler(path); compiler != expected {
+			t.Errorf("expected %s for linker 14.%d, got %q", expected, minor, compiler)
+		}
+	}
+
+	problem := &Problem{Kind: ProblemRuntimeMissing, Path: `C:\php\php.exe`, Expected: "Visual C++ 2022 (x64)", Actual: "14.29"}
+	if problem.String() != `C:\php\php.exe requires the Visual C++ 2022 (x64) runtime, but version 14.29 is installed` {
+		t.Errorf("unexpected problem %q", problem)
+	}
+	if runtime.GOOS != "windows" && (&Version{Compiler: "vs17"}).runtimeProblem() != nil {
+		t.Error("the runtime should only be checked on Windows")
+	}
+}
+
+func TestPHPBrew(t *testing.T) {
+	// the real ~/.phpbrew must not be used
+	homedir.DisableCache = true
+	t.Cleanup(func() { homedir.DisableCache = false })
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	root := t.TempDir()
+	t.Setenv("PHPBREW_ROOT", root)
+	t.Setenv("PHPBREW_HOME", "")
+	t.Setenv("PHPBREW_PHP", "")
+	builds := filepath.Join(root, "php")
+
+	if variants := strings.Join(phpbrewVariants(filepath.Join(builds, "php-8.2.0+fpm+zts")), " "); variants != "fpm zts" {
+		t.Errorf("the variants should be parsed from the build name, got %q", variants)
+	}
+	if variants := phpbrewVariants(filepath.Join("/foo", "php-8.2.0+fpm")); variants != nil {
+		t.Errorf("only phpbrew builds should have variants, got %v", variants)
+	}
+
+	newStore := func() *PHPStore {
+		store := New(t.TempDir(), false, nil)
+		for name, version := range map[string]string{
+			"php-8.2.0+fpm+zts+debug": "8.2.0",
+			"php-8.3.1":               "8.3.1",
+			"legacy":                  "7.4.33",
+		} {
+			v := &Version{Version: version, Path: filepath.Join(builds, name), PHPPath: filepath.Join(builds, name, "bin", "php")}
+			v.applyPHPBrewVariants()
+			store.addVersion(v)
+		}
+		return store
+	}
+	store := newStore()
+	if v := store.versionAtPath(filepath.Join(builds, "php-8.2.0+fpm+zts+debug")); v == nil || !v.ThreadSafe || !v.DebugBuild {
+		t.Errorf("the zts and debug variants should set the build type, got %v", v)
+	}
+
+	dir := t.TempDir()
+	initFile := "export PHPBREW_PHP=php-8.2.0+fpm+zts+debug\nexport PHPBREW_PATH=/foo\n"
+	if err := os.WriteFile(filepath.Join(root, "init"), []byte(initFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v, source, _, _ := store.BestVersionForDir(dir); v == nil || v.Version != "8.2.0" || source != "phpbrew current version" {
+		t.Errorf("the version switched to should be preferred, got %v (%s)", v, source)
+	}
+	t.Setenv("PHPBREW_PHP", "legacy")
+	store.resolutions.reset()
+	if v, _, _, _ := store.BestVersionForDir(dir); v == nil || v.Version != "8.2.0" {
+		t.Errorf("the current phpbrew version should be read once per store, got %v", v)
+	}
+	if v, _, _, _ := newStore().BestVersionForDir(dir); v == nil || v.Version != "7.4.33" {
+		t.Errorf("the version used in the shell should be preferred, got %v", v)
+	}
+
+	// phpbrew is not set up in the shell: its init file is stale
+	t.Setenv("PHPBREW_PHP", "")
+	t.Setenv("PHPBREW_ROOT", "")
+	if err := os.MkdirAll(filepath.Join(home, ".phpbrew"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".phpbrew", "init"), []byte(initFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if current := readPHPBrewCurrent(); current != "" {
+		t.Errorf("the init file should be ignored when phpbrew is not set up, got %q", current)
+	}
+	t.Setenv("PHPBREW_HOME", home)
+	if current := readPHPBrewCurrent(); current != filepath.Join(home, ".phpbrew", "php", "php-8.2.0+fpm+zts+debug") {
+		t.Errorf("the init file should be read when phpbrew is set up, got %q", current)
+	}
+	t.Setenv("PHPBREW_ROOT", root)
+
+	if err := os.WriteFile(filepath.Join(dir, ".php-version"), []byte("legacy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _, _ := newStore().BestVersionForDir(dir); v == nil || v.Version != "7.4.33" {
+		t.Errorf("custom build names should be aliases, got %v", v)
+	}
+	if _, path := store.resolveAlias("php-8.3.1"); path != "" {
+		t.Errorf("build names with a version should not be aliases, got %q", path)
+	}
+}
+
+func TestRegisterContainerName(t *testing.T) {
+	configDir := t.TempDir()
+	store := New(configDir, false, nil)
+	for _, name := range []string{"", "../../..", "php\ntouch /tmp/pwned", "-e", ".hidden"} {
+		if _, err := store.RegisterContainer(context.Background(), ContainerPodman, name); err == nil || !strings.Contains(err.Error(), "invalid container name") {
+			t.Errorf("the container name %q should be rejected, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(configDir, "containers")); !os.IsNotExist(err) {
+		t.Errorf("nothing should be written for invalid container names, got %v", err)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	configDir := t.TempDir()
+	key := New(configDir, false, nil).loadKey()
+	if New(configDir, false, nil).loadKey() != key {
+		t.Error("stores with the same configuration should share their loads")
+	}
+	for _, opts := range [][]Option{
+		{WithSources(SourcePHPVersion)},
+		{WithExcludedPaths("/opt/*")},
+		{WithNetworkFilesystems()},
+		{WithMemoryCache()},
+	} {
+		if New(configDir, false, nil, opts...).loadKey() == key {
+			t.Errorf("stores with different options should not share their loads (%d options)", len(opts))
+		}
+	}
+	store := New(configDir, false, nil)
+	store.ignoredPaths = []string{"/opt/php"}
+	if store.loadKey() == key {
+		t.Error("stores ignoring different paths should not share their loads")
+	}
+}
+
+func TestRequirementMatcher(t *testing.T) {
+	for _, test := range []struct {
+		requirement string
+		version     string
+		ok, exact   bool
+	}{
+		{"8.3", "8.3.12", true, false},
+		{"8.3", "8.30.1", false, false},
+		{"8.3.12", "8.3.12", true, true},
+		{"8.3.12", "8.3.4", true, false},
+		{"8.3.99", "8.3.4", true, false},
+		{"8.3.99-fpm", "8.3.4", true, false},
+		{"^8.2", "8.3.4", true, false},
+		{"^8.2", "7.4.33", false, false},
+		{">=8.1 <", "8.3.4", false, false},
+	} {
+		v := &Version{Version: test.version}
+		if ok, exact := newRequirementMatcher(test.requirement).match(v); ok != test.ok || exact != test.exact {
+			t.Errorf("%s matching %s: expected %t (exact: %t), got %t (exact: %t)", test.version, test.requirement, test.ok, test.exact, ok, exact)
+		}
+	}
+}
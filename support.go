@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"fmt"
+	"time"
+)
+
+// releaseCycle is the support period of a minor version of PHP
+type releaseCycle struct {
+	activeUntil   string
+	securityUntil string
+}
+
+// releaseCalendar is the PHP release calendar, by minor version (see
+// https://www.php.net/supported-versions.php and https://www.php.net/eol.php);
+// versions older than the oldest one are not supported anymore
+var releaseCalendar = map[string]releaseCycle{
+	"5.6": {"2017-01-19", "2018-12-31"},
+	"7.0": {"2017-12-03", "2019-01-10"},
+	"7.1": {"2018-12-01", "2019-12-01"},
+	"7.2": {"2019-11-30", "2020-11-30"},
+	"7.3": {"2020-12-06", "2021-12-06"},
+	"7.4": {"2021-11-28", "2022-11-28"},
+	"8.0": {"2022-11-26", "2023-11-26"},
+	"8.1": {"2023-11-25", "2025-12-31"},
+	"8.2": {"2024-12-31", "2026-12-31"},
+	"8.3": {"2025-12-31", "2027-12-31"},
+	"8.4": {"2026-12-31", "2028-12-31"},
+	"8.5": {"2027-12-31", "2029-12-31"},
+}
+
+// annotateSupport sets the support dates and status of the version at the
+// given time (see releaseCalendar)
+func (v *Version) annotateSupport(now time.Time) {
+	v.ActiveSupportUntil, v.SecuritySupportUntil = time.Time{}, time.Time{}
+	v.SecurityOnly, v.EOL = false, false
+	fv := v.fullVersion()
+	if fv == nil {
+		return
+	}
+	cycle, ok := releaseCalendar[v.minorVersion()]
+	if !ok {
+		// newer versions are not in the calendar yet
+		segments := fv.Segments()
+		v.EOL = segments[0] < 5 || segments[0] == 5 && segments[1] < 6
+		return
+	}
+	v.ActiveSupportUntil = supportDate(cycle.activeUntil)
+	v.SecuritySupportUntil = supportDate(cycle.securityUntil)
+	// support ends at the end of the last day
+	v.EOL = !now.Before(v.SecuritySupportUntil.AddDate(0, 0, 1))
+	v.SecurityOnly = !v.EOL && !now.Before(v.ActiveSupportUntil.AddDate(0, 0, 1))
+}
+
+// SupportWarning returns a message about the support of the version when it
+// is end of life or only receives security fixes, or an empty string
+func (v *Version) SupportWarning() string {
+	switch {
+	case v.EOL && v.SecuritySupportUntil.IsZero():
+		return fmt.Sprintf("PHP %s is not supported anymore, please upgrade", v.minorVersion())
+	case v.EOL:
+		return fmt.Sprintf("PHP %s reached its end of life on %s and does not receive security fixes anymore, please upgrade", v.minorVersion(), v.SecuritySupportUntil.Format("2006-01-02"))
+	case v.SecurityOnly:
+		return fmt.Sprintf("PHP %s only receives security fixes until %s", v.minorVersion(), v.SecuritySupportUntil.Format("2006-01-02"))
+	}
+	return ""
+}
+
+// minorVersion returns the minor version (like 8.3), or an empty string
+func (v *Version) minorVersion() string {
+	fv := v.fullVersion()
+	if fv == nil {
+		return ""
+	}
+	segments := fv.Segments()
+	return fmt.Sprintf("%d.%d", segments[0], segments[1])
+}
+
+func supportDate(date string) time.Time {
+	t, _ := time.Parse("2006-01-02", date)
+	return t
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Unregister removes the PHP installation at the given path (a PHP binary,
+// its bin/ directory, or the installation directory) from the store: it is
+// removed from the registered paths (see RegisterPath) and from the cache.
+// When uninstall is true, builds downloaded by Install and the wrappers of
+// containers (see RegisterContainer) are deleted as well; otherwise they can
+// be registered again later. Versions found by discovery
+// cannot be unregistered as they would be found again, use IgnorePath instead.
+func (s *PHPStore) Unregister(path string, uninstall bool) (*Version, error) {
+	s.load()
+	path, err := expandPath(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.versionAtPath(path)
+	if v == nil {
+		return nil, errors.Errorf("no PHP installation found at %s", path)
+	}
+	var registered []string
+	for _, p := range s.registeredPaths {
+		if s.versionAtPath(p) == v {
+			registered = append(registered, p)
+		}
+	}
+	installed := strings.HasPrefix(v.Path, s.installDir()+string(filepath.Separator)) ||
+		strings.HasPrefix(v.Path, filepath.Join(s.configDir, "containers")+string(filepath.Separator))
+	if len(registered) == 0 && !installed {
+		return nil, errors.Errorf("%s was discovered (%s), it cannot be unregistered but it can be ignored", v.binary(), v.Source)
+	}
+
+	if len(registered) > 0 {
+		if err := s.updateConfig(func(c *config) {
+			c.Paths = removePaths(expandPaths(c.Paths), registered)
+		}); err != nil {
+			return nil, err
+		}
+		s.registeredPaths = removePaths(s.registeredPaths, registered)
+	}
+	if installed && uninstall {
+		// moved first so that a failed removal does not leave a broken build
+		tmp := v.Path + ".uninstall"
+		if err := os.Rename(v.Path, tmp); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := os.RemoveAll(tmp); err != nil {
+			s.log("Unable to remove %s: %s", tmp, err)
+		}
+	}
+
+	vs := versions{}
+	for _, version := range s.versions {
+		if version != v {
+			vs = append(vs, version)
+		}
+	}
+	s.setVersions(vs)
+	s.writeCache()
+	return v, nil
+}
+
+// removePaths returns the paths without the removed ones
+func removePaths(paths, removed []string) []string {
+	var kept []string
+	for _, p := range paths {
+		keep := true
+		for _, r := range removed {
+			if p == r {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
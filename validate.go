@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ProblemKind identifies the reason of a Problem
+type ProblemKind string
+
+const (
+	// ProblemBinaryMissing means that the PHP binary does not exist anymore
+	ProblemBinaryMissing ProblemKind = "binary_missing"
+	// ProblemBinaryNotRunnable means that the PHP binary cannot be run
+	ProblemBinaryNotRunnable ProblemKind = "binary_not_runnable"
+	// ProblemVersionMismatch means that the PHP binary reports another
+	// version (like after an in-place upgrade)
+	ProblemVersionMismatch ProblemKind = "version_mismatch"
+	// ProblemCompanionMissing means that a companion binary (like php-fpm or
+	// php-config) does not exist anymore
+	ProblemCompanionMissing ProblemKind = "companion_missing"
+	// ProblemCompanionMismatch means that a server binary (php-fpm, php-cgi,
+	// or lsphp) reports another version than the PHP binary
+	ProblemCompanionMismatch ProblemKind = "companion_mismatch"
+	// ProblemDirUnreadable means that a directory cannot be scanned during
+	// discovery (like a permission error)
+	ProblemDirUnreadable ProblemKind = "dir_unreadable"
+	// ProblemProbeFailed means that a binary found during discovery cannot
+	// be used (like a broken build or a binary that is not PHP)
+	ProblemProbeFailed ProblemKind = "probe_failed"
+	// ProblemShimUnresolved means that a version manager shim found in the
+	// PATH cannot be resolved to an installation
+	ProblemShimUnresolved ProblemKind = "shim_unresolved"
+	// ProblemStartupWarning means that a binary displays warnings when
+	// started (like an extension that cannot be loaded)
+	ProblemStartupWarning ProblemKind = "startup_warning"
+	// ProblemRuntimeMissing means that the Visual C++ runtime required by a
+	// Windows build is not installed (or is too old), so that it cannot run
+	ProblemRuntimeMissing ProblemKind = "runtime_missing"
+)
+
+// Problem describes why an installed version is not usable as discovered
+type Problem struct {
+	Kind ProblemKind
+	// Path is the binary (or directory) having the problem
+	Path string
+	// Source is the discovery source (discovery problems only)
+	Source string
+	// Expected is the discovered version, and Actual the one reported by
+	// the binary (ProblemVersionMismatch and ProblemCompanionMismatch); for
+	// ProblemRuntimeMissing, Expected is the required runtime and Actual the
+	// installed one, if any
+	Expected string
+	Actual   string
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (p *Problem) String() string {
+	switch p.Kind {
+	case ProblemBinaryMissing:
+		return fmt.Sprintf("%s does not exist anymore", p.Path)
+	case ProblemBinaryNotRunnable:
+		return fmt.Sprintf("%s cannot be run: %s", p.Path, p.Err)
+	case ProblemVersionMismatch:
+		return fmt.Sprintf("%s is now PHP %s instead of %s", p.Path, p.Actual, p.Expected)
+	case ProblemCompanionMismatch:
+		return fmt.Sprintf("%s is PHP %s instead of %s", p.Path, p.Actual, p.Expected)
+	case ProblemDirUnreadable:
+		return fmt.Sprintf("%s cannot be scanned (%s): %s", p.Path, p.Source, p.Err)
+	case ProblemProbeFailed:
+		return fmt.Sprintf("%s cannot be used (%s): %s", p.Path, p.Source, p.Err)
+	case ProblemShimUnresolved:
+		return fmt.Sprintf("%s cannot be resolved (%s): %s", p.Path, p.Source, p.Err)
+	case ProblemStartupWarning:
+		return fmt.Sprintf("%s displays a warning on startup (%s): %s", p.Path, p.Source, p.Err)
+	case ProblemRuntimeMissing:
+		if p.Actual != "" {
+			return fmt.Sprintf("%s requires the %s runtime, but version %s is installed", p.Path, p.Expected, p.Actual)
+		}
+		return fmt.Sprintf("%s requires the %s runtime, which is not installed", p.Path, p.Expected)
+	default:
+		return fmt.Sprintf("%s does not exist anymore", p.Path)
+	}
+}
+
+// Problems is a list of problems
+type Problems []*Problem
+
+func (ps Problems) String() string {
+	messages := make([]string, len(ps))
+	for i, p := range ps {
+		messages[i] = p.String()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate checks that the version is still usable as discovered: the PHP
+// binary is run again to check its version, and the companion binaries are
+// checked as well. It returns nil when no problems are found.
+func (v *Version) Validate() Problems {
+	if _, err := os.Stat(v.binary()); err != nil {
+		return Problems{{Kind: ProblemBinaryMissing, Path: v.binary()}}
+	}
+
+	var problems Problems
+	// reported first as PHP cannot run without it
+	if problem := v.runtimeProblem(); problem != nil {
+		problems = append(problems, problem)
+	}
+	args := []string{"-v"}
+	if v.FrankenPHP {
+		args = append([]string{"php-cli"}, args...)
+	}
+	cmd, cancel := probeCommand(v.binary(), args...)
+	defer cancel()
+	out, err := cmd.Output()
+	if err != nil {
+		problems = append(problems, &Problem{Kind: ProblemBinaryNotRunnable, Path: v.binary(), Err: err})
+	} else if actual, _, _ := parseVersionBanner(out); !v.sameVersion(actual) {
+		actual, _ = splitVersionSuffix(actual)
+		problems = append(problems, &Problem{Kind: ProblemVersionMismatch, Path: v.binary(), Expected: v.Version, Actual: actual})
+	}
+
+	for _, path := range v.missingCompanions() {
+		problems = append(problems, &Problem{Kind: ProblemCompanionMissing, Path: path})
+	}
+	for _, path := range []string{v.FPMPath, v.CGIPath, v.LSAPIPath} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if cv := companionVersion(path); !v.sameVersion(cv) {
+			problems = append(problems, &Problem{Kind: ProblemCompanionMismatch, Path: path, Expected: v.Version, Actual: cv})
+		}
+	}
+	return problems
+}
+
+// missingCompanions returns the companion binaries that do not exist anymore
+func (v *Version) missingCompanions() []string {
+	var missing []string
+	for _, path := range []string{v.FPMPath, v.CGIPath, v.LSAPIPath, v.PHPConfigPath, v.PHPizePath, v.PHPdbgPath} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			missing = append(missing, path)
+		}
+	}
+	return missing
+}
+
+// Problems returns the non-fatal problems of the last discovery, like
+// directories that cannot be scanned or binaries that cannot be used; it is
+// empty when versions were loaded from the cache
+func (s *PHPStore) Problems() Problems {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.problems
+}
+
+// walkProblem records the failure to access a path while scanning a
+// directory; missing paths are expected and ignored
+func (s *PHPStore) walkProblem(path, source string, err error) {
+	if os.IsNotExist(err) {
+		return
+	}
+	s.problems = append(s.problems, &Problem{Kind: ProblemDirUnreadable, Path: path, Source: source, Err: err})
+}
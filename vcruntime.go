@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"debug/pe"
+	"fmt"
+	"runtime"
+)
+
+// vcRuntime is the Visual C++ runtime required by a compiler of Windows
+// builds; the 2015 to 2022 runtimes share the same major version (14), a
+// runtime supports the builds of older compilers
+type vcRuntime struct {
+	name  string
+	minor int
+}
+
+var vcRuntimes = map[string]vcRuntime{
+	"vc14": {"Visual C++ 2015", 0},
+	"vc15": {"Visual C++ 2017", 10},
+	"vs16": {"Visual C++ 2019", 20},
+	"vs17": {"Visual C++ 2022", 30},
+}
+
+// binaryCompiler returns the compiler of a Windows executable from the
+// version of its linker (like vs16 for 14.29), or an empty string
+func binaryCompiler(path string) string {
+	f, err := pe.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var major, minor uint8
+	switch h := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader64:
+		major, minor = h.MajorLinkerVersion, h.MinorLinkerVersion
+	case *pe.OptionalHeader32:
+		major, minor = h.MajorLinkerVersion, h.MinorLinkerVersion
+	default:
+		return ""
+	}
+	if major != 14 {
+		return ""
+	}
+	switch {
+	case minor < 10:
+		return "vc14"
+	case minor < 20:
+		return "vc15"
+	case minor < 30:
+		return "vs16"
+	}
+	return "vs17"
+}
+
+// runtimeProblem returns a ProblemRuntimeMissing problem when the Visual C++
+// runtime required by a Windows build is not installed, or nil
+func (v *Version) runtimeProblem() *Problem {
+	required, ok := vcRuntimes[v.Compiler]
+	if runtime.GOOS != "windows" || v.Remote || !ok {
+		return nil
+	}
+	arch := "x64"
+	switch v.Arch {
+	case "i386":
+		arch = "x86"
+	case "arm64":
+		arch = "arm64"
+	}
+	expected := fmt.Sprintf("%s (%s)", required.name, arch)
+	major, minor, installed := vcRuntimeVersion(arch)
+	if !installed {
+		return &Problem{Kind: ProblemRuntimeMissing, Path: v.binary(), Expected: expected}
+	}
+	if major != 14 || minor < required.minor {
+		return &Problem{Kind: ProblemRuntimeMissing, Path: v.binary(), Expected: expected, Actual: fmt.Sprintf("%d.%d", major, minor)}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+//go:build windows
+// +build windows
+
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// vcRuntimeVersion returns the version of the Visual C++ 2015-2022 runtime
+// installed for the given architecture (x64, x86, or arm64), as registered by
+// its installer, and whether it is installed
+func vcRuntimeVersion(arch string) (int, int, bool) {
+	path, err := syscall.UTF16PtrFromString(`SOFTWARE\Microsoft\VisualStudio\14.0\VC\Runtimes\` + arch)
+	if err != nil {
+		return 0, 0, false
+	}
+	var key syscall.Handle
+	// the installers write to the 32-bit view of the registry
+	if err := syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, path, 0, syscall.KEY_READ|syscall.KEY_WOW64_32KEY, &key); err != nil {
+		return 0, 0, false
+	}
+	defer syscall.RegCloseKey(key)
+	if registryDWORD(key, "Installed") != 1 {
+		return 0, 0, false
+	}
+	return int(registryDWORD(key, "Major")), int(registryDWORD(key, "Minor")), true
+}
+
+// registryDWORD returns a DWORD value of a registry key, or 0
+func registryDWORD(key syscall.Handle, name string) uint32 {
+	n, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return 0
+	}
+	var value, typ uint32
+	size := uint32(unsafe.Sizeof(value))
+	if err := syscall.RegQueryValueEx(key, n, nil, &typ, (*byte)(unsafe.Pointer(&value)), &size); err != nil || typ != syscall.REG_DWORD {
+		return 0
+	}
+	return value
+}
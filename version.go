@@ -23,10 +23,25 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/go-version"
 )
 
+// Flavors that can be requested by suffixing a version requirement (like 8.3-fpm)
+const (
+	FlavorCLI        = "cli"
+	FlavorCGI        = "cgi"
+	FlavorFPM        = "fpm"
+	FlavorFrankenPHP = "frankenphp"
+	FlavorLSAPI      = "lsapi"
+)
+
+var flavors = []string{FlavorCLI, FlavorCGI, FlavorFPM, FlavorFrankenPHP, FlavorLSAPI}
+
 type serverType int
 
 const (
@@ -34,6 +49,7 @@ const (
 	cgiServer
 	cliServer
 	frankenphpServer
+	lsapiServer
 )
 
 // Version stores information about an installed PHP version
@@ -44,18 +60,289 @@ type Version struct {
 	PHPPath       string           `json:"php_path"`
 	FPMPath       string           `json:"fpm_path"`
 	CGIPath       string           `json:"cgi_path"`
+	LSAPIPath     string           `json:"lsapi_path,omitempty"`
 	PHPConfigPath string           `json:"php_config_path"`
 	PHPizePath    string           `json:"phpize_path"`
 	PHPdbgPath    string           `json:"phpdbg_path"`
+	PECLPath      string           `json:"pecl_path,omitempty"`
+	PEARPath      string           `json:"pear_path,omitempty"`
+	PIEPath       string           `json:"pie_path,omitempty"`
 	IsSystem      bool             `json:"is_system"`
 	FrankenPHP    bool             `json:"frankenphp"`
+	Extensions    []string         `json:"extensions,omitempty"`
+	// Source is the discovery source of the version (like homebrew, phpenv,
+	// PATH, XAMPP, or registered for versions added with RegisterPath)
+	Source string `json:"source,omitempty"`
+	// VersionSuffix is the suffix of the version added by distributions or
+	// development builds (like -1ubuntu1 or -dev); it is ignored when
+	// selecting versions
+	VersionSuffix string `json:"version_suffix,omitempty"`
+	// Remote is true for versions installed on Host (see DiscoverRemote);
+	// their paths are the ones of the remote host
+	Remote bool   `json:"remote,omitempty"`
+	Host   string `json:"host,omitempty"`
+	// Container is the container the version runs in, and ContainerRuntime
+	// the tool running it (see RegisterContainer); the binaries are wrapper
+	// scripts on the host
+	Container        string `json:"container,omitempty"`
+	ContainerRuntime string `json:"container_runtime,omitempty"`
+	// EmbedPath is the embed SAPI library (built with --enable-embed)
+	EmbedPath string `json:"embed_path,omitempty"`
+	// ApacheModulePath is the Apache module (mod_php)
+	ApacheModulePath string `json:"apache_module_path,omitempty"`
+	// FPMConfigPath is the default configuration file of FPM, FPMPoolDir the
+	// directory of its pool configurations, and FPMListen the listen address
+	// of its first pool
+	FPMConfigPath string `json:"fpm_config_path,omitempty"`
+	FPMPoolDir    string `json:"fpm_pool_dir,omitempty"`
+	FPMListen     string `json:"fpm_listen,omitempty"`
+	// ThreadSafe is true for ZTS builds (required by FrankenPHP)
+	ThreadSafe bool `json:"thread_safe,omitempty"`
+	// DebugBuild is true for versions compiled with --enable-debug
+	DebugBuild bool `json:"debug_build,omitempty"`
+	// Arch is the CPU architecture of the PHP binary (like x86_64, arm64, or
+	// universal); Emulated is true when it does not run natively (like x86_64
+	// binaries running under Rosetta on Apple Silicon)
+	Arch     string `json:"arch,omitempty"`
+	Emulated bool   `json:"emulated,omitempty"`
+	// Compiler is the compiler of Windows builds (like vs16 or vs17), which
+	// requires the matching Visual C++ runtime
+	Compiler string `json:"compiler,omitempty"`
+	// Archs are the architectures of the slices of universal binaries (like
+	// arm64 and x86_64); the native one is preferred when running them
+	Archs []string `json:"archs,omitempty"`
+	// Variants are the variants of phpbrew builds (like fpm, debug, or zts),
+	// read from the name of their directory (like php-8.2.0+fpm+debug)
+	Variants []string `json:"variants,omitempty"`
+	// Libc is the C library Linux binaries are linked against (see Libc*
+	// constants); shared extensions must be built against the same one
+	Libc string `json:"libc,omitempty"`
+	// IniPath is the loaded php.ini, IniScanDir the directory scanned for
+	// additional .ini files, and ExtensionDir the directory of shared extensions
+	IniPath      string `json:"ini_path,omitempty"`
+	IniScanDir   string `json:"ini_scan_dir,omitempty"`
+	ExtensionDir string `json:"extension_dir,omitempty"`
+	// Xdebug is nil when Xdebug is not installed
+	Xdebug *Xdebug `json:"xdebug,omitempty"`
+	// OPcache is nil when OPcache is not installed
+	OPcache *OPcache `json:"opcache,omitempty"`
+	// ExtensionVersions are the versions of the extensions, by extension name
+	// (extensions without a version are not listed)
+	ExtensionVersions map[string]string `json:"extension_versions,omitempty"`
+	// BinaryModTime and BinarySize identify the PHP binary that was probed,
+	// to detect in-place upgrades
+	BinaryModTime time.Time `json:"binary_mtime,omitempty"`
+	BinarySize    int64     `json:"binary_size,omitempty"`
+	// DiscoveredAt is when the version was first found, and LastVerifiedAt
+	// when its binary was last probed; both are zero for versions cached
+	// before they were recorded
+	DiscoveredAt   time.Time `json:"discovered_at,omitempty"`
+	LastVerifiedAt time.Time `json:"last_verified_at,omitempty"`
+	// ActiveSupportUntil and SecuritySupportUntil are the end of the active
+	// and security support of the minor version (zero when unknown);
+	// SecurityOnly is true when only security fixes are released, and EOL
+	// when the version is not supported anymore. They depend on the current
+	// date, so they are computed when versions are loaded (see
+	// SupportWarning), and not cached.
+	ActiveSupportUntil   time.Time `json:"-"`
+	SecuritySupportUntil time.Time `json:"-"`
+	SecurityOnly         bool      `json:"-"`
+	EOL                  bool      `json:"-"`
+
+	// warnings are the startup warnings displayed by the binary when probed
+	warnings []string
+}
+
+// Xdebug describes the Xdebug installation of a PHP version
+type Xdebug struct {
+	// Enabled is false when Xdebug is installed in the extension directory
+	// but not loaded
+	Enabled bool   `json:"enabled"`
+	Version string `json:"version,omitempty"`
+	// Mode is the value of the xdebug.mode setting (Xdebug 3+), like "debug,develop"
+	Mode string `json:"mode,omitempty"`
+}
+
+// OPcache describes the OPcache installation of a PHP version
+type OPcache struct {
+	// Loaded is false when OPcache is installed in the extension directory
+	// but not loaded; other fields are only known when it is loaded
+	Loaded bool `json:"loaded"`
+	// Enabled and EnabledCLI are the opcache.enable and opcache.enable_cli settings
+	Enabled    bool `json:"enabled,omitempty"`
+	EnabledCLI bool `json:"enabled_cli,omitempty"`
+	// JIT is true when OPcache is compiled with JIT support (PHP 8+), and
+	// JITEnabled when the JIT is configured to be used
+	JIT        bool `json:"jit,omitempty"`
+	JITEnabled bool `json:"jit_enabled,omitempty"`
 }
 
 type versions []*Version
 
-func (vs versions) Len() int           { return len(vs) }
-func (vs versions) Swap(i, j int)      { vs[i], vs[j] = vs[j], vs[i] }
-func (vs versions) Less(i, j int) bool { return vs[i].FullVersion.LessThan(vs[j].FullVersion) }
+func (vs versions) Len() int      { return len(vs) }
+func (vs versions) Swap(i, j int) { vs[i], vs[j] = vs[j], vs[i] }
+func (vs versions) Less(i, j int) bool {
+	if !vs[i].FullVersion.Equal(vs[j].FullVersion) {
+		return vs[i].FullVersion.LessThan(vs[j].FullVersion)
+	}
+	// installations of the same version are sorted by source priority, then
+	// by path, so that the selected one (the last one) is always the same
+	if pi, pj := sourcePriority(vs[i].Source), sourcePriority(vs[j].Source); pi != pj {
+		return pi < pj
+	}
+	return vs[i].binary() > vs[j].binary()
+}
+
+// sourcePriority returns the priority of a discovery source when several
+// installations have the same version: explicitly registered paths first,
+// then the PATH
+func sourcePriority(source string) int {
+	switch source {
+	case "registered":
+		return 2
+	case "PATH":
+		return 1
+	}
+	return 0
+}
+
+// fullVersion returns the parsed version, parsing it on the fly when needed
+func (v *Version) fullVersion() *version.Version {
+	if v.FullVersion != nil {
+		return v.FullVersion
+	}
+	fv, err := parsePHPVersion(v.Version)
+	if err != nil {
+		return nil
+	}
+	return fv
+}
+
+// hasVersionPrefix returns true if the version starts with the segments of
+// the given prefix: 8.1 matches 8.1.2 but not 8.10.0, and 8.4.0 does not
+// match 8.4.0RC1
+func hasVersionPrefix(v, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, ".")
+	if prefix == "" || v == prefix {
+		return true
+	}
+	return strings.HasPrefix(v, prefix+".")
+}
+
+// requirementMatcher matches versions against a requirement, as done by
+// bestVersion: a constraint expression (like ^8.2 or >=8.1 <8.4), or a version
+// prefix (like 8 or 8.3). A patch version (like 8.3.12) matches exactly, and
+// falls back to its minor version; the .99 patch (like 8.3.99) stands for any
+// patch version of the minor version. The flavor suffix (like -fpm) is split
+// from the requirement.
+type requirementMatcher struct {
+	requirement string
+	flavor      string
+	// constraints of a constraint expression, or err when it is invalid
+	constraints *constraints
+	err         error
+	// prefix is the version prefix to match, and patch the exact patch
+	// version to match first, if any
+	prefix string
+	patch  string
+}
+
+func newRequirementMatcher(requirement string) *requirementMatcher {
+	requirement, flavor := splitFlavor(requirement)
+	m := &requirementMatcher{requirement: requirement, flavor: flavor, prefix: requirement}
+	if isConstraint(requirement) {
+		m.constraints, m.err = parseConstraints(requirement)
+		return m
+	}
+	pos := strings.LastIndexByte(requirement, '.')
+	if pos != strings.IndexByte(requirement, '.') {
+		if requirement[pos+1:] != "99" {
+			m.patch = requirement
+		}
+		m.prefix = requirement[:pos]
+	}
+	return m
+}
+
+// isConstraint returns true when the requirement is a constraint expression,
+// even an invalid one
+func (m *requirementMatcher) isConstraint() bool {
+	return m.constraints != nil || m.err != nil
+}
+
+// match returns whether the version matches the requirement (whatever the
+// flavor), and whether it is the exact patch version required
+func (m *requirementMatcher) match(v *Version) (bool, bool) {
+	if m.isConstraint() {
+		fv := v.fullVersion()
+		return m.err == nil && fv != nil && m.constraints.check(fv), false
+	}
+	if m.patch != "" && v.Version == m.patch {
+		return true, true
+	}
+	return hasVersionPrefix(v.Version, m.prefix), false
+}
+
+var prereleaseRegexp = regexp.MustCompile(`(?i)^(\d+\.\d+\.\d+)-?(alpha|beta|rc)(\d*)$`)
+
+// parsePHPVersion parses a PHP version, including pre-releases (like
+// 8.4.0RC2 or 8.4.0beta1), which are ordered before the final release
+// (alpha, then beta, then RC)
+func parsePHPVersion(v string) (*version.Version, error) {
+	if m := prereleaseRegexp.FindStringSubmatch(v); m != nil {
+		number := m[3]
+		if number == "" {
+			number = "0"
+		}
+		// lowercase so that rc comes after alpha and beta, and a numeric
+		// part so that rc10 comes after rc2
+		return version.NewVersion(fmt.Sprintf("%s-%s.%s", m[1], strings.ToLower(m[2]), number))
+	}
+	return version.NewVersion(v)
+}
+
+var versionSuffixRegexp = regexp.MustCompile(`(?i)^(\d+\.\d+\.\d+(?:-?(?:alpha|beta|rc)\d*)?)([^0-9A-Za-z].*)$`)
+
+// splitVersionSuffix splits a PHP version into the version itself and the
+// suffix added by distributions or development builds (like -1ubuntu1 for
+// 8.3.6-1ubuntu1, or -dev for 8.2.20-dev)
+func splitVersionSuffix(v string) (string, string) {
+	if m := versionSuffixRegexp.FindStringSubmatch(v); m != nil {
+		return m[1], m[2]
+	}
+	return v, ""
+}
+
+// splitPrerelease splits a PHP version into its release (like 8.4.0) and its
+// pre-release (like RC2)
+func splitPrerelease(v string) (string, string) {
+	if m := prereleaseRegexp.FindStringSubmatch(v); m != nil {
+		return m[1], v[len(m[1]):]
+	}
+	return v, ""
+}
+
+// binary returns the binary identifying the version: the PHP binary, or the
+// FPM one for FPM-only installations (see discoverFPMOnly)
+func (v *Version) binary() string {
+	if v.PHPPath == "" {
+		return v.FPMPath
+	}
+	return v.PHPPath
+}
+
+// stampBinary records the modification time and size of the PHP binary, and
+// when it was probed
+func (v *Version) stampBinary() {
+	if fi, err := os.Stat(v.binary()); err == nil {
+		v.BinaryModTime = fi.ModTime()
+		v.BinarySize = fi.Size()
+	}
+	v.LastVerifiedAt = time.Now()
+	if v.DiscoveredAt.IsZero() {
+		v.DiscoveredAt = v.LastVerifiedAt
+	}
+}
 
 func (v *Version) ServerPath() string {
 	switch v.serverType() {
@@ -65,6 +352,9 @@ func (v *Version) ServerPath() string {
 	case cgiServer:
 		return v.CGIPath
 
+	case lsapiServer:
+		return v.LSAPIPath
+
 	case frankenphpServer:
 		return ""
 
@@ -81,6 +371,9 @@ func (v *Version) ServerTypeName() string {
 	case cgiServer:
 		return "PHP CGI"
 
+	case lsapiServer:
+		return "PHP LSAPI"
+
 	case frankenphpServer:
 		return "FrankenPHP"
 
@@ -101,10 +394,107 @@ func (v *Version) IsCLIServer() bool {
 	return v.serverType() == cliServer
 }
 
+func (v *Version) IsLSAPIServer() bool {
+	return v.serverType() == lsapiServer
+}
+
 func (v *Version) IsFrankenPHPServer() bool {
 	return v.serverType() == frankenphpServer
 }
 
+// SupportsFlavor returns true if the version can be used with the given flavor
+// (an empty flavor is supported by all versions but FPM-only ones, which are
+// only used for the fpm flavor)
+func (v *Version) SupportsFlavor(flavor string) bool {
+	switch flavor {
+	case "", FlavorCLI:
+		return v.PHPPath != ""
+	case FlavorCGI:
+		return v.CGIPath != ""
+	case FlavorFPM:
+		return v.FPMPath != ""
+	case FlavorFrankenPHP:
+		return v.FrankenPHP
+	case FlavorLSAPI:
+		return v.LSAPIPath != ""
+	}
+	return false
+}
+
+// HasExtension returns true if the given extension (like intl or ext-intl) is
+// loaded by this version
+func (v *Version) HasExtension(name string) bool {
+	name = normalizeExtensionName(name)
+	for _, ext := range v.Extensions {
+		if normalizeExtensionName(ext) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtensionVersion returns the version of the given extension (like intl or
+// ext-intl), or an empty string when the extension is not loaded or has no
+// version
+func (v *Version) ExtensionVersion(name string) string {
+	return v.ExtensionVersions[normalizeExtensionName(name)]
+}
+
+// HasExtensions returns true if all the given extensions are loaded by this
+// version
+func (v *Version) HasExtensions(names ...string) bool {
+	for _, name := range names {
+		if !v.HasExtension(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// missingExtensions returns the extensions not provided by this version;
+// nothing is reported when the list of extensions of the version is unknown
+func (v *Version) missingExtensions(extensions []string) []string {
+	if v.Extensions == nil {
+		return nil
+	}
+	var missing []string
+	for _, ext := range extensions {
+		if !v.HasExtension(ext) {
+			missing = append(missing, ext)
+		}
+	}
+	return missing
+}
+
+// normalizeExtensionName makes Composer names (ext-zend-opcache) and PHP
+// names (Zend OPcache) comparable
+func normalizeExtensionName(name string) string {
+	name = strings.ToLower(strings.TrimPrefix(name, "ext-"))
+	return strings.NewReplacer(" ", "-", "_", "-").Replace(name)
+}
+
+// splitFlavor extracts the flavor suffix from a version requirement (8.3-fpm)
+func splitFlavor(requirement string) (string, string) {
+	requirement = strings.TrimSpace(requirement)
+	pos := strings.LastIndexByte(requirement, '-')
+	if pos == -1 {
+		return requirement, ""
+	}
+	for _, flavor := range flavors {
+		if requirement[pos+1:] == flavor {
+			return requirement[:pos], flavor
+		}
+	}
+	return requirement, ""
+}
+
+func withFlavor(requirement, flavor string) string {
+	if flavor == "" {
+		return requirement
+	}
+	return requirement + "-" + flavor
+}
+
 func (v *Version) serverType() serverType {
 	if v.FrankenPHP {
 		return frankenphpServer
@@ -115,46 +505,201 @@ func (v *Version) serverType() serverType {
 	if v.CGIPath != "" {
 		return cgiServer
 	}
+	if v.LSAPIPath != "" {
+		return lsapiServer
+	}
 
 	return cliServer
 }
 
-func (v *Version) setServer(fpm, cgi, phpconfig, phpize, phpdbg string) string {
+// setServer records the server (FPM, CGI, and LSAPI) and development
+// (php-config, phpize, and phpdbg) binaries of the installation; lookups go
+// through the filesystem cache of the discovery
+func (v *Version) setServer(fs *fsCache, fpm, cgi, lsapi, phpconfig, phpize, phpdbg string) string {
 	msg := fmt.Sprintf("  Found PHP: %s", v.PHPPath)
 	fpm = filepath.Clean(fpm)
-	if _, err := os.Stat(fpm); err == nil {
-		if fpm, err := filepath.EvalSymlinks(fpm); err == nil {
-			v.FPMPath = fpm
-			msg += fmt.Sprintf(", with FPM: %s", fpm)
+	if _, err := fs.stat(fpm); err == nil {
+		if fpm, err := fs.evalSymlinks(fpm); err == nil {
+			if cv := companionVersion(fpm); v.sameVersion(cv) {
+				v.FPMPath = fpm
+				msg += fmt.Sprintf(", with FPM: %s", fpm)
+			} else if cv == "" {
+				msg += fmt.Sprintf(", ignoring FPM %s as its version cannot be determined", fpm)
+			} else {
+				msg += fmt.Sprintf(", ignoring FPM %s as its version (%s) does not match", fpm, cv)
+			}
 		}
 	}
 	cgi = filepath.Clean(cgi)
-	if _, err := os.Stat(cgi); err == nil {
-		if cgi, err := filepath.EvalSymlinks(cgi); err == nil {
-			v.CGIPath = cgi
-			msg += fmt.Sprintf(", with CGI: %s", cgi)
+	if _, err := fs.stat(cgi); err == nil {
+		if cgi, err := fs.evalSymlinks(cgi); err == nil {
+			if cv := companionVersion(cgi); v.sameVersion(cv) {
+				v.CGIPath = cgi
+				msg += fmt.Sprintf(", with CGI: %s", cgi)
+			} else if cv == "" {
+				msg += fmt.Sprintf(", ignoring CGI %s as its version cannot be determined", cgi)
+			} else {
+				msg += fmt.Sprintf(", ignoring CGI %s as its version (%s) does not match", cgi, cv)
+			}
+		}
+	}
+	lsapi = filepath.Clean(lsapi)
+	if _, err := fs.stat(lsapi); err == nil {
+		if lsapi, err := fs.evalSymlinks(lsapi); err == nil {
+			if cv := companionVersion(lsapi); v.sameVersion(cv) {
+				v.LSAPIPath = lsapi
+				msg += fmt.Sprintf(", with LSAPI: %s", lsapi)
+			} else if cv == "" {
+				msg += fmt.Sprintf(", ignoring LSAPI %s as its version cannot be determined", lsapi)
+			} else {
+				msg += fmt.Sprintf(", ignoring LSAPI %s as its version (%s) does not match", lsapi, cv)
+			}
 		}
 	}
 	phpconfig = filepath.Clean(phpconfig)
-	if _, err := os.Stat(phpconfig); err == nil {
-		if phpconfig, err := filepath.EvalSymlinks(phpconfig); err == nil {
+	if _, err := fs.stat(phpconfig); err == nil {
+		if phpconfig, err := fs.evalSymlinks(phpconfig); err == nil {
 			v.PHPConfigPath = phpconfig
 			msg += fmt.Sprintf(", with php-config: %s", phpconfig)
 		}
 	}
 	phpize = filepath.Clean(phpize)
-	if _, err := os.Stat(phpize); err == nil {
-		if phpize, err := filepath.EvalSymlinks(phpize); err == nil {
+	if _, err := fs.stat(phpize); err == nil {
+		if phpize, err := fs.evalSymlinks(phpize); err == nil {
 			v.PHPizePath = phpize
 			msg += fmt.Sprintf(", with phpize: %s", phpize)
 		}
 	}
 	phpdbg = filepath.Clean(phpdbg)
-	if _, err := os.Stat(phpdbg); err == nil {
-		if phpdbg, err := filepath.EvalSymlinks(phpdbg); err == nil {
+	if _, err := fs.stat(phpdbg); err == nil {
+		if phpdbg, err := fs.evalSymlinks(phpdbg); err == nil {
 			v.PHPdbgPath = phpdbg
 			msg += fmt.Sprintf(", with phpdbg: %s", phpdbg)
 		}
 	}
 	return msg
 }
+
+// setInstallers records the extension installers (PECL, PEAR, and PIE) of
+// the installation
+func (v *Version) setInstallers(fs *fsCache, pecl, pear, pie string) string {
+	msg := ""
+	for _, installer := range []struct {
+		name string
+		path string
+		dest *string
+	}{
+		{"pecl", pecl, &v.PECLPath},
+		{"pear", pear, &v.PEARPath},
+		{"pie", pie, &v.PIEPath},
+	} {
+		path := filepath.Clean(installer.path)
+		if _, err := fs.stat(path); err != nil {
+			continue
+		}
+		if path, err := fs.evalSymlinks(path); err == nil {
+			*installer.dest = path
+			msg += fmt.Sprintf(", with %s: %s", installer.name, path)
+		}
+	}
+	return msg
+}
+
+// setEmbed records the embed SAPI library of the installation (like
+// lib/libphp.so or, on Debian, lib/libphp8.3.so)
+func (v *Version) setEmbed() string {
+	fv := v.fullVersion()
+	if fv == nil {
+		return ""
+	}
+	major := fmt.Sprintf("%d", fv.Segments()[0])
+	minor := fmt.Sprintf("%d.%d", fv.Segments()[0], fv.Segments()[1])
+	var candidates []string
+	if runtime.GOOS == "windows" {
+		for _, name := range []string{"php" + major + "embed.lib", "php" + major + "tsembed.lib"} {
+			candidates = append(candidates, filepath.Join(v.Path, "dev", name))
+		}
+	} else {
+		for _, lib := range []string{"lib", "lib64"} {
+			for _, name := range []string{"libphp", "libphp" + major, "libphp" + minor} {
+				candidates = append(candidates, filepath.Join(v.Path, lib, name+".so"), filepath.Join(v.Path, lib, name+".dylib"))
+			}
+		}
+	}
+	if path := firstExistingPath(candidates...); path != "" {
+		v.EmbedPath = path
+		return fmt.Sprintf(", with embed SAPI: %s", path)
+	}
+	return ""
+}
+
+// setApacheModule records the Apache module (mod_php) of the installation,
+// like lib/httpd/modules/libphp.so (Homebrew) or, on Debian,
+// lib/apache2/modules/libphp8.3.so
+func (v *Version) setApacheModule() string {
+	fv := v.fullVersion()
+	if fv == nil {
+		return ""
+	}
+	major := fmt.Sprintf("%d", fv.Segments()[0])
+	minor := fmt.Sprintf("%d.%d", fv.Segments()[0], fv.Segments()[1])
+	var candidates []string
+	if runtime.GOOS == "windows" {
+		candidates = append(candidates, filepath.Join(v.Path, "php"+major+"apache2_4.dll"))
+	} else {
+		for _, modules := range []string{"lib/httpd/modules", "lib64/httpd/modules", "lib/apache2/modules", "libexec/apache2"} {
+			for _, name := range []string{"libphp" + minor + ".so", "libphp" + major + ".so", "libphp.so"} {
+				candidates = append(candidates, filepath.Join(v.Path, filepath.FromSlash(modules), name))
+			}
+		}
+	}
+	if path := firstExistingPath(candidates...); path != "" {
+		v.ApacheModulePath = path
+		return fmt.Sprintf(", with Apache module: %s", path)
+	}
+	return ""
+}
+
+// firstExistingPath returns the first existing path (with symlinks
+// resolved), or an empty string
+func firstExistingPath(paths ...string) string {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if path, err := filepath.EvalSymlinks(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// companionVersion returns the version of a companion binary (like php-fpm or
+// php-cgi), or an empty string when it cannot be determined; the binary is
+// run rather than scanned as it might be a wrapper (like for containers)
+func companionVersion(path string) string {
+	cmd, cancel := probeCommand(path, "-v")
+	defer cancel()
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	version, _, _ := parseVersionBanner(out)
+	version, _ = splitVersionSuffix(version)
+	return version
+}
+
+// sameVersion returns true if the given version (like the version of a
+// companion binary) is the same as this one; unknown versions are not
+// considered the same
+func (v *Version) sameVersion(other string) bool {
+	if other == "" {
+		return false
+	}
+	ov, err := parsePHPVersion(other)
+	fv := v.fullVersion()
+	if err != nil || fv == nil {
+		return false
+	}
+	return ov.Equal(fv)
+}
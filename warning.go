@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WarningKind identifies the reason of a Warning
+type WarningKind string
+
+const (
+	// WarningPatchFallback means that the required patch version is not
+	// available and that another patch version of the same minor was used
+	WarningPatchFallback WarningKind = "patch_fallback"
+	// WarningVersionNotAvailable means that the required version is not available
+	WarningVersionNotAvailable WarningKind = "version_not_available"
+	// WarningConstraintNotSatisfied means that no version satisfies the required constraint
+	WarningConstraintNotSatisfied WarningKind = "constraint_not_satisfied"
+	// WarningInvalidConstraint means that the required constraint cannot be parsed
+	WarningInvalidConstraint WarningKind = "invalid_constraint"
+	// WarningFlavorNotAvailable means that no version matching the requirement supports the required flavor
+	WarningFlavorNotAvailable WarningKind = "flavor_not_available"
+	// WarningMissingExtensions means that the version does not provide all the extensions required by composer.json
+	WarningMissingExtensions WarningKind = "missing_extensions"
+	// WarningOutdatedPatch means that a more recent patch version of the same minor version was released (see WithOutdatedPatchCheck)
+	WarningOutdatedPatch WarningKind = "outdated_patch"
+)
+
+// Warning describes why the version found for a directory might not be the expected one
+type Warning struct {
+	Kind WarningKind
+	// Requested is the requirement (like 8.1.2, ^8.2 or 8.3-fpm)
+	Requested string
+	// Matched is the version used instead, if any
+	Matched string
+	// Source describes where the requirement comes from
+	Source string
+	// Extensions lists the missing extensions (WarningMissingExtensions)
+	Extensions []string
+	// Latest is the latest release of the same minor version (WarningOutdatedPatch)
+	Latest string
+	// Suggestion is how to install a version satisfying the requirement
+	// (WarningVersionNotAvailable and WarningConstraintNotSatisfied), if any
+	Suggestion *Installable
+	// Err is the parsing error (WarningInvalidConstraint)
+	Err error
+}
+
+func (w *Warning) String() string {
+	switch w.Kind {
+	case WarningPatchFallback:
+		requested, _ := splitFlavor(w.Requested)
+		return fmt.Sprintf(`the current dir requires PHP %s (%s), but this version is not available: fallback to %s`, w.Requested, w.Source, requested[:strings.LastIndexByte(requested, '.')])
+	case WarningConstraintNotSatisfied:
+		return fmt.Sprintf(`the current dir requires PHP %s (%s), but no installed version satisfies this constraint`, w.Requested, w.Source) + w.suggestion()
+	case WarningInvalidConstraint:
+		return fmt.Sprintf(`the current dir requires PHP %s (%s), but the constraint cannot be parsed: %s`, w.Requested, w.Source, w.Err)
+	case WarningFlavorNotAvailable:
+		_, flavor := splitFlavor(w.Requested)
+		return fmt.Sprintf(`the current dir requires PHP %s (%s), but no matching version supports the "%s" flavor`, w.Requested, w.Source, flavor)
+	case WarningOutdatedPatch:
+		return fmt.Sprintf(`PHP %s is outdated, the latest patch version is %s`, w.Matched, w.Latest)
+	case WarningMissingExtensions:
+		return fmt.Sprintf(`PHP %s does not provide the following extensions required by composer.json: %s`, w.Matched, strings.Join(w.Extensions, ", "))
+	default:
+		return fmt.Sprintf(`the current dir requires PHP %s (%s), but this version is not available`, w.Requested, w.Source) + w.suggestion()
+	}
+}
+
+func (w *Warning) suggestion() string {
+	if w.Suggestion == nil {
+		return ""
+	}
+	return ": " + w.Suggestion.String()
+}
+
+// Warnings is a list of warnings
+type Warnings []*Warning
+
+func (ws Warnings) String() string {
+	messages := make([]string, len(ws))
+	for i, w := range ws {
+		messages[i] = w.String()
+	}
+	return strings.Join(messages, "; ")
+}
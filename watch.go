@@ -0,0 +1,249 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch watches the directories where PHP versions are installed (like the
+// Homebrew Cellar, the phpenv versions directory, or the PATH) and updates
+// the store and the cache when versions are installed or removed, until the
+// context is done. onChange, when not nil, is called with the new versions
+// after each update (Changes returns what changed, like "New PHP 8.4.1
+// detected (Homebrew)").
+//
+// Directories are watched with file system notifications; in the directories
+// of the PATH, only changes to PHP binaries (like php, php-fpm8.3, or php-cgi)
+// are taken into account. Notifications are coalesced and handled every
+// interval, which is also how often directories that cannot be watched (like
+// the ones that do not exist yet) are checked.
+//
+// Watch is meant for long-running processes (like a local web server) and
+// should be run in its own goroutine.
+func (s *PHPStore) Watch(ctx context.Context, interval time.Duration, onChange func([]*Version)) {
+	s.load()
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		s.log("Unable to watch PHP installations, checking them every %s instead: %s", interval, err)
+	} else {
+		defer watcher.Close()
+	}
+	var events chan fsnotify.Event
+	var errs chan error
+	if watcher != nil {
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	inPath := pathDirSet()
+	roots := s.watchedRoots()
+	polled := watchDirs(watcher, roots)
+	state := fingerprintDirs(polled, inPath)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	changed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+			} else if isRelevantEvent(event, inPath) {
+				changed = true
+			}
+			continue
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+			} else {
+				s.log("Error while watching PHP installations: %s", err)
+			}
+			continue
+		case <-ticker.C:
+		}
+		if !changed {
+			if current := fingerprintDirs(polled, inPath); current == state {
+				continue
+			}
+		}
+		changed = false
+		s.log("PHP installations changed, discovering versions again")
+		fresh := s.newDiscoveryStore()
+		fresh.discover()
+
+		s.mu.Lock()
+		keepDiscoveryTimes(fresh.versions, s.versions)
+		previous := s.versions
+		s.setVersions(fresh.versions)
+		s.changes = DiffVersions(previous, s.versions)
+		s.roots = fresh.roots
+		s.problems = fresh.problems
+		s.report = fresh.report
+		s.writeCache()
+		vs := s.versions
+		s.mu.Unlock()
+
+		if watcher != nil {
+			for _, dir := range watcher.WatchList() {
+				watcher.Remove(dir)
+			}
+		}
+		inPath = pathDirSet()
+		roots = s.watchedRoots()
+		polled = watchDirs(watcher, roots)
+		state = fingerprintDirs(polled, inPath)
+		if onChange != nil {
+			onChange(vs)
+		}
+	}
+}
+
+// watchDirs adds the given directories to the watcher and returns the ones
+// that cannot be watched and must be polled instead (all of them when there
+// is no watcher)
+func watchDirs(watcher *fsnotify.Watcher, dirs []string) []string {
+	if watcher == nil {
+		return dirs
+	}
+	var polled []string
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			polled = append(polled, dir)
+		}
+	}
+	return polled
+}
+
+// isRelevantEvent returns whether a file system event might change the
+// discovered versions: permission changes are ignored, and so are changes to
+// files other than PHP binaries in the directories of the PATH
+func isRelevantEvent(event fsnotify.Event, inPath map[string]bool) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	if inPath[filepath.Dir(event.Name)] {
+		return isPHPBinaryName(filepath.Base(event.Name))
+	}
+	return true
+}
+
+// pathDirSet returns the directories of the PATH
+func pathDirSet() map[string]bool {
+	dirs := map[string]bool{}
+	for _, dir := range pathDirs() {
+		dirs[dir] = true
+	}
+	return dirs
+}
+
+// pathDirs returns the directories of the PATH, with Windows variables
+// expanded
+func pathDirs() []string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(pathEnv()) {
+		if runtime.GOOS == "windows" {
+			dir = expandWindowsVars(dir)
+		}
+		dirs = append(dirs, filepath.Clean(dir))
+	}
+	return dirs
+}
+
+// watchedRoots returns the directories to watch for changes: the ones scanned
+// by the last discovery and the ones of known versions
+func (s *PHPStore) watchedRoots() []string {
+	s.mu.RLock()
+	roots := append([]string(nil), s.roots...)
+	var dry *PHPStore
+	if s.roots == nil {
+		// versions were loaded from the cache, list the directories that
+		// would be scanned, without probing binaries nor running version
+		// managers (see dryRun); their directories are derived from the
+		// known versions instead
+		dry = s.newDiscoveryStore()
+		dry.dryRun = true
+		for _, path := range s.registeredPaths {
+			roots = appendPath(roots, path)
+		}
+		for _, v := range s.versions {
+			// like the phpenv versions directory, or the Homebrew formula
+			roots = appendPath(roots, filepath.Dir(v.Path))
+			if v.Source == "homebrew" {
+				// the Homebrew Cellar, for new formulas
+				roots = appendPath(roots, filepath.Dir(filepath.Dir(v.Path)))
+			}
+		}
+	}
+	// directories of the PATH might not exist yet
+	for _, dir := range pathDirs() {
+		roots = appendPath(roots, dir)
+	}
+	for _, v := range s.versions {
+		roots = appendPath(roots, v.Path)
+	}
+	s.mu.RUnlock()
+
+	if dry != nil {
+		dry.doDiscover()
+		for _, root := range dry.roots {
+			roots = appendPath(roots, root)
+		}
+	}
+	return roots
+}
+
+// fingerprintDirs returns a summary of the contents of the given directories
+// that changes when entries are added to or removed from them; in the
+// directories of the PATH, only PHP binaries are taken into account
+func fingerprintDirs(dirs []string, inPath map[string]bool) string {
+	var b strings.Builder
+	for _, dir := range dirs {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			fmt.Fprintf(&b, "%s: missing\n", dir)
+			continue
+		}
+		if inPath[dir] {
+			// the modification time changes with any entry
+			b.WriteString(dir + ":")
+		} else {
+			fmt.Fprintf(&b, "%s: %d", dir, fi.ModTime().UnixNano())
+		}
+		if entries, err := os.ReadDir(dir); err == nil {
+			for _, entry := range entries {
+				if !inPath[dir] || isPHPBinaryName(entry.Name()) {
+					b.WriteString(" " + entry.Name())
+				}
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"regexp"
+	"strings"
+)
+
+// like php-8.3.8-nts-Win32-vs16-x64 or php-8.4.0RC1-Win32-vs17-x86 (thread
+// safe builds have no nts part)
+var windowsBuildRegexp = regexp.MustCompile(`(?i)^php-(\d+\.\d+\.\d+(?:(?:alpha|beta|RC)\d+)?)(-nts)?-Win32-(v[cs]\d+)-(x64|x86|arm64)$`)
+
+// windowsBuild is the metadata of an official Windows build
+type windowsBuild struct {
+	version    string
+	threadSafe bool
+	compiler   string
+	arch       string
+}
+
+// parseWindowsBuildName returns the metadata of an official Windows build
+// from the name of its directory, or nil when it is not informative (like
+// C:\php)
+func parseWindowsBuildName(name string) *windowsBuild {
+	m := windowsBuildRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return nil
+	}
+	return &windowsBuild{
+		version:    m[1],
+		threadSafe: m[2] == "",
+		compiler:   strings.ToLower(m[3]),
+		arch:       normalizeArch(m[4]),
+	}
+}
+
+// compilers of Windows builds, by the name displayed in the version banner
+var bannerCompilers = map[string]string{
+	"Visual C++ 2017": "vc15",
+	"Visual C++ 2019": "vs16",
+	"Visual C++ 2022": "vs17",
+}
+
+// bannerCompiler returns the compiler of a Windows build from its version
+// banner (like "(ZTS Visual C++ 2019 x64)"), or an empty string
+func bannerCompiler(banner string) string {
+	for name, compiler := range bannerCompilers {
+		if strings.Contains(banner, name) {
+			return compiler
+		}
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2021-present Fabien Potencier <fabien@symfony.com>
+ *
+ * This file is part of Symfony CLI project
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package phpstore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultWorkspaceMarkers are the files or directories marking the root of a
+// workspace (see WithWorkspaceResolution)
+var DefaultWorkspaceMarkers = []string{".git"}
+
+// WithWorkspaceResolution makes BestVersionForDir read all the composer.json
+// files from the directory up to the root of the workspace (like a monorepo),
+// instead of the first one only. The root is the first directory containing
+// one of the given markers (DefaultWorkspaceMarkers when none are given).
+// The nearest config.platform.php wins, require.php constraints are all
+// applied, and ext-* requirements are merged.
+func WithWorkspaceResolution(markers ...string) Option {
+	return func(s *PHPStore) {
+		if len(markers) == 0 {
+			markers = DefaultWorkspaceMarkers
+		}
+		s.workspaceMarkers = markers
+	}
+}
+
+// composerManifest is a parsed Composer manifest and its path
+type composerManifest struct {
+	json *composerJSON
+	file string
+}
+
+// composerManifestsForDir returns the Composer manifests of the given
+// directory and up, the nearest first: the first one only, unless workspace
+// resolution is enabled (see WithWorkspaceResolution)
+func (s *PHPStore) composerManifestsForDir(dir string) []composerManifest {
+	if s.workspaceMarkers == nil || filepath.IsAbs(composerFile()) {
+		if composerJson, file := s.composerJSONForDir(dir); composerJson != nil {
+			return []composerManifest{{composerJson, file}}
+		}
+		return nil
+	}
+	var manifests []composerManifest
+	for {
+		file := filepath.Join(dir, composerFile())
+		var composerJson composerJSON
+		if contents := s.readVersion(file); contents != nil && json.Unmarshal(contents, &composerJson) == nil {
+			manifests = append(manifests, composerManifest{&composerJson, file})
+		}
+		if s.isWorkspaceRoot(dir) {
+			break
+		}
+		upDir := filepath.Dir(dir)
+		if upDir == dir || upDir == "." {
+			break
+		}
+		dir = upDir
+	}
+	return manifests
+}
+
+// isWorkspaceRoot returns true if dir contains one of the workspace markers
+func (s *PHPStore) isWorkspaceRoot(dir string) bool {
+	for _, marker := range s.workspaceMarkers {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeConstraints returns a constraint satisfied by versions satisfying both
+// constraints; as AND has precedence over OR, each alternative of the first
+// one is combined with each alternative of the second one
+func mergeConstraints(a, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" || a == b {
+		return a
+	}
+	var merged []string
+	for _, x := range strings.Split(strings.ReplaceAll(a, "||", "|"), "|") {
+		for _, y := range strings.Split(strings.ReplaceAll(b, "||", "|"), "|") {
+			merged = append(merged, strings.TrimSpace(x)+" "+strings.TrimSpace(y))
+		}
+	}
+	return strings.Join(merged, " || ")
+}